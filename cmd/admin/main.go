@@ -1,20 +1,55 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 
 	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/config"
+	"github.com/etitcombe/tiddlypom/db"
 	"github.com/etitcombe/tiddlypom/rand"
+	"github.com/etitcombe/tiddlypom/tiddlywiki"
 	"golang.org/x/crypto/bcrypt"
 )
 
 /*
-How to generate a users.gob file for the web application. Currently only one
-user is supported.
+How to manage the users of the web application, or of one of its other wikis
+with -wiki=team. Run it from the folder where the web application runs from.
+Passwords are asked for rather than given as flags, so that they don't end up
+in the shell's history; piped into stdin works too.
+
+> ./admin -cmd=user add [-role=reader|writer|admin] user@site.com
+> ./admin -cmd=user list
+> ./admin -cmd=user passwd user@site.com
+> ./admin -cmd=user role user@site.com reader
+> ./admin -cmd=user sessions [-revoke=ID|all] user@site.com
+> ./admin -cmd=user remove user@site.com
+> ./admin -cmd=user 2fa-enroll user@site.com
+> ./admin -cmd=user 2fa-disable user@site.com
+
+How to give a script its own API token to act as a user with, which it sends in
+an Authorization: Bearer header. The token is only shown when it is created.
+
+> ./admin -cmd=token create -name=ci [-scope=read|write] [-expires=720h] user@site.com
+> ./admin -cmd=token list user@site.com
+> ./admin -cmd=token revoke user@site.com ID
+
+How to serve more wikis from the same web application, each at /w/{name}/ and
+at / for its hosts. A new wiki's files go in wikis/{name}. Restart the web
+application after making changes.
+
+> ./admin -cmd=wiki create [-hosts=team.example.com] [-index=index.html] [-driver=sqlite3|files|postgres] [-dsn=...] [-email=me@example.com] team
+> ./admin -cmd=wiki list
+> ./admin -cmd=wiki remove [-purge] team
+
+How to generate a users.gob file for the web application by hand. It replaces
+any users.gob that is there, and the web application moves its user into the
+database when it starts.
 
 1. > ./admin -cmd=pepper
 CPjaot8hYLXpm4xIaXHWsQKJWkelY3msP6AbR8wYmrE=
@@ -22,6 +57,19 @@ CPjaot8hYLXpm4xIaXHWsQKJWkelY3msP6AbR8wYmrE=
 $2a$10$r1sE9VECMqhjaikC2z5/iOaSwCDGlVOe4PLwDjJzKLT7iY1QDkF3.
 3. > ./admin -cmd=userfile -email=user@site.com -hashedPassword=$2a$10$r1sE9VECMqhjaikC2z5/iOaSwCDGlVOe4PLwDjJzKLT7iY1QDkF3.
 [this produces users.gob which can now be copied to the folder where the web application runs from]
+
+How to move the tiddlers of a single-file wiki into tiddlypom. Run it from the
+folder where the web application runs from, so that .config and the database
+migrations are found.
+
+> ./admin -cmd=import-html -file=~/wikis/notes.html [-bag=default] [-wiki=team]
+
+How to export the tiddlers of a recipe, or a bag, for a backup or to hand them
+on: as a standalone single-file wiki (html), a JSON file that any TiddlyWiki can
+import (json), or a Node.js TiddlyWiki tiddlers folder (zip or tar). Also run it
+from the folder where the web application runs from.
+
+> ./admin -cmd=export -format=zip -out=backup.zip [-recipe=default | -bag=default] [-tag=Journal] [-system=only|exclude] [-since=2021-01-31] [-wiki=team]
 */
 
 func main() {
@@ -31,12 +79,30 @@ func main() {
 		password       string
 		email          string
 		hashedPassword string
+		file           string
+		bag            string
+		recipe         string
+		wiki           string
+		format         string
+		out            string
+		tag            string
+		system         string
+		since          string
 	)
-	flag.StringVar(&cmd, "cmd", "", "The command to execute: pepper, password, userfile. [Required]")
+	flag.StringVar(&cmd, "cmd", "", "The command to execute: pepper, password, userfile, user, token, wiki, import-html, export. [Required]")
 	flag.StringVar(&pepper, "pepper", "", "The pepper to use when hashing a password. [Required when cmd=password]")
 	flag.StringVar(&password, "password", "", "The password to hash. [Required when cmd=password]")
 	flag.StringVar(&email, "email", "", "The email to user for the user. [Required when cmd=userfile]")
 	flag.StringVar(&hashedPassword, "hashedPassword", "", "The hashed password to use for the user. [Required when cmd=userfile]")
+	flag.StringVar(&file, "file", "", "The TiddlyWiki HTML file to import. [Required when cmd=import-html]")
+	flag.StringVar(&bag, "bag", "", "The bag to import the tiddlers into, default when not given, or to export instead of a recipe.")
+	flag.StringVar(&recipe, "recipe", app.DefaultRecipe, "The recipe to export.")
+	flag.StringVar(&wiki, "wiki", "", "The wiki to manage, import into or export from, when it isn't the main one.")
+	flag.StringVar(&format, "format", tiddlywiki.FormatHTML, "The format to export in: html, json, tar or zip.")
+	flag.StringVar(&out, "out", "", "The file to export to. [Default: stdout]")
+	flag.StringVar(&tag, "tag", "", "Only export the tiddlers with this tag.")
+	flag.StringVar(&system, "system", "", "Export only the system tiddlers (only) or leave them out (exclude).")
+	flag.StringVar(&since, "since", "", "Only export the tiddlers modified on or after this date, YYYY-MM-DD.")
 	flag.Parse()
 
 	switch cmd {
@@ -54,6 +120,33 @@ func main() {
 			return
 		}
 		saveUsers(email, hashedPassword)
+	case "user":
+		if err := userCommand(wiki, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+	case "token":
+		if err := tokenCommand(wiki, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+	case "wiki":
+		if err := wikiCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+	case "import-html":
+		if file == "" {
+			flag.Usage()
+			return
+		}
+		if bag == "" {
+			bag = app.DefaultBag
+		}
+		importHTML(file, bag, wiki)
+	case "export":
+		filter, err := tiddlywiki.NewExportFilter(tag, system, since)
+		if err != nil {
+			log.Fatal(err)
+		}
+		export(wiki, recipe, bag, format, out, filter)
 	default:
 		flag.Usage()
 	}
@@ -75,12 +168,17 @@ func hashPassword(pepper, password string) {
 	fmt.Println(string(hashedBytes))
 }
 
+// saveUsers writes a users.gob with just the one user in it. As with the web
+// application's own, only its owner may read it.
 func saveUsers(email, hashedPassword string) {
-	f, err := os.OpenFile("users.gob", os.O_CREATE|os.O_RDWR, 0644)
+	f, err := os.OpenFile("users.gob", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		log.Fatal(err)
+	}
 
 	users := []app.User{
 		{Email: email, PasswordHash: hashedPassword},
@@ -91,3 +189,85 @@ func saveUsers(email, hashedPassword string) {
 		log.Fatal(err)
 	}
 }
+
+func importHTML(file, bag, wiki string) {
+	page, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tiddlers, err := tiddlywiki.Tiddlers(page)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ts, _ := openStore(wiki)
+	defer ts.Close()
+
+	ctx := context.Background()
+	if _, err := ts.Bag(ctx, bag); err != nil {
+		log.Fatalf("bag %s: %v", bag, err)
+	}
+	saved, err := tiddlywiki.Import(ctx, ts, bag, tiddlers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("imported %d of the %d tiddlers in %s into bag %s\n", len(saved), len(tiddlers), file, bag)
+}
+
+func export(wiki, recipe, bag, format, out string, filter tiddlywiki.ExportFilter) {
+	ts, w := openStore(wiki)
+	defer ts.Close()
+
+	ctx := context.Background()
+	bags := []string{bag}
+	if bag != "" {
+		if _, err := ts.Bag(ctx, bag); err != nil {
+			log.Fatalf("bag %s: %v", bag, err)
+		}
+	} else {
+		r, err := ts.Recipe(ctx, recipe)
+		if err != nil {
+			log.Fatalf("recipe %s: %v", recipe, err)
+		}
+		bags = r.Bags
+	}
+
+	tiddlers, err := tiddlywiki.ExportTiddlers(ctx, ts, bags, filter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f := os.Stdout
+	if out != "" {
+		if f, err = os.Create(out); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := tiddlywiki.Export(f, format, tiddlers, w.Index); err != nil {
+		log.Fatal(err)
+	}
+	if out != "" {
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("exported %d tiddlers to %s\n", len(tiddlers), out)
+	}
+}
+
+// openStore opens the store of the wiki, or of the main wiki when wiki is
+// empty, as the web application would.
+func openStore(wiki string) (db.Store, config.Wiki) {
+	c, err := config.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	w, err := config.FindWiki(wiki, c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ts, err := db.OpenTiddlyStore(w.Database, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return ts, w
+}
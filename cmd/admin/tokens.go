@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+// tokenCommand manages the API tokens that scripts use to act as a user.
+//
+//	-cmd=token create -name NAME [-scope read|write] [-expires 720h] EMAIL
+//	-cmd=token list EMAIL
+//	-cmd=token revoke EMAIL ID
+func tokenCommand(wiki string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: -cmd=token create|list|revoke")
+	}
+
+	ts, us, err := openUserStore(wiki)
+	if err != nil {
+		return err
+	}
+	defer ts.Close()
+	defer us.Close()
+
+	switch args[0] {
+	case "create":
+		return createToken(us, args[1:])
+	case "list":
+		return listTokens(us, args[1:])
+	case "revoke":
+		return revokeToken(us, args[1:])
+	default:
+		return fmt.Errorf("unknown token command %q", args[0])
+	}
+}
+
+// createToken creates a token and prints it, the only time that it is shown.
+func createToken(us app.UserStore, args []string) error {
+	fs := flag.NewFlagSet("token create", flag.ContinueOnError)
+	name := fs.String("name", "", "what the token is for")
+	scope := fs.String("scope", app.ScopeRead, "what the token may do: read or write")
+	expires := fs.Duration("expires", 0, "how long until the token expires, such as 720h; never when not given")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	email, err := emailArg("token create", fs.Args())
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("a token needs a -name")
+	}
+	if !app.ValidScope(*scope) {
+		return fmt.Errorf("unknown scope %q", *scope)
+	}
+	if *expires < 0 {
+		return errors.New("-expires can't be negative")
+	}
+
+	t := app.APIToken{Email: email, Name: *name, Scope: *scope}
+	if *expires > 0 {
+		t.Expires = time.Now().UTC().Add(*expires).Truncate(time.Second)
+	}
+	token, err := us.CreateAPIToken(&t)
+	if err != nil {
+		return fmt.Errorf("user %s: %w", email, err)
+	}
+	fmt.Fprintf(os.Stderr, "created %s token %s for %s; it won't be shown again\n", t.Scope, t.ID, t.Email)
+	fmt.Println(token)
+	return nil
+}
+
+func listTokens(us app.UserStore, args []string) error {
+	email, err := emailArg("token list", args)
+	if err != nil {
+		return err
+	}
+	if _, err := us.ByEmail(email); err != nil {
+		return fmt.Errorf("user %s: %w", email, err)
+	}
+	tokens, err := us.APITokens(email)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSCOPE\tCREATED\tEXPIRES")
+	for _, t := range tokens {
+		expires := "never"
+		if t.Expired(now) {
+			expires = "expired"
+		} else if !t.Expires.IsZero() {
+			expires = t.Expires.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, t.Scope, t.Created.Local().Format(time.RFC3339), expires)
+	}
+	return tw.Flush()
+}
+
+func revokeToken(us app.UserStore, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: -cmd=token revoke EMAIL ID")
+	}
+	email, id := args[0], args[1]
+	if err := us.DeleteAPIToken(email, id); err != nil {
+		return fmt.Errorf("token %s of %s: %w", id, email, err)
+	}
+	fmt.Printf("revoked token %s of %s\n", id, email)
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/config"
+	"github.com/etitcombe/tiddlypom/db"
+	"github.com/etitcombe/tiddlypom/prompt"
+	"github.com/etitcombe/tiddlypom/totp"
+)
+
+// totpIssuer is what authenticator apps show the codes for a wiki as.
+const totpIssuer = "tiddlypom"
+
+// userCommand manages the users of a wiki, in the same store that the web
+// application uses.
+//
+//	-cmd=user add [-role reader|writer|admin] EMAIL
+//	-cmd=user list
+//	-cmd=user remove EMAIL
+//	-cmd=user passwd EMAIL
+//	-cmd=user role EMAIL reader|writer|admin
+//	-cmd=user sessions [-revoke ID|all] EMAIL
+//	-cmd=user 2fa-enroll EMAIL
+//	-cmd=user 2fa-disable EMAIL
+func userCommand(wiki string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: -cmd=user add|list|remove|passwd|role|sessions|2fa-enroll|2fa-disable")
+	}
+
+	ts, us, err := openUserStore(wiki)
+	if err != nil {
+		return err
+	}
+	defer ts.Close()
+	defer us.Close()
+
+	switch args[0] {
+	case "add":
+		return addUser(us, args[1:])
+	case "list":
+		return listUsers(us)
+	case "remove":
+		return removeUser(us, args[1:])
+	case "passwd":
+		return changePassword(us, args[1:])
+	case "role":
+		return setRole(us, args[1:])
+	case "sessions":
+		return userSessions(us, args[1:])
+	case "2fa-enroll":
+		return enrollTwoFactor(us, args[1:])
+	case "2fa-disable":
+		return disableTwoFactor(us, args[1:])
+	default:
+		return fmt.Errorf("unknown user command %q", args[0])
+	}
+}
+
+func addUser(us app.UserStore, args []string) error {
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	role := fs.String("role", app.RoleWriter, "what the user may do: reader, writer or admin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	email, err := emailArg("user add", fs.Args())
+	if err != nil {
+		return err
+	}
+	if !app.ValidRole(*role) {
+		return fmt.Errorf("unknown role %q", *role)
+	}
+	if _, err := us.ByEmail(email); err == nil {
+		return fmt.Errorf("user %s already exists", email)
+	}
+
+	password, err := prompt.Password(email, true)
+	if err != nil {
+		return err
+	}
+	if err := us.Create(&app.User{Email: email, Role: *role}, password); err != nil {
+		return err
+	}
+	fmt.Printf("added user %s as %s\n", email, *role)
+	return nil
+}
+
+func listUsers(us app.UserStore) error {
+	users, err := us.List()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "EMAIL\tROLE\t2FA\tSESSIONS")
+	for _, u := range users {
+		sessions, err := us.Sessions(u.Email)
+		if err != nil {
+			return err
+		}
+		twoFactor := "off"
+		if u.TOTPSecret != "" {
+			twoFactor = fmt.Sprintf("on, %d recovery codes", len(u.RecoveryCodeHashes))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", u.Email, u.Role, twoFactor, len(sessions))
+	}
+	return tw.Flush()
+}
+
+func removeUser(us app.UserStore, args []string) error {
+	email, err := emailArg("user remove", args)
+	if err != nil {
+		return err
+	}
+	if err := us.Delete(email); err != nil {
+		return fmt.Errorf("user %s: %w", email, err)
+	}
+	fmt.Printf("removed user %s\n", email)
+	return nil
+}
+
+// changePassword sets a new password and, since the old one may be known to
+// someone else, logs the user out everywhere.
+func changePassword(us app.UserStore, args []string) error {
+	email, err := emailArg("user passwd", args)
+	if err != nil {
+		return err
+	}
+	if _, err := us.ByEmail(email); err != nil {
+		return fmt.Errorf("user %s: %w", email, err)
+	}
+
+	password, err := prompt.Password(email, true)
+	if err != nil {
+		return err
+	}
+	if err := us.SetPassword(email, password); err != nil {
+		return err
+	}
+	fmt.Printf("changed the password of %s and logged them out everywhere\n", email)
+	return nil
+}
+
+func setRole(us app.UserStore, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: -cmd=user role EMAIL reader|writer|admin")
+	}
+	email, role := args[0], args[1]
+	if err := us.SetRole(email, role); err != nil {
+		return fmt.Errorf("user %s: %w", email, err)
+	}
+	fmt.Printf("%s is now a %s\n", email, role)
+	return nil
+}
+
+// userSessions lists the sessions of a user, or revokes one of them or all of
+// them.
+func userSessions(us app.UserStore, args []string) error {
+	fs := flag.NewFlagSet("user sessions", flag.ContinueOnError)
+	revoke := fs.String("revoke", "", "the ID of the session to log out, or all")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	email, err := emailArg("user sessions", fs.Args())
+	if err != nil {
+		return err
+	}
+	if _, err := us.ByEmail(email); err != nil {
+		return fmt.Errorf("user %s: %w", email, err)
+	}
+
+	switch *revoke {
+	case "":
+		sessions, err := us.Sessions(email)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tCREATED\tLAST SEEN\tIP\tUSER AGENT")
+		for _, t := range sessions {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.Created.Local().Format(time.RFC3339),
+				t.LastSeen.Local().Format(time.RFC3339), orUnknown(t.IP), orUnknown(t.UserAgent))
+		}
+		return tw.Flush()
+	case "all":
+		if err := us.ClearRememberTokens(email); err != nil {
+			return err
+		}
+		fmt.Printf("revoked all of the sessions of %s\n", email)
+		return nil
+	}
+
+	if err := us.DeleteSession(email, *revoke); err != nil {
+		return fmt.Errorf("session %s of %s: %w", *revoke, email, err)
+	}
+	fmt.Printf("revoked session %s of %s\n", *revoke, email)
+	return nil
+}
+
+// enrollTwoFactor gives a user a new TOTP secret, shown as an otpauth URI for
+// their authenticator app, and a new set of recovery codes. It only takes
+// effect once a code from the app shows that the app was set up correctly.
+func enrollTwoFactor(us app.UserStore, args []string) error {
+	email, err := emailArg("user 2fa-enroll", args)
+	if err != nil {
+		return err
+	}
+	u, err := us.ByEmail(email)
+	if err != nil {
+		return fmt.Errorf("user %s: %w", email, err)
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Add this to the authenticator app of %s, as a QR code or by hand:\n\n%s\n\n",
+		u.Email, totp.URI(totpIssuer, u.Email, secret))
+	code, err := prompt.Line("Code from the app: ")
+	if err != nil {
+		return err
+	}
+	if _, ok := totp.Validate(secret, code, time.Now()); !ok {
+		return errors.New("the code is not valid, so two-factor authentication was not turned on")
+	}
+
+	codes, err := totp.RecoveryCodes()
+	if err != nil {
+		return err
+	}
+	if err := us.SetTOTP(u.Email, secret, codes); err != nil {
+		return err
+	}
+	fmt.Printf("\nTurned on two-factor authentication for %s. Keep these recovery codes\n", u.Email)
+	fmt.Println("somewhere safe. Each one can be used once instead of a code from the app:")
+	fmt.Println()
+	for _, c := range codes {
+		fmt.Println("   ", c)
+	}
+	return nil
+}
+
+func disableTwoFactor(us app.UserStore, args []string) error {
+	email, err := emailArg("user 2fa-disable", args)
+	if err != nil {
+		return err
+	}
+	if err := us.SetTOTP(email, "", nil); err != nil {
+		return fmt.Errorf("user %s: %w", email, err)
+	}
+	fmt.Printf("turned off two-factor authentication for %s\n", email)
+	return nil
+}
+
+// orUnknown is for what a session from before it was recorded doesn't have.
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// emailArg returns the single email address that a command is given.
+func emailArg(name string, args []string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", fmt.Errorf("usage: -cmd=%s EMAIL", name)
+	}
+	return args[0], nil
+}
+
+// openUserStore opens the store of the users of the wiki, or of the main wiki
+// when wiki is empty, along with the store it is kept in.
+func openUserStore(wiki string) (db.Store, app.UserStore, error) {
+	c, err := config.LoadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	ts, w := openStore(wiki)
+	us, err := db.OpenUserStore(ts, w.Dir, c.Pepper)
+	if err != nil {
+		ts.Close()
+		return nil, nil, err
+	}
+	return ts, us, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/config"
+	"github.com/etitcombe/tiddlypom/db"
+	"github.com/etitcombe/tiddlypom/prompt"
+)
+
+// wikisDir is the folder that new wikis are created in.
+const wikisDir = "wikis"
+
+// wikiCommand manages the registry of wikis that the web application serves
+// alongside the main one.
+//
+//	-cmd=wiki create [-hosts a,b] [-index file] [-driver d] [-dsn dsn] [-email address] NAME
+//	-cmd=wiki list
+//	-cmd=wiki remove [-purge] NAME
+func wikiCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: -cmd=wiki create|list|remove")
+	}
+	switch args[0] {
+	case "create":
+		return createWiki(args[1:])
+	case "list":
+		return listWikis(os.Stdout)
+	case "remove":
+		return removeWiki(args[1:])
+	default:
+		return fmt.Errorf("unknown wiki command %q", args[0])
+	}
+}
+
+func createWiki(args []string) error {
+	fs := flag.NewFlagSet("wiki create", flag.ContinueOnError)
+	hosts := fs.String("hosts", "", "comma separated host names that serve the wiki at /")
+	index := fs.String("index", "index.html", "the index.html, and so the core version, to copy for the wiki")
+	driver := fs.String("driver", "sqlite3", "the store for the wiki: sqlite3, files or postgres")
+	dsn := fs.String("dsn", "", "the connection string when the driver is postgres")
+	email := fs.String("email", "", "the email address of the first user; the password is asked for")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: -cmd=wiki create [flags] NAME")
+	}
+	name := fs.Arg(0)
+	if !config.ValidWikiName(name) {
+		return fmt.Errorf("invalid wiki name %q", name)
+	}
+
+	c, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	wikis, err := config.LoadWikis(config.WikisFile)
+	if err != nil {
+		return err
+	}
+	w := config.Wiki{
+		Name:  name,
+		Dir:   filepath.Join(wikisDir, name),
+		Index: filepath.Join(wikisDir, name, "index.html"),
+	}
+	for _, host := range strings.Split(*hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			w.Hosts = append(w.Hosts, strings.ToLower(host))
+		}
+	}
+	for _, other := range wikis {
+		if other.Name == name {
+			return fmt.Errorf("wiki %s already exists", name)
+		}
+		for _, host := range other.Hosts {
+			for _, h := range w.Hosts {
+				if h == host {
+					return fmt.Errorf("host %s is already used by wiki %s", host, other.Name)
+				}
+			}
+		}
+	}
+
+	switch *driver {
+	case "sqlite3":
+		w.Database = config.DbConfig{Driver: *driver, Path: filepath.Join(w.Dir, "tiddly.db")}
+	case "files":
+		w.Database = config.DbConfig{Driver: *driver, Path: filepath.Join(w.Dir, "wiki")}
+	case "postgres":
+		if *dsn == "" {
+			return errors.New("-dsn is required for postgres")
+		}
+		w.Database = config.DbConfig{Driver: *driver, DSN: *dsn}
+	default:
+		return fmt.Errorf("unknown database driver %q", *driver)
+	}
+
+	var password string
+	if *email != "" {
+		if password, err = prompt.Password(*email, true); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(w.Dir, 0700); err != nil {
+		return err
+	}
+	if err := copyFile(*index, w.Index); err != nil {
+		return err
+	}
+
+	ts, err := db.OpenTiddlyStore(w.Database, nil)
+	if err != nil {
+		return err
+	}
+	defer ts.Close()
+
+	if *email != "" {
+		us, err := db.OpenUserStore(ts, w.Dir, c.Pepper)
+		if err != nil {
+			return err
+		}
+		if err := us.Create(&app.User{Email: *email, Role: app.RoleAdmin}, password); err != nil {
+			return err
+		}
+	}
+
+	if err := config.SaveWikis(config.WikisFile, append(wikis, w)); err != nil {
+		return err
+	}
+	fmt.Printf("created wiki %s at %s%s/\n", name, config.WikiPrefix, name)
+	return nil
+}
+
+func listWikis(out io.Writer) error {
+	wikis, err := config.LoadWikis(config.WikisFile)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPATH\tHOSTS\tDATABASE")
+	for _, w := range wikis {
+		database := w.Database.Driver + " " + w.Database.Path
+		if w.Database.Driver == "postgres" {
+			database = w.Database.Driver
+		}
+		fmt.Fprintf(tw, "%s\t%s%s/\t%s\t%s\n", w.Name, config.WikiPrefix, w.Name, strings.Join(w.Hosts, ","), database)
+	}
+	return tw.Flush()
+}
+
+func removeWiki(args []string) error {
+	fs := flag.NewFlagSet("wiki remove", flag.ContinueOnError)
+	purge := fs.Bool("purge", false, "also delete the wiki's folder")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: -cmd=wiki remove [-purge] NAME")
+	}
+	name := fs.Arg(0)
+
+	wikis, err := config.LoadWikis(config.WikisFile)
+	if err != nil {
+		return err
+	}
+	for i, w := range wikis {
+		if w.Name != name {
+			continue
+		}
+		if err := config.SaveWikis(config.WikisFile, append(wikis[:i], wikis[i+1:]...)); err != nil {
+			return err
+		}
+		if *purge {
+			return os.RemoveAll(w.Dir)
+		}
+		fmt.Printf("removed wiki %s; its files are still in %s\n", name, w.Dir)
+		return nil
+	}
+	return fmt.Errorf("wiki %s does not exist", name)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
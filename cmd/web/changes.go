@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+// handleChanges lists everything written or deleted after the change numbered
+// since={seq}, oldest first, so that a client can catch up from the last
+// change it saw instead of fetching every tiddler again. It answers with the
+// number to ask from next time, and with "more": true when it stopped at
+// limit={n} changes and there are more to come. Each tiddler is listed as in a
+// skinny list, with its seq added, and each deleted tiddler as its bag, title,
+// revision and "deleted": true. exclude={fields} works as for the tiddler
+// list, and recipe= or bag= keeps to the bags of a recipe or to one bag.
+func (s *server) handleChanges() http.HandlerFunc {
+	const (
+		defaultLimit = 500
+		maxLimit     = 1000
+	)
+
+	type result struct {
+		Seq     int64                    `json:"seq"`
+		More    bool                     `json:"more"`
+		Changes []map[string]interface{} `json:"changes"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+
+		q := r.URL.Query()
+		var since int64
+		if v := q.Get("since"); v != "" {
+			var err error
+			if since, err = strconv.ParseInt(v, 10, 64); err != nil || since < 0 {
+				s.clientError(w, http.StatusBadRequest, "since must be a change number")
+				return
+			}
+		}
+		limit := defaultLimit
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				s.clientError(w, http.StatusBadRequest, "invalid limit")
+				return
+			}
+			limit = n
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+		exclude := map[string]bool{"text": true}
+		if v, ok := q["exclude"]; ok {
+			exclude = map[string]bool{}
+			for _, name := range strings.Split(strings.Join(v, ","), ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					exclude[name] = true
+				}
+			}
+		}
+
+		var inBags map[string]bool
+		if kind, name := "recipes", q.Get("recipe"); name != "" || q.Get("bag") != "" {
+			if name == "" {
+				kind, name = "bags", q.Get("bag")
+			}
+			sp, err := s.findSpace(r.Context(), kind, name)
+			if errors.Is(err, app.ErrNotFound) {
+				s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+				return
+			} else if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			inBags = map[string]bool{}
+			for _, bag := range sp.bags {
+				inBags[bag] = true
+			}
+		}
+
+		// One more than limit is asked for to find out whether there are more.
+		changes, err := s.tiddlyStore.Changes(r.Context(), since, limit+1, !exclude["text"])
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+
+		readable := map[string]bool{}
+		res := result{Seq: since, Changes: []map[string]interface{}{}}
+		if len(changes) > limit {
+			changes, res.More = changes[:limit], true
+		}
+		for _, c := range changes {
+			res.Seq = c.Seq
+			if inBags != nil && !inBags[c.Bag] {
+				continue
+			}
+			ok, seen := readable[c.Bag]
+			if !seen {
+				if ok, err = s.mayRead(r.Context(), c.Bag); err != nil {
+					s.serverError(w, r, err)
+					return
+				}
+				readable[c.Bag] = ok
+			}
+			if !ok {
+				continue
+			}
+
+			var js map[string]interface{}
+			if c.Deleted {
+				js = map[string]interface{}{"bag": c.Bag, "title": c.Title, "revision": c.Rev, "deleted": true}
+			} else if js, err = skinnyJSON(c.Tiddler, exclude, !exclude["text"]); err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			js["seq"] = c.Seq
+			res.Changes = append(res.Changes, js)
+		}
+		s.writeJSON(w, r, res)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/etitcombe/tiddlypom/rand"
+)
+
+// TiddlyWiki sends requestedWith in the X-Requested-With header of every
+// request that it makes to the server. Another site can't make a browser send
+// it without the server's say so, so requiring it on the requests that change
+// things keeps other sites from making them with the user's cookie.
+const requestedWith = "TiddlyWiki"
+
+// loginFormTime is how long the login page may be left open before it has to
+// be loaded again.
+const loginFormTime = time.Hour
+
+// loginForm is what the login page needs: the token that ties the form to the
+// browser it was sent to and, for a user who has given their password and has
+// to give the code from their app, their pending login.
+type loginForm struct {
+	Token   string
+	Pending string
+}
+
+// safeMethod reports whether method only reads.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// checkRequestedWith turns away a request that changes things but doesn't have
+// the X-Requested-With header of TiddlyWiki, and reports whether it did not.
+func (s *server) checkRequestedWith(w http.ResponseWriter, r *http.Request) bool {
+	if safeMethod(r.Method) || r.Header.Get("X-Requested-With") == requestedWith {
+		return true
+	}
+	s.clientError(w, http.StatusForbidden, "the X-Requested-With: "+requestedWith+" header is required")
+	return false
+}
+
+// renderLogin renders the login page with a new token in its form and in a
+// cookie, which the form has to send back with the same token to be accepted.
+func (s *server) renderLogin(w http.ResponseWriter, r *http.Request, pending string) {
+	token, err := rand.RememberToken()
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		HttpOnly: true,
+		Name:     s.loginCookie(),
+		Value:    token,
+		Path:     s.wiki.base + "/login/",
+		MaxAge:   int(loginFormTime / time.Second),
+		Secure:   s.wiki.secureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+	s.render(w, r, "login", "Login", nil, loginForm{Token: token, Pending: pending})
+}
+
+// checkLoginForm reports whether the login form that r posts has the token
+// that was sent to the browser with it.
+func (s *server) checkLoginForm(r *http.Request) bool {
+	c, err := r.Cookie(s.loginCookie())
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostFormValue("csrf_token"))) == 1
+}
+
+// loginCookie is the name of the cookie with the token of the login form.
+func (s *server) loginCookie() string {
+	return s.wiki.cookie + "-login"
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+const (
+	// eventBacklog is how many of the latest events are kept for clients that
+	// reconnect after missing some.
+	eventBacklog = 256
+
+	// eventHeartbeat is how often a comment is sent down an event stream
+	// that has had nothing else to send, so that proxies don't take it for
+	// idle and close it, and a client that has gone away is noticed.
+	eventHeartbeat = 15 * time.Second
+
+	// eventWriteTime is how long each write to an event stream may take. The
+	// server's WriteTimeout would otherwise end the stream, which stays open
+	// for as long as the client wants it.
+	eventWriteTime = 10 * time.Second
+
+	// eventRetry is how many milliseconds the browser waits to reconnect.
+	eventRetry = 500
+)
+
+// The operations that an event reports.
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// eventsModule is a TiddlyWiki startup module, added to the page, that has
+// TiddlyWiki sync with the server as soon as a tiddler changes rather than at
+// its next poll.
+var eventsModule = map[string]string{
+	"title":       "$:/tiddlypom/events.js",
+	"type":        "application/javascript",
+	"module-type": "startup",
+	"text": `(function(){
+
+"use strict";
+
+exports.name = "tiddlypom-events";
+exports.platforms = ["browser"];
+exports.after = ["startup"];
+exports.synchronous = true;
+
+exports.startup = function() {
+	if(!$tw.syncer || !$tw.syncadaptor || !window.EventSource) {
+		return;
+	}
+	var timer = null,
+		sync = function() {
+			if(!timer) {
+				timer = setTimeout(function() {
+					timer = null;
+					$tw.syncer.syncFromServer();
+				},250);
+			}
+		},
+		source = new EventSource($tw.syncadaptor.host + "events");
+	source.onmessage = sync;
+	source.addEventListener("reset",sync);
+};
+
+})();
+`,
+}
+
+// event is a change to a tiddler, sent to the clients of /events.
+type event struct {
+	ID       int64  `json:"-"`
+	Op       string `json:"op"`
+	Bag      string `json:"bag"`
+	Title    string `json:"title"`
+	Revision int    `json:"revision"`
+}
+
+// notifier passes the changes that handlers make to the tiddlers of a wiki on
+// to the event streams that are open.
+type notifier struct {
+	mu     sync.Mutex
+	lastID int64
+	recent []event
+	subs   map[chan struct{}]bool
+}
+
+func newNotifier() *notifier {
+	return &notifier{subs: map[chan struct{}]bool{}}
+}
+
+// publish records an event and wakes up the streams.
+func (n *notifier) publish(op string, t app.Tiddler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.lastID++
+	n.recent = append(n.recent, event{ID: n.lastID, Op: op, Bag: t.Bag, Title: t.Title, Revision: t.Rev})
+	if len(n.recent) > eventBacklog {
+		n.recent = n.recent[len(n.recent)-eventBacklog:]
+	}
+	for ch := range n.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel that is signalled after each event, and a
+// function to stop it.
+func (n *notifier) subscribe() (chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	n.mu.Lock()
+	n.subs[ch] = true
+	n.mu.Unlock()
+	return ch, func() {
+		n.mu.Lock()
+		delete(n.subs, ch)
+		n.mu.Unlock()
+	}
+}
+
+// since returns the events after the one with the given ID. It reports false
+// when some of them are no longer kept.
+func (n *notifier) since(id int64) ([]event, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if id >= n.lastID {
+		return nil, true
+	}
+	if len(n.recent) == 0 || n.recent[0].ID > id+1 {
+		return nil, false
+	}
+	i := len(n.recent) - int(n.lastID-id)
+	return append([]event{}, n.recent[i:]...), true
+}
+
+// last returns the ID of the latest event.
+func (n *notifier) last() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.lastID
+}
+
+// handleEvents streams the changes to tiddlers as Server-Sent Events. Each
+// one carries the operation, bag, title and revision as JSON. A client that
+// reconnects with a Last-Event-ID header gets the events it missed, or a reset
+// event when too many have gone by and it should get the whole list again.
+func (s *server) handleEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		// The stream outlives the server's timeouts, so reading, of which
+		// there is nothing left to do, is let go on for as long as it lasts,
+		// and the deadline to write is put off before each write.
+		rc := http.NewResponseController(w)
+		if err := rc.SetReadDeadline(time.Time{}); err != nil {
+			s.serverError(w, r, fmt.Errorf("streaming events: %w", err))
+			return
+		}
+		if err := rc.SetWriteDeadline(time.Now().Add(eventWriteTime)); err != nil {
+			s.serverError(w, r, fmt.Errorf("streaming events: %w", err))
+			return
+		}
+
+		ch, stop := s.events.subscribe()
+		defer stop()
+
+		lastID := s.events.last()
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id > lastID {
+				// The ID is from before the server restarted.
+				id = -1
+			}
+			lastID = id
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Giving the browser the ID it is up to straight away means that it
+		// asks for what it missed when it reconnects, even if nothing changed.
+		fmt.Fprintf(w, "retry: %d\n", eventRetry)
+		if lastID >= 0 {
+			fmt.Fprintf(w, "id: %d\n", lastID)
+		}
+		fmt.Fprint(w, "\n")
+
+		heartbeat := time.NewTicker(eventHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			if err := rc.SetWriteDeadline(time.Now().Add(eventWriteTime)); err != nil {
+				s.errorLog.Printf("streaming events: %v", err)
+				return
+			}
+			events, ok := s.events.since(lastID)
+			if !ok {
+				lastID = s.events.last()
+				fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", lastID)
+			}
+			for _, e := range events {
+				lastID = e.ID
+				if ok, err := s.mayReadEvent(r.Context(), e); err != nil {
+					s.errorLog.Printf("streaming events: %v", err)
+					return
+				} else if !ok {
+					continue
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					s.errorLog.Printf("streaming events: %v", err)
+					return
+				}
+				fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, data)
+			}
+			if err := rc.Flush(); err != nil {
+				// The client has gone away.
+				return
+			}
+
+			select {
+			case <-ch:
+			case <-heartbeat.C:
+				// A comment, which the browser ignores. It is sent with
+				// the next flush, once the deadline has been put off.
+				fmt.Fprint(w, ": ping\n\n")
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// mayReadEvent reports whether the user logged in, or the visitor to a public
+// wiki, may know of e. A visitor isn't told about private tiddlers being saved.
+func (s *server) mayReadEvent(ctx context.Context, e event) (bool, error) {
+	ok, err := s.mayRead(ctx, e.Bag)
+	if err != nil || !ok || e.Op != opPut || s.wiki.privateTag == "" || currentUser(ctx) != nil {
+		return ok, err
+	}
+	t, err := s.tiddlyStore.Get(ctx, e.Bag, e.Title)
+	if errors.Is(err, app.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	private, err := s.isPrivate(ctx, t)
+	return !private, err
+}
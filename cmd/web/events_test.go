@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+// TestEventsOutlastTimeouts makes sure that an event stream is still open,
+// and sends what happens, after the server's timeouts have gone by.
+func TestEventsOutlastTimeouts(t *testing.T) {
+	s := newTestServer(t)
+	hs := httptest.NewUnstartedServer(s)
+	hs.Config.ReadTimeout = 200 * time.Millisecond
+	hs.Config.WriteTimeout = 200 * time.Millisecond
+	hs.Start()
+	defer hs.Close()
+
+	r, err := http.NewRequest(http.MethodGet, hs.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+s.tokens[app.RoleReader])
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /events = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	time.Sleep(time.Second)
+	if w := s.do(app.RoleWriter, http.MethodPut, "/recipes/default/tiddlers/Late", `{"title":"Late"}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("the stream ended before the event was sent")
+			}
+			if strings.HasPrefix(line, "data: ") && strings.Contains(line, `"title":"Late"`) {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the event wasn't sent")
+		}
+	}
+}
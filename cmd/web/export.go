@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/tiddlywiki"
+)
+
+// exportTypes are the content types of the export formats.
+var exportTypes = map[string]string{
+	tiddlywiki.FormatHTML: "text/html; charset=utf-8",
+	tiddlywiki.FormatJSON: "application/json",
+	tiddlywiki.FormatTar:  "application/x-tar",
+	tiddlywiki.FormatZip:  "application/zip",
+}
+
+// handleExport sends the tiddlers of a recipe, or of a bag, as a download. By
+// default that is a standalone copy of the wiki as a single HTML file that works
+// without the server; format=json, tar or zip asks for the tiddlers themselves.
+// They can be narrowed down with tag={tag}, system=only or system=exclude, and
+// since={date}.
+func (s *server) handleExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+
+		q := r.URL.Query()
+		format := q.Get("format")
+		if format == "" {
+			format = tiddlywiki.FormatHTML
+		}
+		contentType, ok := exportTypes[format]
+		if !ok {
+			s.clientError(w, http.StatusBadRequest, "unknown format")
+			return
+		}
+		filter, err := tiddlywiki.NewExportFilter(q.Get("tag"), q.Get("system"), q.Get("since"))
+		if err != nil {
+			s.clientError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		kind, name := "recipes", s.userRecipe(r)
+		if v := q.Get("recipe"); v != "" {
+			name = v
+		} else if v := q.Get("bag"); v != "" {
+			kind, name = "bags", v
+		}
+		sp, err := s.findSpace(r.Context(), kind, name)
+		if errors.Is(err, app.ErrNotFound) {
+			s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		} else if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+
+		tiddlers, err := tiddlywiki.ExportTiddlers(r.Context(), s.tiddlyStore, sp.bags, filter)
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+
+		disposition := `attachment; filename="` + exportFilename(name, format) + `"`
+
+		// The archives are written straight to the response, so once they
+		// have started an error can only be logged.
+		if format == tiddlywiki.FormatTar || format == tiddlywiki.FormatZip {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", disposition)
+			if err := tiddlywiki.Export(w, format, tiddlers, s.wiki.index); err != nil {
+				s.errorLog.Printf("export of %s as %s: %v", name, format, err)
+			}
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := tiddlywiki.Export(&buf, format, tiddlers, s.wiki.index); err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", disposition)
+		buf.WriteTo(w)
+	}
+}
+
+// exportFilename makes a file name for the export of the bag or recipe name,
+// which may well be an email address.
+func exportFilename(name, format string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`"/\:*?<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name) + "." + format
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/filter"
+	"github.com/etitcombe/tiddlypom/merge"
+	"github.com/etitcombe/tiddlypom/tiddlywiki"
 )
 
 func (s *server) handleDelete() http.HandlerFunc {
@@ -21,23 +26,60 @@ func (s *server) handleDelete() http.HandlerFunc {
 			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
 			return
 		}
-		var title string
-		if strings.HasPrefix(r.URL.Path, "/bags/default/tiddlers/") {
-			title = strings.TrimPrefix(r.URL.Path, "/bags/default/tiddlers/")
-		} else if strings.HasPrefix(r.URL.Path, "/bags/bag/tiddlers/") {
-			title = strings.TrimPrefix(r.URL.Path, "/bags/bag/tiddlers/")
-		} else {
-			s.serverError(w, r, fmt.Errorf("invalid path: %q", r.URL.Path))
+		rt := routeFrom(r)
+		bag := rt.space.writeBag()
+
+		// Through a recipe, the tiddler that the recipe shows can only be
+		// deleted when it is in the bag that the recipe saves to, as saving it
+		// would go there too.
+		t, err := s.lookup(r.Context(), rt.space, rt.title)
+		if errors.Is(err, app.ErrNotFound) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		} else if err != nil {
+			s.serverError(w, r, err)
+			return
 		}
 
-		if err := s.tiddlyStore.Delete(r.Context(), title); err != nil {
+		if !s.requireWrite(w, r, bag) {
+			return
+		}
+		if t.Bag != bag {
+			s.clientError(w, http.StatusForbidden, fmt.Sprintf("the tiddler is in bag %q, which the recipe doesn't save to", t.Bag))
+			return
+		}
+		if err := s.tiddlyStore.Delete(r.Context(), bag, rt.title); err != nil {
 			s.serverError(w, r, err)
 			return
 		}
+		s.events.publish(opDelete, app.Tiddler{Bag: bag, Title: rt.title})
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
+func (s *server) handleDeletedList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+
+		revs := []app.Revision{}
+		for _, bag := range routeFrom(r).space.bags {
+			deleted, err := s.tiddlyStore.Deleted(r.Context(), bag)
+			if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			revs = append(revs, deleted...)
+		}
+		sort.SliceStable(revs, func(i, j int) bool { return revs[i].Created.After(revs[j].Created) })
+		s.writeRevisions(w, r, revs)
+	}
+}
+
+// handleHome serves the wiki. How many of the user's tiddlers come with it is
+// set by the embed mode of the wiki, which embed={mode} overrides.
 func (s *server) handleHome() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// https://tiddlywiki.com/static/WebServer%2520API%253A%2520Get%2520Wiki.html
@@ -50,37 +92,109 @@ func (s *server) handleHome() http.HandlerFunc {
 			s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 			return
 		}
-		http.ServeFile(w, r, "index.html")
+
+		mode := s.wiki.embed
+		if v := r.URL.Query().Get("embed"); v != "" {
+			if err := checkEmbed(v); err != nil {
+				s.clientError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			mode = v
+		}
+		var tiddlers []map[string]string
+		if mode != "" && mode != embedNone {
+			sp, err := s.findSpace(r.Context(), "recipes", s.userRecipe(r))
+			if errors.Is(err, app.ErrNotFound) {
+				// There is nothing that they may read.
+				s.serveIndex(w, r, nil)
+				return
+			} else if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			if tiddlers, err = s.embedTiddlers(r.Context(), sp, mode); err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+		}
+		s.serveIndex(w, r, tiddlers)
 	}
 }
 
+// handleList sends the skinny list of the tiddlers in a space: their fields
+// and revisions, without their text, which TiddlyWiki loads one tiddler at a
+// time when it needs it. As with TiddlyWiki's own server, exclude={fields}
+// names the fields to leave out, by default just text, and filter={filter}
+// picks and orders the tiddlers. Otherwise the text of selected tiddlers can be
+// asked for with include_text={title}, as often as needed.
 func (s *server) handleList() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// https://tiddlywiki.com/static/WebServer%2520API%253A%2520Get%2520All%2520Tiddlers.html
 
-		tiddlers, err := s.tiddlyStore.GetList(r.Context())
+		q := r.URL.Query()
+		exclude := map[string]bool{"text": true}
+		if v, ok := q["exclude"]; ok {
+			exclude = map[string]bool{}
+			for _, name := range strings.Split(strings.Join(v, ","), ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					exclude[name] = true
+				}
+			}
+		}
+		includeText := map[string]bool{}
+		for _, title := range q["include_text"] {
+			includeText[title] = true
+		}
+
+		var f *filter.Filter
+		if v := q.Get("filter"); v != "" {
+			var err error
+			if f, err = filter.Parse(v); err != nil {
+				s.clientError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		sp := routeFrom(r).space
+		withText := !exclude["text"] || f != nil && f.UsesText()
+		tiddlers, err := s.spaceList(r.Context(), sp, withText)
 		if err != nil {
 			s.serverError(w, r, err)
 			return
 		}
+		if f != nil {
+			tiddlers, err = filterTiddlers(f, tiddlers)
+			if errors.Is(err, filter.ErrOperand) {
+				s.clientError(w, http.StatusBadRequest, err.Error())
+				return
+			} else if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+		}
 
-		sep := ""
-		var buf bytes.Buffer
-		buf.WriteString("[")
+		list := make([]map[string]interface{}, 0, len(tiddlers))
 		for _, t := range tiddlers {
-			buf.WriteString(sep)
-			sep = ","
-			buf.WriteString(t.Meta)
+			if !withText && includeText[t.Title] {
+				if t, err = s.lookup(r.Context(), sp, t.Title); err != nil {
+					s.serverError(w, r, err)
+					return
+				}
+			}
+			js, err := skinnyJSON(t, exclude, !exclude["text"] || includeText[t.Title])
+			if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			list = append(list, js)
 		}
-		buf.WriteString("]")
-
-		// s.infoLog.Println(buf.String())
-
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(buf.Bytes())
+		s.writeJSON(w, r, list)
 	}
 }
 
+// handleLogin logs a user in with their email and password and, when they
+// have enrolled in two-factor authentication, then a code from their app. The
+// form is only accepted from the browser that the login page was sent to.
 func (s *server) handleLogin() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
@@ -89,6 +203,15 @@ func (s *server) handleLogin() http.HandlerFunc {
 				s.clientError(w, http.StatusBadRequest, err.Error())
 				return
 			}
+			if !s.checkLoginForm(r) {
+				s.clientError(w, http.StatusForbidden, "the login form has expired, please load it again")
+				return
+			}
+			if pending := r.PostFormValue("pending"); pending != "" {
+				s.loginSecondStep(w, r, pending)
+				return
+			}
+
 			email := r.PostFormValue("email")
 			if len(email) == 0 {
 				s.clientError(w, http.StatusBadRequest, "Email address is required.")
@@ -100,57 +223,228 @@ func (s *server) handleLogin() http.HandlerFunc {
 				return
 			}
 
+			if s.tooManyLogins(w, r, email) {
+				return
+			}
 			u, err := s.userStore.Authenticate(email, password)
 			if err != nil {
+				s.loginFailed(r, email, "wrong email or password")
 				s.clientError(w, http.StatusUnauthorized, "")
 				return
 			}
 
-			token, err := s.userStore.CreateRememberToken(u)
-			if err != nil {
-				s.serverError(w, r, err)
+			if u.TOTPSecret != "" {
+				pending, err := s.logins.add(u.Email)
+				if err != nil {
+					s.serverError(w, r, err)
+					return
+				}
+				s.renderLogin(w, r, pending)
 				return
 			}
-
-			c := http.Cookie{
-				HttpOnly: true,
-				Name:     rememberCookieName,
-				Value:    token,
-				Path:     "/",
-				Expires:  time.Now().AddDate(1, 0, 0),
-				MaxAge:   365 * 24 * 60 * 60,
-			}
-			http.SetCookie(w, &c)
-			http.Redirect(w, r, "/", http.StatusFound)
+			s.logIn(w, r, u)
 			return
 		}
 
 		// GET
-		s.render(w, r, "login", "Login", nil, nil)
+		s.renderLogin(w, r, "")
+	}
+}
+
+// logIn remembers that u has logged in with a cookie and sends them to the
+// wiki. Their account's failed logins are forgotten.
+func (s *server) logIn(w http.ResponseWriter, r *http.Request, u *app.User) {
+	s.limiter.succeed(accountKey(u.Email))
+
+	token, err := s.userStore.CreateRememberToken(u, s.limiter.clientIP(r), r.UserAgent())
+	if err != nil {
+		s.serverError(w, r, err)
+		return
 	}
+	s.setRememberCookie(w, token, time.Now())
+	http.Redirect(w, r, s.wiki.base+"/", http.StatusFound)
 }
 
 func (s *server) handleLogout() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie(rememberCookieName)
+		c, err := r.Cookie(s.wiki.cookie)
 		if err != nil {
-			http.Redirect(w, r, "/", http.StatusFound)
+			http.Redirect(w, r, s.wiki.base+"/", http.StatusFound)
 			return
 		}
 		err = s.userStore.ClearRememberToken(c.Value)
 		if err != nil {
 			s.errorLog.Println("error clearing remember token", err)
 		}
-		c.Expires = time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
-		c.MaxAge = 0
-		c.Path = "/"
-		c.Value = ""
-		http.SetCookie(w, c)
-		http.Redirect(w, r, "/login/", http.StatusFound)
+		s.clearRememberCookie(w)
+		http.Redirect(w, r, s.wiki.base+"/login/", http.StatusFound)
+	}
+}
+
+func (s *server) handleRevisions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rt := routeFrom(r)
+
+		wantMethod := http.MethodGet
+		if rt.restore {
+			wantMethod = http.MethodPost
+		}
+		if r.Method != wantMethod {
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+
+		bag, err := s.historyBag(r.Context(), rt.space, rt.title)
+		if errors.Is(err, app.ErrNotFound) {
+			s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		} else if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+
+		if rt.rev == 0 {
+			revs, err := s.tiddlyStore.Revisions(r.Context(), bag, rt.title)
+			if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			s.writeRevisions(w, r, revs)
+			return
+		}
+
+		if rt.restore && !s.requireWrite(w, r, bag) {
+			return
+		}
+
+		var t app.Tiddler
+		if rt.restore {
+			t, err = s.tiddlyStore.Restore(r.Context(), bag, rt.title, rt.rev)
+		} else {
+			t, err = s.tiddlyStore.GetRevision(r.Context(), bag, rt.title, rt.rev)
+		}
+		if errors.Is(err, app.ErrNotFound) {
+			s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		} else if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+
+		data, err := tiddlerJSON(t)
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+		if rt.restore {
+			s.events.publish(opPut, t)
+			w.Header().Set("Etag", etag(t))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
 	}
 }
 
+func (s *server) handleSearch() http.HandlerFunc {
+	const (
+		defaultLimit = 20
+		maxLimit     = 100
+	)
+
+	type result struct {
+		Bag      string  `json:"bag"`
+		Title    string  `json:"title"`
+		Revision int     `json:"revision"`
+		Snippet  string  `json:"snippet"`
+		Score    float64 `json:"score"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+
+		q := r.URL.Query()
+		limit, offset := defaultLimit, 0
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				s.clientError(w, http.StatusBadRequest, "invalid limit")
+				return
+			}
+			limit = n
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+		if v := q.Get("offset"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				s.clientError(w, http.StatusBadRequest, "invalid offset")
+				return
+			}
+			offset = n
+		}
+
+		// Search a recipe, the default one unless a recipe or a bag is given.
+		kind, name := "recipes", app.DefaultRecipe
+		if v := q.Get("recipe"); v != "" {
+			name = v
+		} else if v := q.Get("bag"); v != "" {
+			kind, name = "bags", v
+		}
+		sp, err := s.findSpace(r.Context(), kind, name)
+		if errors.Is(err, app.ErrNotFound) {
+			s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		} else if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+
+		found, err := s.tiddlyStore.Search(r.Context(), sp.bags, q.Get("q"), limit, offset)
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+
+		results := make([]result, 0, len(found))
+		for _, f := range found {
+			// Leave out the tiddlers that the recipe hides behind another.
+			if hidden, err := s.hidden(r.Context(), sp, f.Bag, f.Title); err != nil {
+				s.serverError(w, r, err)
+				return
+			} else if hidden {
+				continue
+			}
+			results = append(results, result{Bag: f.Bag, Title: f.Title, Revision: f.Rev, Snippet: f.Snippet, Score: f.Score})
+		}
+		data, err := json.Marshal(results)
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// handleStatus tells TiddlyWiki who is logged in and which recipe to use. The
+// wiki is read only when they may not save to the bag that the recipe saves to,
+// which visitors to a public wiki never may, and TiddlyWiki then hides its
+// editing buttons.
 func (s *server) handleStatus() http.HandlerFunc {
+	type status struct {
+		Username  string `json:"username"`
+		Anonymous bool   `json:"anonymous"`
+		ReadOnly  bool   `json:"read_only"`
+		Space     struct {
+			Recipe string `json:"recipe"`
+		} `json:"space"`
+		TiddlyWikiVersion string `json:"tiddlywiki_version"`
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		// https://tiddlywiki.com/static/WebServer%2520API%253A%2520Get%2520Server%2520Status.html
 
@@ -159,43 +453,52 @@ func (s *server) handleStatus() http.HandlerFunc {
 			return
 		}
 
-		// TODO: get name from currently logged-in user.
+		u := currentUser(r.Context())
+		st := status{Anonymous: u == nil, TiddlyWikiVersion: "5.1.23"}
+		if u != nil {
+			st.Username = u.Email
+		}
+		st.Space.Recipe = s.userRecipe(r)
 
-		w.Header().Set("Content-Type", "application/json")
-		body := `{
-			"username": "Eric",
-			"anonymous": false,
-			"read_only": false,
-			"space": {
-			  "recipe": "default"
-			},
-			"tiddlywiki_version": "5.1.23"
-		  }`
-		w.Write([]byte(body))
+		sp, err := s.findSpace(r.Context(), "recipes", st.Space.Recipe)
+		if errors.Is(err, app.ErrNotFound) {
+			st.ReadOnly = true
+		} else if err != nil {
+			s.serverError(w, r, err)
+			return
+		} else {
+			writable, err := s.mayWrite(r.Context(), sp.writeBag())
+			if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			st.ReadOnly = !writable
+		}
+		s.writeJSON(w, r, st)
 	}
 }
 
 func (s *server) handleTiddler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		title := strings.TrimPrefix(r.URL.Path, "/recipes/default/tiddlers/")
+		rt := routeFrom(r)
+		title := rt.title
+		bag := rt.space.writeBag()
 
 		if r.Method == http.MethodPut {
 			// https://tiddlywiki.com/static/WebServer%2520API%253A%2520Put%2520Tiddler.html
 
-			data, err := ioutil.ReadAll(r.Body)
-			if err != nil {
-				s.clientError(w, http.StatusBadRequest, "cannot read data: "+err.Error())
+			if !s.requireWrite(w, r, bag) {
 				return
 			}
-
 			var js map[string]interface{}
-			err = json.Unmarshal(data, &js)
-			if err != nil {
-				s.serverError(w, r, err)
+			if !s.readJSON(w, r, &js) {
+				return
+			}
+			fieldTitle, ok := js["title"].(string)
+			if !ok {
+				s.clientError(w, http.StatusBadRequest, "the tiddler must have a title")
 				return
 			}
-
-			js["bag"] = "bag"
 
 			var t app.Tiddler
 			if text, ok := js["text"].(string); ok {
@@ -203,14 +506,19 @@ func (s *server) handleTiddler() http.HandlerFunc {
 			}
 			delete(js, "text")
 
-			rev := 1
-			old, err := s.tiddlyStore.Get(r.Context(), title)
-			if err == nil {
-				rev = old.Rev + 1
+			// An If-Match header must name the current revision. Failing
+			// that, the revision in the body says what the edit was based on.
+			ifRev, conflictStatus := 0, http.StatusConflict
+			if v := r.Header.Get("If-Match"); v != "" && v != "*" {
+				rev, ok := etagRevision(v)
+				if !ok {
+					s.clientError(w, http.StatusPreconditionFailed, "cannot read If-Match")
+					return
+				}
+				ifRev, conflictStatus = rev, http.StatusPreconditionFailed
+			} else if rev := jsonRevision(js["revision"]); rev != 0 {
+				ifRev = s.baseRevision(r, bag, title, rev)
 			}
-			t.Rev = rev
-
-			js["revision"] = rev
 
 			meta, err := json.Marshal(js)
 			if err != nil {
@@ -218,36 +526,39 @@ func (s *server) handleTiddler() http.HandlerFunc {
 				return
 			}
 			t.Meta = string(meta)
-			t.IsSystem = isSystemTiddler(js["title"].(string))
+			t.IsSystem = tiddlywiki.IsSystemTiddler(fieldTitle)
 
-			if err := s.tiddlyStore.Upsert(r.Context(), title, t); err != nil {
+			saved, err := s.tiddlyStore.Upsert(r.Context(), bag, title, t, ifRev)
+			if errors.Is(err, app.ErrConflict) && conflictStatus == http.StatusConflict {
+				s.mergeTiddler(w, r, bag, title, t, ifRev)
+				return
+			} else if errors.Is(err, app.ErrConflict) {
+				s.clientError(w, conflictStatus, "the tiddler has been changed since it was loaded")
+				return
+			} else if err != nil {
 				s.serverError(w, r, err)
 				return
 			}
+			s.recordSave(r, saved)
+			s.events.publish(opPut, saved)
 
 			w.Header().Set("Content-Type", "text/plain")
-			// etag := fmt.Sprintf("\"default/%s/%d:%x\"", url.QueryEscape(title), rev, md5.Sum(data))
-			etag := fmt.Sprintf("\"default/%s/%d:\"", url.QueryEscape(title), rev)
-			w.Header().Set("Etag", etag)
+			w.Header().Set("Etag", etag(saved))
 			return
 		}
 
 		// GET
 		// https://tiddlywiki.com/static/WebServer%2520API%253A%2520Get%2520Tiddler.html
-		t, err := s.tiddlyStore.Get(r.Context(), title)
-		if err != nil {
-			s.serverError(w, r, err)
+		t, err := s.lookup(r.Context(), rt.space, title)
+		if errors.Is(err, app.ErrNotFound) {
+			s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 			return
-		}
-
-		var js map[string]interface{}
-		err = json.Unmarshal([]byte(t.Meta), &js)
-		if err != nil {
+		} else if err != nil {
 			s.serverError(w, r, err)
 			return
 		}
-		js["text"] = string(t.Text)
-		data, err := json.Marshal(js)
+
+		data, err := tiddlerJSON(t)
 		if err != nil {
 			s.serverError(w, r, err)
 			return
@@ -257,42 +568,267 @@ func (s *server) handleTiddler() http.HandlerFunc {
 	}
 }
 
-func isSystemTiddler(title string) bool {
-	/*
-		These tiddlers are listed when you click on System under More in the sidebar:
-		   $:/boot/boot.css
-		   $:/boot/boot.js
-		   $:/boot/bootprefix.js
-		   $:/core
-		   $:/HistoryList
-		   $:/isEncrypted
-		   $:/library/sjcl.js
-		   $:/plugins/tiddlywiki/tiddlyweb
-		   $:/state/tab-1749438307
-		   $:/state/tab/moresidebar-1850697562
-		   $:/state/tab/sidebar--595412856
-		   $:/status/IsAnonymous
-		   $:/status/IsLoggedIn
-		   $:/status/IsReadOnly
-		   $:/status/RequireReloadDueToPluginChange
-		   $:/status/UserName
-		   $:/StoryList
-		   $:/temp/info-plugin
-		   $:/themes/tiddlywiki/snowwhite
-		   $:/themes/tiddlywiki/vanilla
-		But if we don't return what's under /themes/ any changes we make to the
-		appearance will get lost on the next refresh.
-	*/
-	return strings.HasPrefix(title, "$:/boot/") ||
-		strings.HasPrefix(title, "$:/core") ||
-		strings.HasPrefix(title, "$:/HistoryList") ||
-		strings.HasPrefix(title, "$:/isEncrypted") ||
-		strings.HasPrefix(title, "$:/library/") ||
-		strings.HasPrefix(title, "$:/plugins/") ||
-		strings.HasPrefix(title, "$:/state/") ||
-		strings.HasPrefix(title, "$:/status/") ||
-		strings.HasPrefix(title, "$:/StoryList") ||
-		strings.HasPrefix(title, "$:/temp/")
+// mergeTiddler saves an edit of a tiddler that was based on an earlier
+// revision than the current one by merging the two edits. When the text can't
+// be merged cleanly the current revision is left alone and the edit is saved as
+// a new "Conflict: <title>" tiddler instead, showing both versions of the lines
+// that were changed on each side. A title that is already taken, by an earlier
+// conflict, gets a number after it.
+//
+// The ETag sent back is for the base revision rather than a new one so that
+// TiddlyWiki sees that the tiddler has changed on the server and loads it.
+func (s *server) mergeTiddler(w http.ResponseWriter, r *http.Request, bag, title string, mine app.Tiddler, baseRev int) {
+	base, err := s.tiddlyStore.GetRevision(r.Context(), bag, title, baseRev)
+	if errors.Is(err, app.ErrNotFound) {
+		s.clientError(w, http.StatusConflict, "the tiddler has been changed since it was loaded")
+		return
+	} else if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	theirs, err := s.tiddlyStore.Get(r.Context(), bag, title)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	mineFields, err := tiddlywiki.Fields(mine)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	baseFields, err := tiddlywiki.Fields(base)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	theirsFields, err := tiddlywiki.Fields(theirs)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	text, clean := merge.Text(base.Text, mine.Text, theirs.Text, "this edit", fmt.Sprintf("revision %d", theirs.Rev))
+
+	var saved app.Tiddler
+	if clean {
+		fields := merge.Fields(baseFields, mineFields, theirsFields)
+		fields["text"] = text
+		saved, err = s.saveFields(r.Context(), bag, title, fields, theirs.Rev)
+	} else {
+		fields := mineFields
+		fields["conflict.of"] = title
+		fields["conflict.revision"] = strconv.Itoa(theirs.Rev)
+		fields["text"] = text
+		saved, err = s.saveConflict(r.Context(), bag, title, fields)
+	}
+	if errors.Is(err, app.ErrConflict) {
+		s.clientError(w, http.StatusConflict, "the tiddler has been changed since it was loaded")
+		return
+	} else if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	s.recordSave(r, saved)
+	s.events.publish(opPut, saved)
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Etag", etag(base))
+}
+
+// maxConflicts is how many conflicting edits of a tiddler can be kept before
+// saving another one fails.
+const maxConflicts = 100
+
+// saveConflict saves the fields of an edit that couldn't be merged into title
+// as a new tiddler, under the first of "Conflict: <title>", "Conflict: <title>
+// (2)" and so on that isn't taken, so that it doesn't replace an earlier one.
+func (s *server) saveConflict(ctx context.Context, bag, title string, fields map[string]string) (app.Tiddler, error) {
+	for n := 1; n <= maxConflicts; n++ {
+		conflictTitle := "Conflict: " + title
+		if n > 1 {
+			conflictTitle += fmt.Sprintf(" (%d)", n)
+		}
+		fields["title"] = conflictTitle
+		saved, err := s.saveFields(ctx, bag, conflictTitle, fields, app.IfNew)
+		if !errors.Is(err, app.ErrConflict) {
+			return saved, err
+		}
+	}
+	return app.Tiddler{}, fmt.Errorf("%q already has %d conflicting edits: %w", title, maxConflicts, app.ErrConflict)
+}
+
+// saveFields saves a tiddler with the given fields to bag.
+func (s *server) saveFields(ctx context.Context, bag, title string, fields map[string]string, ifRev int) (app.Tiddler, error) {
+	t, err := tiddlywiki.NewTiddler(fields, bag, 0)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+	return s.tiddlyStore.Upsert(ctx, bag, title, t, ifRev)
+}
+
+// baseRevision works out which revision of a tiddler a client's edit is based
+// on. TiddlyWiki does not update the revision field of a tiddler after saving
+// it, so once this session has saved the tiddler the field is out of date and
+// the revision the session last saved is the one to compare against.
+//
+// A tiddler that does not exist is simply written again. Drafts carry the
+// revision of the tiddler they are a draft of and would otherwise never match.
+func (s *server) baseRevision(r *http.Request, bag, title string, bodyRev int) int {
+	current, err := s.tiddlyStore.Get(r.Context(), bag, title)
+	if err != nil {
+		return 0
+	}
+	if client := saveClient(r.Context()); client != "" {
+		if saved, ok := s.saves.get(client, bag, title); ok && saved == current.Rev {
+			return current.Rev
+		}
+	}
+	return bodyRev
+}
+
+// recordSave remembers the revision of a tiddler that the request's session,
+// or API token, has just saved.
+func (s *server) recordSave(r *http.Request, t app.Tiddler) {
+	if client := saveClient(r.Context()); client != "" {
+		s.saves.add(client, t.Bag, t.Title, t.Rev)
+	}
+}
+
+// etag returns the ETag of a tiddler in the TiddlyWeb form, "bag/title/rev:".
+// TiddlyWiki reads the bag from it with decodeURIComponent, so it is escaped
+// as a path rather than a query.
+func etag(t app.Tiddler) string {
+	return fmt.Sprintf("\"%s/%s/%d:\"", url.PathEscape(t.Bag), url.PathEscape(t.Title), t.Rev)
+}
+
+// etagRevision reads the revision from an ETag in the form the tiddler
+// handlers send, "bag/title/rev:".
+func etagRevision(etag string) (int, bool) {
+	etag = strings.TrimPrefix(etag, "W/")
+	etag = strings.Trim(etag, `"`)
+	i := strings.LastIndex(etag, "/")
+	if i < 0 {
+		return 0, false
+	}
+	etag = etag[i+1:]
+	if j := strings.Index(etag, ":"); j >= 0 {
+		etag = etag[:j]
+	}
+	rev, err := strconv.Atoi(etag)
+	if err != nil || rev < 1 {
+		return 0, false
+	}
+	return rev, true
+}
+
+// jsonRevision reads the revision field of a tiddler sent by a client, which
+// TiddlyWiki sends as a string and other clients may send as a number. Zero
+// means there is no revision.
+func jsonRevision(v interface{}) int {
+	n := 0
+	switch rev := v.(type) {
+	case string:
+		n, _ = strconv.Atoi(rev)
+	case float64:
+		n = int(rev)
+	}
+	if n < 1 {
+		return 0
+	}
+	return n
+}
+
+func (s *server) writeRevisions(w http.ResponseWriter, r *http.Request, revs []app.Revision) {
+	type revision struct {
+		Bag      string    `json:"bag"`
+		Title    string    `json:"title"`
+		Revision int       `json:"revision"`
+		Created  time.Time `json:"created"`
+		Deleted  bool      `json:"deleted,omitempty"`
+	}
+
+	list := make([]revision, 0, len(revs))
+	for _, rev := range revs {
+		list = append(list, revision{Bag: rev.Bag, Title: rev.Title, Revision: rev.Rev, Created: rev.Created, Deleted: rev.Deleted})
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// tiddlerJSON returns the full JSON representation of a tiddler, that is its
+// meta data with the text added back in.
+func tiddlerJSON(t app.Tiddler) ([]byte, error) {
+	var js map[string]interface{}
+	if err := json.Unmarshal([]byte(t.Meta), &js); err != nil {
+		return nil, err
+	}
+	js["text"] = string(t.Text)
+	return json.Marshal(js)
+}
+
+// skinnyJSON returns a tiddler in the TiddlyWeb JSON format of the tiddler
+// list, with its revision, without the fields in exclude and with its text
+// only when text is true. The revision can be excluded as well, though the
+// title can't.
+func skinnyJSON(t app.Tiddler, exclude map[string]bool, text bool) (map[string]interface{}, error) {
+	var js map[string]interface{}
+	if err := json.Unmarshal([]byte(t.Meta), &js); err != nil {
+		return nil, err
+	}
+	if fields, ok := js["fields"].(map[string]interface{}); ok {
+		for name := range fields {
+			if exclude[name] {
+				delete(fields, name)
+			}
+		}
+	}
+	for name := range js {
+		if exclude[name] && name != "title" {
+			delete(js, name)
+		}
+	}
+	if !exclude["revision"] {
+		js["revision"] = t.Rev
+	}
+	delete(js, "text")
+	if text {
+		js["text"] = t.Text
+	}
+	return js, nil
+}
+
+// filterTiddlers runs f against tiddlers and returns the ones it selects, in
+// the order it gives them.
+func filterTiddlers(f *filter.Filter, tiddlers []app.Tiddler) ([]app.Tiddler, error) {
+	list := make([]map[string]string, 0, len(tiddlers))
+	byTitle := make(map[string]app.Tiddler, len(tiddlers))
+	for _, t := range tiddlers {
+		fields, err := tiddlywiki.Fields(t)
+		if err != nil {
+			return nil, err
+		}
+		fields["title"] = t.Title
+		list = append(list, fields)
+		byTitle[t.Title] = t
+	}
+
+	titles, err := f.Run(filter.NewSet(list))
+	if err != nil {
+		return nil, err
+	}
+	selected := make([]app.Tiddler, 0, len(titles))
+	for _, title := range titles {
+		if t, ok := byTitle[title]; ok {
+			selected = append(selected, t)
+		}
+	}
+	return selected, nil
 }
 
 /*
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/tiddlywiki"
+)
+
+// maxImportSize is the largest single-file wiki that can be uploaded.
+const maxImportSize = 64 << 20
+
+// handleImport moves the tiddlers of an uploaded single-file wiki into a bag.
+// The file is the body of the request, which is meant for scripts with an API
+// token; a form in a browser can't post it, as it can't set X-Requested-With.
+// The tiddlers go in the bag given by bag={bag}, or the bag that the logged in
+// user's recipe saves to.
+func (s *server) handleImport() http.HandlerFunc {
+	type result struct {
+		Bag      string `json:"bag"`
+		Imported int    `json:"imported"`
+		Skipped  int    `json:"skipped"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+
+		kind, name := "recipes", s.userRecipe(r)
+		if v := r.URL.Query().Get("bag"); v != "" {
+			kind, name = "bags", v
+		}
+		sp, err := s.findSpace(r.Context(), kind, name)
+		if errors.Is(err, app.ErrNotFound) {
+			s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		} else if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+		bag := sp.writeBag()
+		if !s.requireWrite(w, r, bag) {
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		page, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			s.clientError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		tiddlers, err := tiddlywiki.Tiddlers(page)
+		if errors.Is(err, tiddlywiki.ErrNoStoreArea) || errors.Is(err, tiddlywiki.ErrBadStoreArea) {
+			s.clientError(w, http.StatusBadRequest, err.Error())
+			return
+		} else if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+
+		saved, err := tiddlywiki.Import(r.Context(), s.tiddlyStore, bag, tiddlers)
+		for _, t := range saved {
+			s.events.publish(opPut, t)
+		}
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+		s.writeJSON(w, r, result{Bag: bag, Imported: len(saved), Skipped: len(tiddlers) - len(saved)})
+	}
+}
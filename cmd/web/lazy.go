@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/etitcombe/tiddlypom/tiddlywiki"
+)
+
+// The ways the home page can carry the tiddlers of the user's recipe. With
+// embedNone TiddlyWiki starts from the bare index.html and, after getting the
+// skinny list, loads every tiddler with a request of its own. The others put
+// the tiddlers in the page, and the lazy ones leave out the text of some so
+// that TiddlyWiki loads it the first time the tiddler is shown.
+const (
+	embedNone       = "none"
+	embedAll        = "all"
+	embedLazyImages = "lazy-images"
+	embedLazyAll    = "lazy-all"
+)
+
+// checkEmbed returns an error unless mode is one of the embed modes, or empty
+// for the default.
+func checkEmbed(mode string) error {
+	switch mode {
+	case "", embedNone, embedAll, embedLazyImages, embedLazyAll:
+		return nil
+	default:
+		return fmt.Errorf("unknown embed mode %q", mode)
+	}
+}
+
+// embedTiddlers gets the tiddlers of sp that the home page carries in mode, as
+// the fields TiddlyWiki keeps in its store area. They keep their bag and
+// revision so that TiddlyWiki knows it is up to date and doesn't load them
+// again. A skinny tiddler has no text and an _is_skinny field instead.
+func (s *server) embedTiddlers(ctx context.Context, sp space, mode string) ([]map[string]string, error) {
+	if mode == "" || mode == embedNone {
+		return nil, nil
+	}
+
+	tiddlers, err := s.spaceList(ctx, sp, mode != embedLazyAll)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]string, 0, len(tiddlers))
+	for _, t := range tiddlers {
+		fields, err := tiddlywiki.Fields(t)
+		if err != nil {
+			return nil, err
+		}
+		fields["title"] = t.Title
+		fields["bag"] = t.Bag
+		fields["revision"] = strconv.Itoa(t.Rev)
+		if mode == embedLazyAll || mode == embedLazyImages && lazyType(fields["type"]) {
+			delete(fields, "text")
+			fields["_is_skinny"] = ""
+		}
+		list = append(list, fields)
+	}
+	return list, nil
+}
+
+// lazyType reports whether the text of tiddlers of type typ is left to load
+// lazily in embedLazyImages mode: images, and anything else held as base64.
+func lazyType(typ string) bool {
+	return strings.HasPrefix(typ, "image/") || tiddlywiki.IsBinaryType(typ)
+}
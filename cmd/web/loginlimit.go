@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etitcombe/tiddlypom/config"
+)
+
+// The defaults of config.LoginLimits.
+const (
+	defaultFreeFailures = 5
+	defaultBackoff      = time.Second
+	defaultLockoutAfter = 10
+	defaultLockout      = 15 * time.Minute
+)
+
+// loginFailures is the run of failed logins from an IP address, or for an
+// account.
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+// loginLimiter slows down and then locks out the attempts to guess passwords,
+// and codes, by keeping track of the failed logins from each IP address and
+// for each account. The servers of a wiki share one.
+type loginLimiter struct {
+	freeFailures int
+	backoff      time.Duration
+	lockoutAfter int
+	lockout      time.Duration
+	trustProxy   bool
+
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+// newLoginLimiter returns a limiter for the limits in c, with the defaults for
+// the ones that aren't set.
+func newLoginLimiter(c config.LoginLimits) (*loginLimiter, error) {
+	l := &loginLimiter{
+		freeFailures: c.FreeFailures,
+		backoff:      defaultBackoff,
+		lockoutAfter: c.LockoutAfter,
+		lockout:      defaultLockout,
+		trustProxy:   c.TrustProxy,
+		failures:     map[string]*loginFailures{},
+	}
+	if l.freeFailures <= 0 {
+		l.freeFailures = defaultFreeFailures
+	}
+	if l.lockoutAfter <= 0 {
+		l.lockoutAfter = defaultLockoutAfter
+	}
+	if l.lockoutAfter < l.freeFailures {
+		return nil, fmt.Errorf("login limits: lockout_after can't be less than free_failures")
+	}
+
+	var err error
+	if c.Backoff != "" {
+		if l.backoff, err = time.ParseDuration(c.Backoff); err != nil || l.backoff <= 0 {
+			return nil, fmt.Errorf("login limits: cannot read backoff %q", c.Backoff)
+		}
+	}
+	if c.Lockout != "" {
+		if l.lockout, err = time.ParseDuration(c.Lockout); err != nil || l.lockout <= 0 {
+			return nil, fmt.Errorf("login limits: cannot read lockout %q", c.Lockout)
+		}
+	}
+	return l, nil
+}
+
+// delay is how long after the last of count failures in a row the next
+// attempt has to wait.
+func (l *loginLimiter) delay(count int) time.Duration {
+	if count < l.freeFailures {
+		return 0
+	}
+	if count >= l.lockoutAfter {
+		return l.lockout
+	}
+	d := l.backoff
+	for i := l.freeFailures; i < count && d < l.lockout; i++ {
+		d *= 2
+	}
+	if d > l.lockout {
+		d = l.lockout
+	}
+	return d
+}
+
+// wait returns how long an attempt has to wait, going by the longest wait of
+// keys, or zero when it may go ahead.
+func (l *loginLimiter) wait(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var longest time.Duration
+	for _, key := range keys {
+		f, ok := l.failures[key]
+		if !ok {
+			continue
+		}
+		if d := f.last.Add(l.delay(f.count)).Sub(now); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// fail records a failed attempt against keys and returns the longest run of
+// failures among them. Runs that have been left alone for the lockout time
+// are forgotten along the way.
+func (l *loginLimiter) fail(keys ...string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, f := range l.failures {
+		if now.Sub(f.last) > l.lockout {
+			delete(l.failures, key)
+		}
+	}
+
+	longest := 0
+	for _, key := range keys {
+		f, ok := l.failures[key]
+		if !ok {
+			f = &loginFailures{}
+			l.failures[key] = f
+		}
+		f.count++
+		f.last = now
+		if f.count > longest {
+			longest = f.count
+		}
+	}
+	return longest
+}
+
+// succeed forgets the failures of key.
+func (l *loginLimiter) succeed(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// clientIP returns the IP address that r came from.
+func (l *loginLimiter) clientIP(r *http.Request) string {
+	if l.trustProxy {
+		if v := r.Header.Get("X-Forwarded-For"); v != "" {
+			// The proxy adds the address it saw to the end of the list.
+			parts := strings.Split(v, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// ipKey and accountKey are the keys that the failures from an IP address and
+// for an account are kept under.
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+// tooManyLogins turns a login away when there have been too many failures,
+// and reports whether it did.
+func (s *server) tooManyLogins(w http.ResponseWriter, r *http.Request, email string) bool {
+	ip := s.limiter.clientIP(r)
+	wait := s.limiter.wait(ipKey(ip), accountKey(email))
+	if wait <= 0 {
+		return false
+	}
+
+	seconds := int((wait + time.Second - 1) / time.Second)
+	s.errorLog.Printf("login for %s from %s turned away for %ds after too many failures", email, ip, seconds)
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+	s.clientError(w, http.StatusTooManyRequests, fmt.Sprintf("too many failed logins, try again in %d seconds", seconds))
+	return true
+}
+
+// loginFailed records a failed login, of the password or of a code.
+func (s *server) loginFailed(r *http.Request, email, what string) {
+	ip := s.limiter.clientIP(r)
+	n := s.limiter.fail(ipKey(ip), accountKey(email))
+	s.errorLog.Printf("failed login for %s from %s: %s (%d in a row)", email, ip, what, n)
+}
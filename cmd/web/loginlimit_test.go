@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/etitcombe/tiddlypom/config"
+)
+
+func TestNewLoginLimiter(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits config.LoginLimits
+		ok     bool
+	}{
+		{"defaults", config.LoginLimits{}, true},
+		{"all set", config.LoginLimits{FreeFailures: 3, Backoff: "2s", LockoutAfter: 6, Lockout: "1h"}, true},
+		{"lockout before backoff", config.LoginLimits{FreeFailures: 8, LockoutAfter: 4}, false},
+		{"bad backoff", config.LoginLimits{Backoff: "soon"}, false},
+		{"negative backoff", config.LoginLimits{Backoff: "-1s"}, false},
+		{"bad lockout", config.LoginLimits{Lockout: "15"}, false},
+	}
+	for _, tt := range tests {
+		if _, err := newLoginLimiter(tt.limits); (err == nil) != tt.ok {
+			t.Errorf("%s: newLoginLimiter error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestLoginLimiterDelay(t *testing.T) {
+	l, err := newLoginLimiter(config.LoginLimits{FreeFailures: 2, Backoff: "1s", LockoutAfter: 8, Lockout: "10s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second, 10 * time.Second, 10 * time.Second}
+	for count, d := range want {
+		if got := l.delay(count); got != d {
+			t.Errorf("delay(%d) = %v, want %v", count, got, d)
+		}
+	}
+}
+
+func TestLoginLimiterWait(t *testing.T) {
+	l, err := newLoginLimiter(config.LoginLimits{FreeFailures: 2, Backoff: "1m", LockoutAfter: 3, Lockout: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, me, you := ipKey("192.0.2.1"), accountKey("Me@Example.com"), accountKey("you@example.com")
+
+	if n := l.fail(ip, me); n != 1 {
+		t.Errorf("fail = %d, want 1", n)
+	}
+	l.fail(ip, me)
+	if wait := l.wait(ip, you); wait <= 0 || wait > time.Minute {
+		t.Errorf("wait after two failures = %v, want up to a minute", wait)
+	}
+	if wait := l.wait(ipKey("192.0.2.2"), accountKey("me@example.com")); wait <= 0 {
+		t.Errorf("the account isn't held up from another address")
+	}
+	if wait := l.wait(ipKey("192.0.2.2"), you); wait != 0 {
+		t.Errorf("wait for someone else = %v, want 0", wait)
+	}
+
+	if n := l.fail(ip, you); n != 3 {
+		t.Errorf("fail = %d, want the address's 3", n)
+	}
+	if wait := l.wait(ip); wait <= time.Minute {
+		t.Errorf("wait after three failures = %v, want the lockout", wait)
+	}
+
+	l.succeed(me)
+	if wait := l.wait(ipKey("192.0.2.2"), me); wait != 0 {
+		t.Errorf("wait after the account logged in = %v, want 0", wait)
+	}
+	if wait := l.wait(ip, me); wait <= 0 {
+		t.Errorf("a login forgot the failures from the address")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		trustProxy bool
+		remote     string
+		forwarded  string
+		want       string
+	}{
+		{false, "192.0.2.1:1234", "", "192.0.2.1"},
+		{false, "192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+		{true, "192.0.2.1:1234", "", "192.0.2.1"},
+		{true, "192.0.2.1:1234", "198.51.100.1", "198.51.100.1"},
+		{true, "192.0.2.1:1234", "203.0.113.9, 198.51.100.1", "198.51.100.1"},
+		{false, "[2001:db8::1]:1234", "", "2001:db8::1"},
+		{false, "@", "", "@"},
+	}
+	for _, tt := range tests {
+		l := &loginLimiter{trustProxy: tt.trustProxy}
+		r := httptest.NewRequest("POST", "/login", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := l.clientIP(r); got != tt.want {
+			t.Errorf("clientIP(%q, %q, trust %v) = %q, want %q", tt.remote, tt.forwarded, tt.trustProxy, got, tt.want)
+		}
+	}
+}
@@ -35,27 +35,46 @@ func main() {
 		errorLog.Fatal(err)
 	}
 
-	tiddlyStore, err := db.NewTiddlyStore("./database/tiddly.db")
+	tiddlyStore, err := db.OpenTiddlyStore(config.Database, errorLog)
 	if err != nil {
 		errorLog.Fatal(err)
 	}
 	defer tiddlyStore.Close()
 
-	if err := tiddlyStore.Open(); err != nil {
+	userStore, err := db.OpenUserStore(tiddlyStore, ".", config.Pepper)
+	if err != nil {
 		errorLog.Fatal(err)
 	}
 
-	userStore, err := db.NewUserStoreFile(config.Pepper)
-	if err != nil {
+	if err := checkEmbed(config.Embed); err != nil {
+		errorLog.Fatal(err)
+	}
+	wk := mainWiki
+	wk.embed = config.Embed
+	wk.public = config.Public
+	wk.privateTag = config.PrivateTag
+	wk.secureCookies = !config.InsecureCookies
+	if wk.limiter, err = newLoginLimiter(config.Login); err != nil {
+		errorLog.Fatal(err)
+	}
+	wk.logins = newPendingLogins()
+	wk.saves = newSavedRevisions()
+	if wk.sessions, err = newSessionLimits(config.Sessions); err != nil {
 		errorLog.Fatal(err)
 	}
 
-	server := newServer(infoLog, errorLog, tiddlyStore, userStore)
+	server := newServer(infoLog, errorLog, tiddlyStore, userStore, wk)
+
+	wikis, err := openWikis(infoLog, errorLog, server, config)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer wikis.Close()
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", port),
 		ErrorLog:     errorLog,
-		Handler:      server,
+		Handler:      wikis,
 		WriteTimeout: time.Second * 15,
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
@@ -0,0 +1,15 @@
+CREATE TABLE tiddler_revision (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	title      TEXT NOT NULL,
+	rev        INTEGER NOT NULL,
+	meta       TEXT NOT NULL,
+	text       TEXT NOT NULL,
+	is_system  INTEGER NOT NULL DEFAULT (0),
+	created    TEXT NOT NULL,
+	UNIQUE (title, rev)
+);
+
+CREATE INDEX tiddler_revision_title_idx ON tiddler_revision (title);
+
+INSERT INTO tiddler_revision (title, rev, meta, text, is_system, created)
+SELECT title, rev, meta, text, is_system, strftime('%Y-%m-%dT%H:%M:%SZ', 'now') FROM tiddler;
@@ -0,0 +1,3 @@
+ALTER TABLE tiddler_revision ADD COLUMN deleted INTEGER NOT NULL DEFAULT (0);
+
+CREATE INDEX tiddler_revision_deleted_idx ON tiddler_revision (deleted);
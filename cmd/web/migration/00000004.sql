@@ -0,0 +1,2 @@
+-- The full-text index of the tiddlers is made by TiddlyStore.Open, with
+-- fts5/tiddler_fts.sql, when go-sqlite3 is built with the sqlite_fts5 tag.
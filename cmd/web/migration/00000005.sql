@@ -0,0 +1,65 @@
+CREATE TABLE bag (
+	name        TEXT PRIMARY KEY,
+	description TEXT NOT NULL DEFAULT ('')
+);
+
+CREATE TABLE recipe (
+	name        TEXT PRIMARY KEY,
+	description TEXT NOT NULL DEFAULT ('')
+);
+
+CREATE TABLE recipe_bag (
+	recipe     TEXT NOT NULL REFERENCES recipe (name) ON DELETE CASCADE,
+	position   INTEGER NOT NULL,
+	bag        TEXT NOT NULL REFERENCES bag (name),
+	PRIMARY KEY (recipe, position)
+);
+
+INSERT INTO bag (name) VALUES ('default');
+INSERT INTO recipe (name) VALUES ('default');
+INSERT INTO recipe_bag (recipe, position, bag) VALUES ('default', 0, 'default');
+
+-- Titles are now only unique within a bag. SQLite can't change a table's
+-- constraints, so the tiddler tables are copied into new ones. Everything so far
+-- goes in the default bag, including tiddlers saved with the old "bag" bag.
+CREATE TABLE tiddler_new (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	bag        TEXT NOT NULL REFERENCES bag (name),
+	title      TEXT NOT NULL,
+	rev        INTEGER NOT NULL,
+	meta       TEXT NOT NULL,
+	text       TEXT NOT NULL,
+	is_system  INTEGER NOT NULL DEFAULT (0),
+	UNIQUE (bag, title)
+);
+
+INSERT INTO tiddler_new (id, bag, title, rev, meta, text, is_system)
+SELECT id, 'default', title, rev, REPLACE(meta, '"bag":"bag"', '"bag":"default"'), text, is_system FROM tiddler;
+
+DROP TABLE tiddler;
+ALTER TABLE tiddler_new RENAME TO tiddler;
+
+CREATE INDEX tiddler_title_idx ON tiddler (title);
+CREATE INDEX tiddler_is_system_idx ON tiddler (is_system);
+
+CREATE TABLE tiddler_revision_new (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	bag        TEXT NOT NULL REFERENCES bag (name),
+	title      TEXT NOT NULL,
+	rev        INTEGER NOT NULL,
+	meta       TEXT NOT NULL,
+	text       TEXT NOT NULL,
+	is_system  INTEGER NOT NULL DEFAULT (0),
+	created    TEXT NOT NULL,
+	deleted    INTEGER NOT NULL DEFAULT (0),
+	UNIQUE (bag, title, rev)
+);
+
+INSERT INTO tiddler_revision_new (id, bag, title, rev, meta, text, is_system, created, deleted)
+SELECT id, 'default', title, rev, REPLACE(meta, '"bag":"bag"', '"bag":"default"'), text, is_system, created, deleted FROM tiddler_revision;
+
+DROP TABLE tiddler_revision;
+ALTER TABLE tiddler_revision_new RENAME TO tiddler_revision;
+
+CREATE INDEX tiddler_revision_title_idx ON tiddler_revision (title);
+CREATE INDEX tiddler_revision_deleted_idx ON tiddler_revision (deleted);
@@ -0,0 +1,17 @@
+-- Users and their login sessions, which used to be kept in users.gob and
+-- usertokens.gob. Emails are unique whatever their case.
+CREATE TABLE user_account (
+	email          TEXT PRIMARY KEY,
+	password_hash  TEXT NOT NULL,
+	created        TEXT NOT NULL
+);
+
+CREATE UNIQUE INDEX user_account_email_idx ON user_account (lower(email));
+
+CREATE TABLE user_session (
+	token    TEXT PRIMARY KEY,
+	email    TEXT NOT NULL REFERENCES user_account (email) ON DELETE CASCADE ON UPDATE CASCADE,
+	created  TEXT NOT NULL
+);
+
+CREATE INDEX user_session_email_idx ON user_session (email);
@@ -0,0 +1,13 @@
+-- API tokens that scripts use to act as a user. Only a hash of each token is
+-- kept. expires is empty for a token that doesn't expire.
+CREATE TABLE api_token (
+	id       TEXT PRIMARY KEY,
+	email    TEXT NOT NULL REFERENCES user_account (email) ON DELETE CASCADE ON UPDATE CASCADE,
+	name     TEXT NOT NULL,
+	hash     TEXT NOT NULL UNIQUE,
+	scope    TEXT NOT NULL,
+	created  TEXT NOT NULL,
+	expires  TEXT NOT NULL
+);
+
+CREATE INDEX api_token_email_idx ON api_token (email);
@@ -0,0 +1,5 @@
+-- Two-factor authentication: the TOTP secret a user shares with their app, or
+-- empty, and the bcrypt hashes of their unused recovery codes, separated by
+-- spaces.
+ALTER TABLE user_account ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ('');
+ALTER TABLE user_account ADD COLUMN recovery_codes TEXT NOT NULL DEFAULT ('');
@@ -0,0 +1,27 @@
+-- The full-text index of the tiddlers, which TiddlyStore.Open makes when
+-- go-sqlite3 has FTS5, rather than a migration, as it only has FTS5 when it is
+-- built with the sqlite_fts5 tag. It can be run again to fill in what is
+-- missing, such as the triggers, which go when the tiddler table is replaced.
+
+CREATE VIRTUAL TABLE IF NOT EXISTS tiddler_fts USING fts5(
+	title,
+	meta,
+	text,
+	content='tiddler',
+	content_rowid='id'
+);
+
+CREATE TRIGGER IF NOT EXISTS tiddler_fts_insert AFTER INSERT ON tiddler BEGIN
+	INSERT INTO tiddler_fts (rowid, title, meta, text) VALUES (new.id, new.title, new.meta, new.text);
+END;
+
+CREATE TRIGGER IF NOT EXISTS tiddler_fts_delete AFTER DELETE ON tiddler BEGIN
+	INSERT INTO tiddler_fts (tiddler_fts, rowid, title, meta, text) VALUES ('delete', old.id, old.title, old.meta, old.text);
+END;
+
+CREATE TRIGGER IF NOT EXISTS tiddler_fts_update AFTER UPDATE ON tiddler BEGIN
+	INSERT INTO tiddler_fts (tiddler_fts, rowid, title, meta, text) VALUES ('delete', old.id, old.title, old.meta, old.text);
+	INSERT INTO tiddler_fts (rowid, title, meta, text) VALUES (new.id, new.title, new.meta, new.text);
+END;
+
+INSERT INTO tiddler_fts (tiddler_fts) VALUES ('rebuild');
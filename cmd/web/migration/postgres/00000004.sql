@@ -0,0 +1,31 @@
+CREATE TABLE tiddler (
+	id         SERIAL PRIMARY KEY,
+	title      TEXT NOT NULL UNIQUE,
+	rev        INTEGER NOT NULL,
+	meta       TEXT NOT NULL,
+	text       TEXT NOT NULL,
+	is_system  INTEGER NOT NULL DEFAULT (0),
+	search     TSVECTOR GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', title), 'A') ||
+		to_tsvector('simple', meta) ||
+		to_tsvector('simple', text)
+	) STORED
+);
+
+CREATE INDEX tiddler_is_system_idx ON tiddler (is_system);
+CREATE INDEX tiddler_search_idx ON tiddler USING GIN (search);
+
+CREATE TABLE tiddler_revision (
+	id         SERIAL PRIMARY KEY,
+	title      TEXT NOT NULL,
+	rev        INTEGER NOT NULL,
+	meta       TEXT NOT NULL,
+	text       TEXT NOT NULL,
+	is_system  INTEGER NOT NULL DEFAULT (0),
+	created    TEXT NOT NULL,
+	deleted    INTEGER NOT NULL DEFAULT (0),
+	UNIQUE (title, rev)
+);
+
+CREATE INDEX tiddler_revision_title_idx ON tiddler_revision (title);
+CREATE INDEX tiddler_revision_deleted_idx ON tiddler_revision (deleted);
@@ -0,0 +1,35 @@
+CREATE TABLE bag (
+	name        TEXT PRIMARY KEY,
+	description TEXT NOT NULL DEFAULT ('')
+);
+
+CREATE TABLE recipe (
+	name        TEXT PRIMARY KEY,
+	description TEXT NOT NULL DEFAULT ('')
+);
+
+CREATE TABLE recipe_bag (
+	recipe     TEXT NOT NULL REFERENCES recipe (name) ON DELETE CASCADE,
+	position   INTEGER NOT NULL,
+	bag        TEXT NOT NULL REFERENCES bag (name),
+	PRIMARY KEY (recipe, position)
+);
+
+INSERT INTO bag (name) VALUES ('default');
+INSERT INTO recipe (name) VALUES ('default');
+INSERT INTO recipe_bag (recipe, position, bag) VALUES ('default', 0, 'default');
+
+-- Titles are now only unique within a bag. Everything so far goes in the
+-- default bag, including tiddlers saved with the old "bag" bag.
+ALTER TABLE tiddler ADD COLUMN bag TEXT NOT NULL DEFAULT ('default') REFERENCES bag (name);
+ALTER TABLE tiddler ALTER COLUMN bag DROP DEFAULT;
+ALTER TABLE tiddler DROP CONSTRAINT tiddler_title_key;
+ALTER TABLE tiddler ADD UNIQUE (bag, title);
+CREATE INDEX tiddler_title_idx ON tiddler (title);
+UPDATE tiddler SET meta = REPLACE(meta, '"bag":"bag"', '"bag":"default"');
+
+ALTER TABLE tiddler_revision ADD COLUMN bag TEXT NOT NULL DEFAULT ('default') REFERENCES bag (name);
+ALTER TABLE tiddler_revision ALTER COLUMN bag DROP DEFAULT;
+ALTER TABLE tiddler_revision DROP CONSTRAINT tiddler_revision_title_rev_key;
+ALTER TABLE tiddler_revision ADD UNIQUE (bag, title, rev);
+UPDATE tiddler_revision SET meta = REPLACE(meta, '"bag":"bag"', '"bag":"default"');
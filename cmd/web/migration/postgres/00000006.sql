@@ -0,0 +1,17 @@
+-- Every write to a tiddler takes the next number in a single sequence, so that
+-- clients can ask for whatever changed after the last number they saw.
+CREATE TABLE change_seq (
+	id   INTEGER PRIMARY KEY CHECK (id = 1),
+	seq  BIGINT NOT NULL
+);
+
+ALTER TABLE tiddler ADD COLUMN seq BIGINT NOT NULL DEFAULT (0);
+ALTER TABLE tiddler_revision ADD COLUMN seq BIGINT NOT NULL DEFAULT (0);
+
+-- The revisions so far are numbered in the order they were written.
+UPDATE tiddler_revision SET seq = id;
+UPDATE tiddler SET seq = COALESCE((SELECT MAX(r.seq) FROM tiddler_revision r WHERE r.bag = tiddler.bag AND r.title = tiddler.title), 0);
+INSERT INTO change_seq (id, seq) SELECT 1, COALESCE(MAX(seq), 0) FROM tiddler_revision;
+
+CREATE INDEX tiddler_seq_idx ON tiddler (seq);
+CREATE INDEX tiddler_revision_seq_idx ON tiddler_revision (seq);
@@ -0,0 +1,5 @@
+-- Users have a role, and bags a policy saying who may read and write them, as
+-- JSON. Everyone who could log in so far could do everything, so they are all
+-- admins.
+ALTER TABLE user_account ADD COLUMN role TEXT NOT NULL DEFAULT ('admin');
+ALTER TABLE bag ADD COLUMN policy TEXT NOT NULL DEFAULT ('');
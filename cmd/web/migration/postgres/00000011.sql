@@ -0,0 +1,18 @@
+-- Login sessions keep only a hash of their token, which is replaced now and
+-- then, along with the one it replaced last, and where they were last used.
+-- The sessions in user_session, which kept the tokens themselves, are moved
+-- here when the user store is opened.
+CREATE TABLE login_session (
+	id             TEXT PRIMARY KEY,
+	email          TEXT NOT NULL REFERENCES user_account (email) ON DELETE CASCADE ON UPDATE CASCADE,
+	token_hash     TEXT NOT NULL UNIQUE,
+	previous_hash  TEXT NOT NULL DEFAULT (''),
+	created        TEXT NOT NULL,
+	rotated        TEXT NOT NULL,
+	last_seen      TEXT NOT NULL,
+	ip             TEXT NOT NULL DEFAULT (''),
+	user_agent     TEXT NOT NULL DEFAULT ('')
+);
+
+CREATE INDEX login_session_email_idx ON login_session (email);
+CREATE INDEX login_session_previous_hash_idx ON login_session (previous_hash);
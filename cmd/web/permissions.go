@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/tiddlywiki"
+)
+
+// policyAny is the entry in a policy list that allows anyone who is logged in,
+// and policyRolePrefix starts the entries that allow a role.
+const (
+	policyAny        = "ANY"
+	policyRolePrefix = "R:"
+)
+
+// currentUser returns the user who is logged in, or nil.
+func currentUser(ctx context.Context) *app.User {
+	u, _ := ctx.Value(userKey).(*app.User)
+	return u
+}
+
+// userRole returns the role of u. Users from before there were roles are
+// admins.
+func userRole(u *app.User) string {
+	if u.Role == "" {
+		return app.RoleAdmin
+	}
+	return u.Role
+}
+
+// canRead reports whether u may read the tiddlers in b: an admin always may,
+// and anyone else when the bag's read policy is empty or lets them. u is nil
+// for a visitor to a public wiki, who may only read the bags that anyone may.
+func canRead(u *app.User, b app.Bag) bool {
+	if u == nil {
+		return len(b.Policy.Read) == 0
+	}
+	if userRole(u) == app.RoleAdmin {
+		return true
+	}
+	return len(b.Policy.Read) == 0 || policyAllows(u, b.Policy.Read)
+}
+
+// canWrite reports whether u may save and delete the tiddlers in b. Besides
+// being able to read the bag, they have to be a writer when the bag's write
+// policy is empty, or be let in by it otherwise, which can let in a reader.
+func canWrite(u *app.User, b app.Bag) bool {
+	if u == nil || !canRead(u, b) {
+		return false
+	}
+	if userRole(u) == app.RoleAdmin {
+		return true
+	}
+	if len(b.Policy.Write) == 0 {
+		return userRole(u) == app.RoleWriter
+	}
+	return policyAllows(u, b.Policy.Write)
+}
+
+func policyAllows(u *app.User, list []string) bool {
+	for _, entry := range list {
+		switch {
+		case entry == policyAny:
+			return true
+		case strings.HasPrefix(entry, policyRolePrefix):
+			if strings.TrimPrefix(entry, policyRolePrefix) == userRole(u) {
+				return true
+			}
+		case strings.EqualFold(entry, u.Email):
+			return true
+		}
+	}
+	return false
+}
+
+// checkPolicy returns an error for a policy that names a role that doesn't
+// exist.
+func checkPolicy(p app.Policy) error {
+	for _, entry := range append(append([]string{}, p.Read...), p.Write...) {
+		if role := strings.TrimPrefix(entry, policyRolePrefix); role != entry && !app.ValidRole(role) {
+			return fmt.Errorf("unknown role %q in policy", role)
+		}
+	}
+	return nil
+}
+
+// mayRead reports whether the user logged in may read the tiddlers in bag.
+func (s *server) mayRead(ctx context.Context, bag string) (bool, error) {
+	b, err := s.tiddlyStore.Bag(ctx, bag)
+	if err != nil {
+		return false, err
+	}
+	return canRead(currentUser(ctx), b), nil
+}
+
+// mayReadRecipe reports whether the user logged in may read every bag of rc,
+// and so may know what it is made of. An admin may read every recipe, even one
+// with a bag that doesn't exist.
+func (s *server) mayReadRecipe(ctx context.Context, rc app.Recipe) (bool, error) {
+	if u := currentUser(ctx); u != nil && userRole(u) == app.RoleAdmin {
+		return true, nil
+	}
+	for _, bag := range rc.Bags {
+		ok, err := s.mayRead(ctx, bag)
+		if errors.Is(err, app.ErrNotFound) {
+			return false, nil
+		} else if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// mayWrite reports whether the user logged in may save and delete the
+// tiddlers in bag.
+func (s *server) mayWrite(ctx context.Context, bag string) (bool, error) {
+	b, err := s.tiddlyStore.Bag(ctx, bag)
+	if err != nil {
+		return false, err
+	}
+	return canWrite(currentUser(ctx), b), nil
+}
+
+// requireWrite writes a 403 and returns false unless the user logged in may
+// write to bag.
+func (s *server) requireWrite(w http.ResponseWriter, r *http.Request, bag string) bool {
+	ok, err := s.mayWrite(r.Context(), bag)
+	if err != nil {
+		s.serverError(w, r, err)
+		return false
+	}
+	if !ok {
+		s.clientError(w, http.StatusForbidden, "you may not change the tiddlers in bag "+bag)
+		return false
+	}
+	return true
+}
+
+// isPrivate reports whether t is hidden from the visitors to a public wiki who
+// aren't logged in, for being tagged with the wiki's private tag.
+func (s *server) isPrivate(ctx context.Context, t app.Tiddler) (bool, error) {
+	if s.wiki.privateTag == "" || currentUser(ctx) != nil {
+		return false, nil
+	}
+	fields, err := tiddlywiki.Fields(t)
+	if err != nil {
+		return false, err
+	}
+	for _, tag := range tiddlywiki.ParseStringList(fields["tags"]) {
+		if tag == s.wiki.privateTag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	u := &app.User{Email: "Me@Example.com", Role: app.RoleReader}
+	tests := []struct {
+		name string
+		list []string
+		want bool
+	}{
+		{"empty", nil, false},
+		{"anyone", []string{policyAny}, true},
+		{"email", []string{"me@example.com"}, true},
+		{"email in another case", []string{"ME@EXAMPLE.COM"}, true},
+		{"someone else", []string{"you@example.com"}, false},
+		{"role", []string{"R:reader"}, true},
+		{"another role", []string{"R:writer"}, false},
+		{"one of several", []string{"you@example.com", "R:admin", "me@example.com"}, true},
+		{"role name as an email", []string{"reader"}, false},
+	}
+	for _, tt := range tests {
+		if got := policyAllows(u, tt.list); got != tt.want {
+			t.Errorf("%s: policyAllows(%v) = %v, want %v", tt.name, tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestCanReadAndWrite(t *testing.T) {
+	var (
+		visitor = (*app.User)(nil)
+		reader  = &app.User{Email: "reader@example.com", Role: app.RoleReader}
+		writer  = &app.User{Email: "writer@example.com", Role: app.RoleWriter}
+		admin   = &app.User{Email: "admin@example.com", Role: app.RoleAdmin}
+		old     = &app.User{Email: "old@example.com"}
+	)
+	open := app.Policy{}
+	private := app.Policy{Read: []string{"writer@example.com"}}
+	readersWrite := app.Policy{Write: []string{"R:reader"}}
+	writeOnlyToOne := app.Policy{Read: []string{policyAny}, Write: []string{"reader@example.com"}}
+	unreadableWrite := app.Policy{Read: []string{"admin@example.com"}, Write: []string{policyAny}}
+
+	tests := []struct {
+		name      string
+		user      *app.User
+		policy    app.Policy
+		wantRead  bool
+		wantWrite bool
+	}{
+		{"visitor, open bag", visitor, open, true, false},
+		{"visitor, private bag", visitor, private, false, false},
+		{"visitor, anyone may write", visitor, unreadableWrite, false, false},
+		{"reader, open bag", reader, open, true, false},
+		{"reader, private bag", reader, private, false, false},
+		{"reader, readers may write", reader, readersWrite, true, true},
+		{"reader, named writer", reader, writeOnlyToOne, true, true},
+		{"reader, may write but not read", reader, unreadableWrite, false, false},
+		{"writer, open bag", writer, open, true, true},
+		{"writer, named reader", writer, private, true, true},
+		{"writer, readers may write", writer, readersWrite, true, false},
+		{"writer, someone else writes", writer, writeOnlyToOne, true, false},
+		{"admin, private bag", admin, private, true, true},
+		{"admin, someone else writes", admin, writeOnlyToOne, true, true},
+		{"user without a role is an admin", old, private, true, true},
+	}
+	for _, tt := range tests {
+		b := app.Bag{Name: "bag", Policy: tt.policy}
+		if got := canRead(tt.user, b); got != tt.wantRead {
+			t.Errorf("%s: canRead = %v, want %v", tt.name, got, tt.wantRead)
+		}
+		if got := canWrite(tt.user, b); got != tt.wantWrite {
+			t.Errorf("%s: canWrite = %v, want %v", tt.name, got, tt.wantWrite)
+		}
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	tests := []struct {
+		policy app.Policy
+		ok     bool
+	}{
+		{app.Policy{}, true},
+		{app.Policy{Read: []string{policyAny, "me@example.com", "R:reader"}, Write: []string{"R:writer", "R:admin"}}, true},
+		{app.Policy{Read: []string{"R:owner"}}, false},
+		{app.Policy{Write: []string{"R:"}}, false},
+	}
+	for _, tt := range tests {
+		if err := checkPolicy(tt.policy); (err == nil) != tt.ok {
+			t.Errorf("checkPolicy(%+v) = %v, want ok %v", tt.policy, err, tt.ok)
+		}
+	}
+}
@@ -3,25 +3,46 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/etitcombe/logifymw"
+	app "github.com/etitcombe/tiddlypom"
 )
 
 func (s *server) registerRoutes() {
 	mux := http.NewServeMux()
 	addIcons(mux)
-	mux.Handle("/", s.authenticate(s.requireAuthentication(s.handleHome())))
-	mux.Handle("/bags/default/tiddlers/", s.authenticate(s.requireAuthentication(s.handleDelete())))
-	mux.Handle("/bags/bag/tiddlers/", s.authenticate(s.requireAuthentication(s.handleDelete())))
+	mux.Handle("/", s.authenticate(s.allowVisitors(s.handleHome())))
+	mux.Handle("/bags", s.authenticate(s.requireAuthentication(s.handleBagList())))
+	mux.Handle("/bags/", s.authenticate(s.allowVisitors(s.handleSpaces("bags"))))
+	mux.Handle("/changes", s.authenticate(s.requireAuthentication(s.handleChanges())))
+	mux.Handle("/export", s.authenticate(s.requireAuthentication(s.handleExport())))
+	mux.Handle("/import", s.authenticate(s.requireAuthentication(s.handleImport())))
 	mux.Handle("/login/", s.handleLogin())
 	mux.Handle("/logout/", s.handleLogout())
-	mux.Handle("/recipes/default/tiddlers/", s.authenticate(s.requireAuthentication(s.handleTiddler())))
-	mux.Handle("/recipes/default/tiddlers.json", s.authenticate(s.requireAuthentication(s.handleList())))
-	mux.Handle("/status", s.authenticate(s.requireAuthentication(s.handleStatus())))
+	mux.Handle("/logout/everywhere", s.authenticate(s.requireAuthentication(s.handleLogoutEverywhere())))
+	mux.Handle("/recipes", s.authenticate(s.requireAuthentication(s.handleRecipeList())))
+	mux.Handle("/recipes/", s.authenticate(s.allowVisitors(s.handleSpaces("recipes"))))
+	mux.Handle("/search", s.authenticate(s.requireAuthentication(s.handleSearch())))
+	mux.Handle("/status", s.authenticate(s.allowVisitors(s.handleStatus())))
+	mux.Handle("/tokens", s.authenticate(s.requireAuthentication(s.handleTokens())))
+	mux.Handle("/tokens/", s.authenticate(s.requireAuthentication(s.handleTokens())))
 
-	s.router = s.recoverPanicMw(logifymw.LogIt2(s.infoLog, headersMw(mux)))
+	logged := s.recoverPanicMw(logifymw.LogIt2(s.infoLog, headersMw(mux)))
+
+	// The event stream is flushed as it goes, which the logging middleware's
+	// response writer can't do, so it goes around it.
+	events := s.recoverPanicMw(headersMw(s.authenticate(s.allowVisitors(s.handleEvents()))))
+
+	s.router = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			events.ServeHTTP(w, r)
+			return
+		}
+		logged.ServeHTTP(w, r)
+	})
 }
 
 func addIcons(mux *http.ServeMux) {
@@ -43,29 +64,72 @@ func addFileHandler(mux *http.ServeMux, file string) {
 	})
 }
 
+// authenticate finds the user that a request is from, by the API token in its
+// Authorization header or else by its remember cookie, and puts them in its
+// context. A remember cookie whose session has ended is ignored, and one whose
+// token is due to be replaced gets a new one. A request with a token that
+// isn't valid is turned away rather than being treated as anonymous, and one
+// with a read token may only read. A request without a token that changes
+// things has to come from TiddlyWiki, as its X-Requested-With header shows.
 func (s *server) authenticate(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie(rememberCookieName)
+		if token, ok := bearerToken(r); ok {
+			u, t, err := s.userStore.ByAPIToken(token)
+			if err != nil || t.Expired(time.Now()) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				s.clientError(w, http.StatusUnauthorized, "the API token is not valid")
+				return
+			}
+			if t.Scope != app.ScopeWrite && r.Method != http.MethodGet && r.Method != http.MethodHead {
+				s.clientError(w, http.StatusForbidden, "the API token may only read")
+				return
+			}
+
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, adminCheckKey, userRole(u) == app.RoleAdmin)
+			ctx = context.WithValue(ctx, userKey, u)
+			ctx = context.WithValue(ctx, apiTokenKey, t)
+			h.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if !s.checkRequestedWith(w, r) {
+			return
+		}
+
+		c, err := r.Cookie(s.wiki.cookie)
 		if err != nil {
 			// When the cookie doesn't exist the err will be "http: named cookie not present"
 			h.ServeHTTP(w, r)
 			return
 		}
 
-		_, err = s.userStore.ByRememberToken(c.Value)
-		if err != nil {
-			log.Println("remember token not found", c.Value, err)
+		u, t := s.sessionUser(w, r, c.Value)
+		if u == nil {
 			h.ServeHTTP(w, r)
 			return
 		}
 
 		ctx := r.Context()
-		ctx = context.WithValue(ctx, adminCheckKey, true)
+		ctx = context.WithValue(ctx, adminCheckKey, userRole(u) == app.RoleAdmin)
+		ctx = context.WithValue(ctx, userKey, u)
+		ctx = context.WithValue(ctx, sessionKey, t.ID)
 		r = r.WithContext(ctx)
 		h.ServeHTTP(w, r)
 	})
 }
 
+// bearerToken returns the API token in the Authorization header of r, if it
+// has one.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	v := r.Header.Get("Authorization")
+	if len(v) <= len(prefix) || !strings.EqualFold(v[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(v[len(prefix):]), true
+}
+
 func headersMw(next http.Handler) http.Handler {
 	var headers = map[string]string{
 		"Feature-Policy":            "camera 'none';fullscreen 'self';geolocation 'none';gyroscope 'none';magnetometer 'none';microphone 'none';midi 'none';payment 'none';sync-xhr 'none';",
@@ -99,8 +163,26 @@ func (s *server) recoverPanicMw(next http.Handler) http.Handler {
 
 func (s *server) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !s.isAdmin(r) {
-			http.Redirect(w, r, "/login/", http.StatusFound)
+		if currentUser(r.Context()) == nil {
+			http.Redirect(w, r, s.wiki.base+"/login/", http.StatusFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowVisitors lets visitors who aren't logged in read a public wiki, though
+// without changing anything, and otherwise requires authentication. Handlers
+// find no user in the context of a visitor's request.
+func (s *server) allowVisitors(next http.Handler) http.Handler {
+	auth := s.requireAuthentication(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.wiki.public || currentUser(r.Context()) != nil {
+			auth.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			s.clientError(w, http.StatusUnauthorized, "log in to make changes")
 			return
 		}
 		next.ServeHTTP(w, r)
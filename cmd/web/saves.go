@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+const (
+	// savedRevisionTime is how long the revision that a client saved is
+	// remembered, and maxSavedRevisions how many are remembered at once.
+	savedRevisionTime = 24 * time.Hour
+	maxSavedRevisions = 10000
+)
+
+type savedKey struct {
+	client string
+	bag    string
+	title  string
+}
+
+type savedRevision struct {
+	rev   int
+	saved time.Time
+}
+
+// savedRevisions remembers the revision of each tiddler that a client, a login
+// session or an API token, saved last. The servers of a wiki share it, so that
+// it doesn't matter which of them a save went through.
+type savedRevisions struct {
+	mu   sync.Mutex
+	revs map[savedKey]savedRevision
+}
+
+func newSavedRevisions() *savedRevisions {
+	return &savedRevisions{revs: map[savedKey]savedRevision{}}
+}
+
+// add remembers that client saved rev of title in bag. When too many are
+// remembered, the ones that have expired are forgotten along the way and then,
+// if there are still too many, the oldest.
+func (sr *savedRevisions) add(client, bag, title string, rev int) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	now := time.Now()
+	key := savedKey{client, bag, title}
+	if _, ok := sr.revs[key]; !ok && len(sr.revs) >= maxSavedRevisions {
+		var oldest savedKey
+		for k, v := range sr.revs {
+			if now.Sub(v.saved) > savedRevisionTime {
+				delete(sr.revs, k)
+			} else if oldest == (savedKey{}) || v.saved.Before(sr.revs[oldest].saved) {
+				oldest = k
+			}
+		}
+		if len(sr.revs) >= maxSavedRevisions {
+			delete(sr.revs, oldest)
+		}
+	}
+	sr.revs[key] = savedRevision{rev: rev, saved: now}
+}
+
+// get returns the revision of title in bag that client saved last.
+func (sr *savedRevisions) get(client, bag, title string) (int, bool) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	v, ok := sr.revs[savedKey{client, bag, title}]
+	if !ok || time.Since(v.saved) > savedRevisionTime {
+		return 0, false
+	}
+	return v.rev, true
+}
+
+// saveClient returns who a request's saves are remembered for: the login
+// session that it is part of, which keeps its ID when its token is replaced,
+// or the API token that it was made with. It is empty for visitors.
+func saveClient(ctx context.Context) string {
+	if id, ok := ctx.Value(sessionKey).(string); ok {
+		return "session/" + id
+	}
+	if t, ok := ctx.Value(apiTokenKey).(*app.APIToken); ok {
+		return "token/" + t.ID
+	}
+	return ""
+}
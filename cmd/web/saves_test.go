@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+func TestSavedRevisions(t *testing.T) {
+	sr := newSavedRevisions()
+	sr.add("session/a", "default", "Notes", 2)
+	if rev, ok := sr.get("session/a", "default", "Notes"); !ok || rev != 2 {
+		t.Errorf("get = %d, %v, want 2", rev, ok)
+	}
+	if _, ok := sr.get("session/b", "default", "Notes"); ok {
+		t.Error("another client got the revision")
+	}
+
+	sr.revs[savedKey{"session/a", "default", "Notes"}] = savedRevision{rev: 2, saved: time.Now().Add(-savedRevisionTime - time.Minute)}
+	if _, ok := sr.get("session/a", "default", "Notes"); ok {
+		t.Error("got an expired revision")
+	}
+
+	// Once full, the expired revision goes first and then the oldest.
+	for i := 1; i < maxSavedRevisions; i++ {
+		sr.add("session/a", "default", fmt.Sprint(i), i)
+	}
+	sr.add("session/a", "default", "New", 1)
+	if _, ok := sr.revs[savedKey{"session/a", "default", "Notes"}]; ok || len(sr.revs) != maxSavedRevisions {
+		t.Errorf("kept %d revisions, with the expired one %v", len(sr.revs), ok)
+	}
+	sr.add("session/a", "default", "Newer", 1)
+	if _, ok := sr.get("session/a", "default", "1"); ok || len(sr.revs) != maxSavedRevisions {
+		t.Errorf("kept %d revisions, with the oldest one %v", len(sr.revs), ok)
+	}
+}
+
+// TestSaveStaleRevision saves a tiddler the way TiddlyWiki does, with the
+// revision it loaded rather than the one it saved last.
+func TestSaveStaleRevision(t *testing.T) {
+	s := newTestServer(t)
+	path := "/recipes/default/tiddlers/Stale"
+
+	for _, body := range []string{
+		`{"title":"Stale","text":"one"}`,
+		`{"title":"Stale","text":"two","revision":"1"}`,
+		`{"title":"Stale","text":"three","revision":"1"}`,
+	} {
+		if w := s.do(app.RoleWriter, http.MethodPut, path, body); w.Code != http.StatusNoContent && w.Code != http.StatusOK {
+			t.Fatalf("PUT %s = %d: %s", body, w.Code, w.Body)
+		}
+	}
+	got, err := s.tiddlyStore.Get(context.Background(), app.DefaultBag, "Stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Rev != 3 || got.Text != "three" {
+		t.Errorf("tiddler is rev %d %q, want rev 3 %q", got.Rev, got.Text, "three")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/config"
 )
 
 type contextKey string
@@ -20,6 +21,10 @@ const (
 	rememberCookieName string = "tiddlywiki-remember"
 
 	adminCheckKey contextKey = "admin-check"
+	apiTokenKey   contextKey = "api-token"
+	routeKey      contextKey = "route"
+	sessionKey    contextKey = "session"
+	userKey       contextKey = "user"
 
 	etagCacheKey string = "etag"
 )
@@ -30,9 +35,17 @@ type server struct {
 
 	router http.Handler
 
+	wiki wiki
+
 	tiddlyStore app.TiddlyStore
 	userStore   app.UserStore
 
+	events *notifier
+
+	logins  *pendingLogins
+	limiter *loginLimiter
+	saves   *savedRevisions
+
 	templateCache map[string]*template.Template
 
 	rwMutex sync.RWMutex
@@ -40,19 +53,40 @@ type server struct {
 }
 
 type viewModel struct {
+	Base  string
 	Blurb template.HTML
 	Title string
 	Yield interface{}
 }
 
-func newServer(infoLog, errorLog *log.Logger, ls app.TiddlyStore, us app.UserStore) *server {
+func newServer(infoLog, errorLog *log.Logger, ls app.TiddlyStore, us app.UserStore, wk wiki) *server {
 	srv := &server{
 		infoLog:  infoLog,
 		errorLog: errorLog,
+		wiki:     wk,
 	}
 	srv.rwMutex = sync.RWMutex{}
 	srv.tiddlyStore = ls
 	srv.userStore = us
+	srv.events = wk.events
+	srv.logins = wk.logins
+	if srv.logins == nil {
+		srv.logins = newPendingLogins()
+	}
+	srv.limiter = wk.limiter
+	if srv.limiter == nil {
+		srv.limiter, _ = newLoginLimiter(config.LoginLimits{})
+	}
+	srv.saves = wk.saves
+	if srv.saves == nil {
+		srv.saves = newSavedRevisions()
+	}
+	if srv.wiki.sessions == (sessionLimits{}) {
+		srv.wiki.sessions, _ = newSessionLimits(config.SessionLimits{})
+	}
+	if srv.events == nil {
+		srv.events = newNotifier()
+	}
 	srv.parseTemplates()
 	srv.registerRoutes()
 	srv.cache = make(map[string]interface{})
@@ -113,6 +147,7 @@ func (s *server) render(w http.ResponseWriter, r *http.Request, name, title stri
 	ts.Funcs(template.FuncMap{"isAdmin": isAdminFunc})
 
 	viewModel := viewModel{
+		Base:  s.wiki.base,
 		Blurb: "<!-- Heaven is your fucking life. -->",
 		Title: title,
 		Yield: data,
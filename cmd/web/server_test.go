@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/db"
+)
+
+// testServer is a server for the main wiki, with its tiddlers kept as files,
+// and an API token for each of its users.
+type testServer struct {
+	*server
+	tokens map[string]string
+}
+
+// newTestServer starts a server whose users are an admin, a writer and a
+// reader, all of whom may write to the server with their API tokens.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+
+	ts, err := db.NewTidStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ts.Close() })
+
+	us, err := db.NewUserStoreFile(dir, "pepper")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := map[string]string{}
+	for _, role := range []string{app.RoleAdmin, app.RoleWriter, app.RoleReader} {
+		u := &app.User{Email: role + "@example.com", Role: role}
+		if err := us.Create(u, "secret"); err != nil {
+			t.Fatal(err)
+		}
+		token, err := us.CreateAPIToken(&app.APIToken{Email: u.Email, Name: "test", Scope: app.ScopeWrite})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[role] = token
+	}
+
+	logger := log.New(ioutil.Discard, "", 0)
+	return &testServer{server: newServer(logger, logger, ts, us, mainWiki), tokens: tokens}
+}
+
+// do makes a request to the server as the user with role, or as a visitor
+// when role is empty.
+func (s *testServer) do(role, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if role != "" {
+		r.Header.Set("Authorization", "Bearer "+s.tokens[role])
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestPutTiddlerBadBody(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"not JSON", `not json`, http.StatusBadRequest},
+		{"not an object", `[1]`, http.StatusBadRequest},
+		{"null", `null`, http.StatusBadRequest},
+		{"no title", `{"text":"x"}`, http.StatusBadRequest},
+		{"title not a string", `{"title":5}`, http.StatusBadRequest},
+		{"good", `{"title":"Put","text":"x"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(app.RoleWriter, http.MethodPut, "/recipes/default/tiddlers/Put", tt.body)
+			if w.Code != tt.want {
+				t.Errorf("PUT %s = %d, want %d: %s", tt.body, w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestListExclude(t *testing.T) {
+	s := newTestServer(t)
+	if w := s.do(app.RoleWriter, http.MethodPut, "/recipes/default/tiddlers/Listed", `{"title":"Listed","text":"x","tags":"a"}`); w.Code >= 300 {
+		t.Fatalf("PUT = %d: %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", `{"bag":"default","revision":1,"tags":"a","title":"Listed"}`},
+		{"?exclude=", `{"bag":"default","revision":1,"tags":"a","text":"x","title":"Listed"}`},
+		{"?exclude=revision,tags,title", `{"bag":"default","text":"x","title":"Listed"}`},
+	}
+	for _, tt := range tests {
+		w := s.do(app.RoleWriter, http.MethodGet, "/recipes/default/tiddlers.json"+tt.query, "")
+		if got := strings.TrimSpace(w.Body.String()); got != "["+tt.want+"]" {
+			t.Errorf("list%s = %s, want [%s]", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestDeleteThroughRecipe(t *testing.T) {
+	s := newTestServer(t)
+	for _, req := range [][2]string{
+		{"/bags/templates", `{"desc":""}`},
+		{"/recipes/mixed", `{"desc":"","recipe":[["templates",""],["default",""]]}`},
+		{"/bags/templates/tiddlers/Shared", `{"title":"Shared"}`},
+		{"/bags/default/tiddlers/Own", `{"title":"Own"}`},
+	} {
+		if w := s.do(app.RoleAdmin, http.MethodPut, req[0], req[1]); w.Code >= 300 {
+			t.Fatalf("PUT %s = %d: %s", req[0], w.Code, w.Body)
+		}
+	}
+
+	tests := []struct {
+		title string
+		want  int
+		kept  bool
+	}{
+		{"Shared", http.StatusForbidden, true},
+		{"Own", http.StatusNoContent, false},
+		{"Missing", http.StatusNoContent, false},
+	}
+	for _, tt := range tests {
+		if w := s.do(app.RoleAdmin, http.MethodDelete, "/recipes/mixed/tiddlers/"+tt.title, ""); w.Code != tt.want {
+			t.Errorf("DELETE %s = %d, want %d: %s", tt.title, w.Code, tt.want, w.Body)
+		}
+		w := s.do(app.RoleAdmin, http.MethodGet, "/recipes/mixed/tiddlers/"+tt.title, "")
+		if kept := w.Code == http.StatusOK; kept != tt.kept {
+			t.Errorf("after DELETE %s, GET = %d, want it kept %v", tt.title, w.Code, tt.kept)
+		}
+	}
+}
+
+func TestChangesPaging(t *testing.T) {
+	s := newTestServer(t)
+	for _, title := range []string{"One", "Two", "Three"} {
+		if w := s.do(app.RoleWriter, http.MethodPut, "/recipes/default/tiddlers/"+title, `{"title":"`+title+`","text":"x"}`); w.Code >= 300 {
+			t.Fatalf("PUT %s = %d: %s", title, w.Code, w.Body)
+		}
+	}
+
+	var since int64
+	var titles []string
+	for page := 0; page < 3; page++ {
+		w := s.do(app.RoleReader, http.MethodGet, fmt.Sprintf("/changes?since=%d&limit=2", since), "")
+		var res struct {
+			Seq     int64                    `json:"seq"`
+			More    bool                     `json:"more"`
+			Changes []map[string]interface{} `json:"changes"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("GET /changes = %d %s: %v", w.Code, w.Body, err)
+		}
+		for _, c := range res.Changes {
+			if _, ok := c["text"]; ok {
+				t.Errorf("change %q has its text", c["title"])
+			}
+			titles = append(titles, fmt.Sprint(c["title"]))
+		}
+		if since = res.Seq; !res.More {
+			break
+		}
+	}
+	if fmt.Sprint(titles) != "[One Two Three]" {
+		t.Errorf("changes are %q, want One, Two and Three", titles)
+	}
+}
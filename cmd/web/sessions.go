@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/config"
+)
+
+// The defaults of config.SessionLimits.
+const (
+	defaultSessionIdle     = 14 * 24 * time.Hour
+	defaultSessionAbsolute = 90 * 24 * time.Hour
+	defaultSessionRotate   = 24 * time.Hour
+)
+
+// touchInterval is how often the last time a session was seen, and where
+// from, is recorded.
+const touchInterval = time.Minute
+
+// sessionLimits is how long the logins to a wiki are remembered.
+type sessionLimits struct {
+	idle     time.Duration
+	absolute time.Duration
+	rotate   time.Duration
+}
+
+// sessionsModule is a TiddlyWiki startup module, added to the page of a user
+// who is logged in, that logs them out everywhere when sessionsTab's button
+// sends tm-tiddlypom-logout-everywhere. Its request carries the
+// X-Requested-With header that cookie-authenticated writes need.
+var sessionsModule = map[string]string{
+	"title":       "$:/tiddlypom/sessions.js",
+	"type":        "application/javascript",
+	"module-type": "startup",
+	"text": `(function(){
+
+"use strict";
+
+exports.name = "tiddlypom-sessions";
+exports.platforms = ["browser"];
+exports.after = ["startup"];
+exports.synchronous = true;
+
+exports.startup = function() {
+	$tw.rootWidget.addEventListener("tm-tiddlypom-logout-everywhere",function() {
+		if(!$tw.syncadaptor || !confirm("Log out of this wiki in every browser and on every device?")) {
+			return;
+		}
+		$tw.utils.httpRequest({
+			url: $tw.syncadaptor.host + "logout/everywhere",
+			type: "POST",
+			callback: function(err) {
+				if(err) {
+					alert(err);
+					return;
+				}
+				location.href = $tw.syncadaptor.host + "login/";
+			}
+		});
+	});
+};
+
+})();
+`,
+}
+
+// sessionsTab is a tab of the control panel's Info tab with the button that
+// logs the user out everywhere.
+var sessionsTab = map[string]string{
+	"title":   "$:/tiddlypom/ui/ControlPanel/Sessions",
+	"caption": "Sessions",
+	"tags":    "$:/tags/ControlPanel/Info",
+	"text": `Log out of this wiki in every browser and on every device, such as after losing one of them. You will have to log in again here too.
+
+<$button message="tm-tiddlypom-logout-everywhere">Log out everywhere</$button>
+`,
+}
+
+// newSessionLimits reads the limits in c, with the defaults for the ones that
+// aren't set.
+func newSessionLimits(c config.SessionLimits) (sessionLimits, error) {
+	l := sessionLimits{
+		idle:     defaultSessionIdle,
+		absolute: defaultSessionAbsolute,
+		rotate:   defaultSessionRotate,
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		into  *time.Duration
+	}{
+		{"idle", c.Idle, &l.idle},
+		{"absolute", c.Absolute, &l.absolute},
+		{"rotate", c.Rotate, &l.rotate},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v <= 0 {
+			return sessionLimits{}, fmt.Errorf("sessions: cannot read %s %q", d.name, d.value)
+		}
+		*d.into = v
+	}
+	return l, nil
+}
+
+// expired reports whether the session t has ended, by going unused for too
+// long or by getting too old.
+func (l sessionLimits) expired(t *app.UserToken, now time.Time) bool {
+	return now.After(t.LastSeen.Add(l.idle)) || now.After(t.Created.Add(l.absolute))
+}
+
+// sessionUser returns the user whose session the remember token is, and the
+// session, or nil when it isn't one that is still going. It records that the
+// session has been seen and, when it is due, replaces its token with a new one
+// in the cookie.
+func (s *server) sessionUser(w http.ResponseWriter, r *http.Request, token string) (*app.User, *app.UserToken) {
+	u, t, err := s.userStore.ByRememberToken(token)
+	if err != nil {
+		s.infoLog.Println("remember token not found:", err)
+		return nil, nil
+	}
+
+	now := time.Now()
+	if s.wiki.sessions.expired(t, now) {
+		if err := s.userStore.ClearRememberToken(token); err != nil {
+			s.errorLog.Println("error clearing remember token", err)
+		}
+		return nil, nil
+	}
+
+	rotate := now.Sub(t.Rotated) > s.wiki.sessions.rotate
+	if rotate || now.Sub(t.LastSeen) > touchInterval {
+		newToken, err := s.userStore.TouchRememberToken(token, s.limiter.clientIP(r), r.UserAgent(), rotate)
+		if err != nil {
+			s.errorLog.Println("error updating session", err)
+		} else if newToken != "" {
+			s.setRememberCookie(w, newToken, t.Created)
+		}
+	}
+	return u, t
+}
+
+// setRememberCookie sets the cookie that remembers a login, to last as long as
+// a session that was started at created can.
+func (s *server) setRememberCookie(w http.ResponseWriter, token string, created time.Time) {
+	expires := created.Add(s.wiki.sessions.absolute)
+	http.SetCookie(w, &http.Cookie{
+		HttpOnly: true,
+		Name:     s.wiki.cookie,
+		Value:    token,
+		Path:     s.wiki.base + "/",
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires) / time.Second),
+		Secure:   s.wiki.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearRememberCookie tells the browser to forget the cookie that remembers a
+// login.
+func (s *server) clearRememberCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		HttpOnly: true,
+		Name:     s.wiki.cookie,
+		Path:     s.wiki.base + "/",
+		Expires:  time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
+		MaxAge:   -1,
+		Secure:   s.wiki.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// handleLogoutEverywhere ends every session of the user logged in, on every
+// device, and sends them to the login page.
+func (s *server) handleLogoutEverywhere() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		if apiTokenFromContext(r) != nil {
+			s.clientError(w, http.StatusForbidden, "API tokens may not be used to log out")
+			return
+		}
+		u := currentUser(r.Context())
+		if err := s.userStore.ClearRememberTokens(u.Email); err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+		s.infoLog.Printf("%s logged out everywhere", u.Email)
+		s.clearRememberCookie(w)
+		http.Redirect(w, r, s.wiki.base+"/login/", http.StatusFound)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/config"
+)
+
+func TestNewSessionLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits config.SessionLimits
+		want   sessionLimits
+		ok     bool
+	}{
+		{"defaults", config.SessionLimits{}, sessionLimits{defaultSessionIdle, defaultSessionAbsolute, defaultSessionRotate}, true},
+		{"all set", config.SessionLimits{Idle: "1h", Absolute: "48h", Rotate: "30m"}, sessionLimits{time.Hour, 48 * time.Hour, 30 * time.Minute}, true},
+		{"bad idle", config.SessionLimits{Idle: "a while"}, sessionLimits{}, false},
+		{"zero absolute", config.SessionLimits{Absolute: "0s"}, sessionLimits{}, false},
+		{"negative rotate", config.SessionLimits{Rotate: "-1h"}, sessionLimits{}, false},
+	}
+	for _, tt := range tests {
+		got, err := newSessionLimits(tt.limits)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("%s: newSessionLimits = %+v, %v, want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	l := sessionLimits{idle: time.Hour, absolute: 24 * time.Hour, rotate: time.Minute}
+	now := time.Date(2026, time.January, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		created  time.Time
+		lastSeen time.Time
+		want     bool
+	}{
+		{"in use", now.Add(-2 * time.Hour), now.Add(-time.Minute), false},
+		{"just within both", now.Add(-24 * time.Hour), now.Add(-time.Hour), false},
+		{"idle", now.Add(-2 * time.Hour), now.Add(-61 * time.Minute), true},
+		{"too old", now.Add(-25 * time.Hour), now.Add(-time.Minute), true},
+	}
+	for _, tt := range tests {
+		tok := &app.UserToken{Created: tt.created, LastSeen: tt.lastSeen}
+		if got := l.expired(tok, now); got != tt.want {
+			t.Errorf("%s: expired = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSessionUser(t *testing.T) {
+	s := newTestServer(t)
+	u, err := s.userStore.ByEmail("writer@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.userStore.CreateRememberToken(u, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sessionUser returns the user of the session, and a cookie with a new
+	// token when the old one is due to be replaced.
+	session := func(token string) (*app.User, string) {
+		r := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		u, _ := s.sessionUser(w, r, token)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == s.wiki.cookie {
+				return u, c.Value
+			}
+		}
+		return u, ""
+	}
+
+	if got, cookie := session(token); got == nil || got.Email != u.Email || cookie != "" {
+		t.Fatalf("session = %v, %q, want %s without a new cookie", got, cookie, u.Email)
+	}
+	if got, _ := session("not a token"); got != nil {
+		t.Errorf("session of an unknown token = %v, want nil", got)
+	}
+
+	s.wiki.sessions.rotate = time.Nanosecond
+	got, newToken := session(token)
+	if got == nil || newToken == "" || newToken == token {
+		t.Fatalf("rotated session = %v, %q, want a new token", got, newToken)
+	}
+	// A request that was already on its way with the old token still gets
+	// through for a moment.
+	if got, cookie := session(token); got == nil || cookie != "" {
+		t.Errorf("session of the replaced token = %v, %q, want the user without a new cookie", got, cookie)
+	}
+
+	s.wiki.sessions = sessionLimits{idle: time.Nanosecond, absolute: time.Hour, rotate: time.Hour}
+	time.Sleep(time.Millisecond)
+	if got, _ := session(newToken); got != nil {
+		t.Errorf("session after it went idle = %v, want nil", got)
+	}
+	if _, _, err := s.userStore.ByRememberToken(newToken); err == nil {
+		t.Error("the idle session wasn't removed")
+	}
+}
+
+func TestSessionsTab(t *testing.T) {
+	s := newTestServer(t)
+	if w := s.do(app.RoleReader, http.MethodGet, "/", ""); !strings.Contains(w.Body.String(), sessionsTab["title"]) {
+		t.Errorf("the page of a user hasn't the sessions tab: %d", w.Code)
+	}
+	if w := s.do("", http.MethodGet, "/", ""); strings.Contains(w.Body.String(), sessionsTab["title"]) {
+		t.Error("the page of a visitor has the sessions tab")
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+// legacyBag is the bag that tiddlers were said to be in before there were
+// bags. TiddlyWiki may still use it to delete tiddlers it loaded back then.
+const legacyBag = "bag"
+
+// space is where a request finds and saves tiddlers: a single bag, or the bags
+// of a recipe. Tiddlers in later bags hide those with the same title in earlier
+// bags, and tiddlers are saved to the last bag.
+type space struct {
+	recipe string // empty when the request is for a single bag
+	bags   []string
+	write  string // the last bag, even if the user may not read it
+}
+
+func (sp space) writeBag() string {
+	return sp.write
+}
+
+// route is what the path of a request under /bags/ or /recipes/ refers to.
+type route struct {
+	name      string // the bag or recipe named in the path
+	space     space
+	title     string
+	revisions bool
+	rev       int
+	restore   bool
+}
+
+func routeFrom(r *http.Request) route {
+	rt, _ := r.Context().Value(routeKey).(route)
+	return rt
+}
+
+// handleSpaces routes the requests for a bag or recipe, and for the tiddlers
+// in it, to their handlers. kind is "bags" or "recipes".
+//
+// The path is split on the escaped slashes, so a title containing a slash must
+// have it escaped as %2F, as TiddlyWiki does.
+func (s *server) handleSpaces(kind string) http.HandlerFunc {
+	resource := s.handleBag()
+	if kind == "recipes" {
+		resource = s.handleRecipe()
+	}
+	del := s.handleDelete()
+	deletedList := s.handleDeletedList()
+	list := s.handleList()
+	revisions := s.handleRevisions()
+	tiddler := s.handleTiddler()
+
+	prefix := "/" + kind + "/"
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), prefix), "/")
+		for i, p := range parts {
+			var err error
+			if parts[i], err = url.PathUnescape(p); err != nil {
+				s.clientError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		rt := route{name: parts[0]}
+		if kind == "bags" && rt.name == legacyBag {
+			rt.name = app.DefaultBag
+		}
+
+		var h http.HandlerFunc
+		public := false // whether visitors to a public wiki may have it
+		switch {
+		case len(parts) == 1:
+			h = resource
+		case len(parts) == 2 && parts[1] == "tiddlers.json":
+			h = list
+			public = true
+		case len(parts) == 2 && parts[1] == "deleted.json":
+			h = deletedList
+		case len(parts) == 3 && parts[1] == "tiddlers" && r.Method == http.MethodDelete:
+			h = del
+		case len(parts) == 3 && parts[1] == "tiddlers":
+			h = tiddler
+			public = true
+		case len(parts) >= 4 && len(parts) <= 6 && parts[1] == "tiddlers" && parts[3] == "revisions":
+			h = revisions
+			rt.revisions = true
+			if len(parts) >= 5 {
+				rev, err := strconv.Atoi(parts[4])
+				if err != nil || rev < 1 {
+					s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+					return
+				}
+				rt.rev = rev
+			}
+			if len(parts) == 6 {
+				if parts[5] != "restore" {
+					s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+					return
+				}
+				rt.restore = true
+			}
+		default:
+			s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+		if len(parts) >= 3 {
+			rt.title = parts[2]
+		}
+		if !public && currentUser(r.Context()) == nil {
+			http.Redirect(w, r, s.wiki.base+"/login/", http.StatusFound)
+			return
+		}
+
+		// The bag or recipe itself may be about to be created, but everything
+		// else needs it to exist.
+		if len(parts) > 1 {
+			var err error
+			rt.space, err = s.findSpace(r.Context(), kind, rt.name)
+			if errors.Is(err, app.ErrNotFound) {
+				s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+				return
+			} else if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+		}
+
+		h(w, r.WithContext(context.WithValue(r.Context(), routeKey, rt)))
+	}
+}
+
+// findSpace looks up the bag or recipe named name as the user logged in sees
+// it, without the bags that they may not read. A bag that they may not read, or
+// a recipe without any that they may, isn't found.
+func (s *server) findSpace(ctx context.Context, kind, name string) (space, error) {
+	if kind == "bags" {
+		b, err := s.tiddlyStore.Bag(ctx, name)
+		if err != nil {
+			return space{}, err
+		}
+		if !canRead(currentUser(ctx), b) {
+			return space{}, app.ErrNotFound
+		}
+		return space{bags: []string{name}, write: name}, nil
+	}
+
+	recipe, err := s.tiddlyStore.Recipe(ctx, name)
+	if err != nil {
+		return space{}, err
+	}
+	if len(recipe.Bags) == 0 {
+		return space{}, app.ErrNotFound
+	}
+	sp := space{recipe: name, write: recipe.Bags[len(recipe.Bags)-1]}
+	for _, bag := range recipe.Bags {
+		ok, err := s.mayRead(ctx, bag)
+		if err != nil {
+			return space{}, err
+		}
+		if ok {
+			sp.bags = append(sp.bags, bag)
+		}
+	}
+	if len(sp.bags) == 0 {
+		return space{}, app.ErrNotFound
+	}
+	return sp, nil
+}
+
+// lookup finds a tiddler in a space, looking in the last bag first. A private
+// tiddler isn't found for a visitor to a public wiki.
+func (s *server) lookup(ctx context.Context, sp space, title string) (app.Tiddler, error) {
+	for i := len(sp.bags) - 1; i >= 0; i-- {
+		t, err := s.tiddlyStore.Get(ctx, sp.bags[i], title)
+		if err == nil {
+			if private, err := s.isPrivate(ctx, t); err != nil {
+				return app.Tiddler{}, err
+			} else if private {
+				return app.Tiddler{}, app.ErrNotFound
+			}
+			return t, nil
+		} else if !errors.Is(err, app.ErrNotFound) {
+			return app.Tiddler{}, err
+		}
+	}
+	return app.Tiddler{}, app.ErrNotFound
+}
+
+// spaceList lists the tiddlers in a space, other than system tiddlers, with
+// their text when text is true. Where more than one bag has a tiddler with the
+// same title, the one from the last bag is listed. Visitors to a public wiki
+// don't get the private tiddlers.
+func (s *server) spaceList(ctx context.Context, sp space, text bool) ([]app.Tiddler, error) {
+	var tiddlers []app.Tiddler
+	index := map[string]int{}
+	for _, bag := range sp.bags {
+		var list []app.Tiddler
+		var err error
+		if text {
+			list, err = s.tiddlyStore.GetAll(ctx, bag)
+		} else {
+			list, err = s.tiddlyStore.GetList(ctx, bag)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range list {
+			if t.IsSystem {
+				continue
+			}
+			if i, ok := index[t.Title]; ok {
+				tiddlers[i] = t
+				continue
+			}
+			index[t.Title] = len(tiddlers)
+			tiddlers = append(tiddlers, t)
+		}
+	}
+	if s.wiki.privateTag == "" || currentUser(ctx) != nil {
+		return tiddlers, nil
+	}
+
+	public := tiddlers[:0]
+	for _, t := range tiddlers {
+		private, err := s.isPrivate(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		if !private {
+			public = append(public, t)
+		}
+	}
+	return public, nil
+}
+
+// historyBag returns the bag whose history of a tiddler a request for its
+// revisions is about: the bag the tiddler is in, or failing that the last bag
+// that has a history for it, or else the bag it would be saved to. Only the
+// bags the user may read are looked at, so that is ErrNotFound when they may
+// not read the bag it would be saved to.
+func (s *server) historyBag(ctx context.Context, sp space, title string) (string, error) {
+	t, err := s.lookup(ctx, sp, title)
+	if err == nil {
+		return t.Bag, nil
+	} else if !errors.Is(err, app.ErrNotFound) {
+		return "", err
+	}
+	for i := len(sp.bags) - 1; i >= 0; i-- {
+		revs, err := s.tiddlyStore.Revisions(ctx, sp.bags[i], title)
+		if err != nil {
+			return "", err
+		}
+		if len(revs) > 0 {
+			return sp.bags[i], nil
+		}
+	}
+	if len(sp.bags) > 0 && sp.bags[len(sp.bags)-1] == sp.writeBag() {
+		return sp.writeBag(), nil
+	}
+	return "", app.ErrNotFound
+}
+
+// hidden reports whether a tiddler in bag is hidden by one with the same title
+// in a later bag of the space.
+func (s *server) hidden(ctx context.Context, sp space, bag, title string) (bool, error) {
+	t, err := s.lookup(ctx, sp, title)
+	if errors.Is(err, app.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return t.Bag != bag, nil
+}
+
+func (s *server) handleBagList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+
+		bags, err := s.tiddlyStore.Bags(r.Context())
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+		u := currentUser(r.Context())
+		names := make([]string, 0, len(bags))
+		for _, b := range bags {
+			if canRead(u, b) {
+				names = append(names, b.Name)
+			}
+		}
+		s.writeJSON(w, r, names)
+	}
+}
+
+func (s *server) handleRecipeList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+
+		recipes, err := s.tiddlyStore.Recipes(r.Context())
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+		names := make([]string, 0, len(recipes))
+		for _, rc := range recipes {
+			ok, err := s.mayReadRecipe(r.Context(), rc)
+			if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			if ok {
+				names = append(names, rc.Name)
+			}
+		}
+		sort.Strings(names)
+		s.writeJSON(w, r, names)
+	}
+}
+
+func (s *server) handleBag() http.HandlerFunc {
+	// bag is a bag in the TiddlyWeb JSON format.
+	type bag struct {
+		Name   string     `json:"name,omitempty"`
+		Desc   string     `json:"desc"`
+		Policy app.Policy `json:"policy"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := routeFrom(r).name
+
+		switch r.Method {
+		case http.MethodGet:
+			b, err := s.tiddlyStore.Bag(r.Context(), name)
+			if errors.Is(err, app.ErrNotFound) || err == nil && !canRead(currentUser(r.Context()), b) {
+				s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+				return
+			} else if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			s.writeJSON(w, r, bag{Name: b.Name, Desc: b.Description, Policy: b.Policy})
+
+		case http.MethodPut:
+			if !s.isAdmin(r) {
+				s.clientError(w, http.StatusForbidden, "only admins may change bags")
+				return
+			}
+			if !validSpaceName(name) {
+				s.clientError(w, http.StatusBadRequest, "invalid bag name")
+				return
+			}
+			var b bag
+			if !s.readJSON(w, r, &b) {
+				return
+			}
+			if err := checkPolicy(b.Policy); err != nil {
+				s.clientError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if err := s.tiddlyStore.PutBag(r.Context(), app.Bag{Name: name, Description: b.Desc, Policy: b.Policy}); err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		}
+	}
+}
+
+func (s *server) handleRecipe() http.HandlerFunc {
+	// recipe is a recipe in the TiddlyWeb JSON format, where each line of the
+	// recipe is a bag and a filter. Filters aren't supported and must be empty.
+	type recipe struct {
+		Name   string      `json:"name,omitempty"`
+		Desc   string      `json:"desc"`
+		Recipe [][2]string `json:"recipe"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := routeFrom(r).name
+
+		switch r.Method {
+		case http.MethodGet:
+			rc, err := s.tiddlyStore.Recipe(r.Context(), name)
+			if err == nil {
+				var ok bool
+				if ok, err = s.mayReadRecipe(r.Context(), rc); err == nil && !ok {
+					err = app.ErrNotFound
+				}
+			}
+			if errors.Is(err, app.ErrNotFound) {
+				s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+				return
+			} else if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			out := recipe{Name: rc.Name, Desc: rc.Description, Recipe: [][2]string{}}
+			for _, bag := range rc.Bags {
+				out.Recipe = append(out.Recipe, [2]string{bag, ""})
+			}
+			s.writeJSON(w, r, out)
+
+		case http.MethodPut:
+			if !s.isAdmin(r) {
+				s.clientError(w, http.StatusForbidden, "only admins may change recipes")
+				return
+			}
+			if !validSpaceName(name) {
+				s.clientError(w, http.StatusBadRequest, "invalid recipe name")
+				return
+			}
+			var in recipe
+			if !s.readJSON(w, r, &in) {
+				return
+			}
+			if len(in.Recipe) == 0 {
+				s.clientError(w, http.StatusBadRequest, "a recipe needs at least one bag")
+				return
+			}
+			rc := app.Recipe{Name: name, Description: in.Desc}
+			for _, line := range in.Recipe {
+				if line[1] != "" {
+					s.clientError(w, http.StatusBadRequest, "recipe filters are not supported")
+					return
+				}
+				rc.Bags = append(rc.Bags, line[0])
+			}
+			err := s.tiddlyStore.PutRecipe(r.Context(), rc)
+			if errors.Is(err, app.ErrNotFound) {
+				s.clientError(w, http.StatusBadRequest, "the recipe names a bag that does not exist")
+				return
+			} else if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		}
+	}
+}
+
+// userRecipe returns the recipe that TiddlyWiki should use for the logged in
+// user: the recipe with the same name as their email address if there is one,
+// and the default recipe otherwise.
+func (s *server) userRecipe(r *http.Request) string {
+	u, ok := r.Context().Value(userKey).(*app.User)
+	if !ok {
+		return app.DefaultRecipe
+	}
+	if _, err := s.tiddlyStore.Recipe(r.Context(), u.Email); err != nil {
+		return app.DefaultRecipe
+	}
+	return u.Email
+}
+
+// validSpaceName reports whether name can be used for a new bag or recipe.
+// Bags may be kept in folders named after them, so names that could escape the
+// folder, or be hidden, aren't allowed.
+func validSpaceName(name string) bool {
+	return name != "" && name != legacyBag &&
+		!strings.ContainsAny(name, `/\`) &&
+		!strings.HasPrefix(name, ".")
+}
+
+func (s *server) readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.clientError(w, http.StatusBadRequest, "cannot read data: "+err.Error())
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		s.clientError(w, http.StatusBadRequest, "cannot read JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *server) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+// apiTokenFromContext returns the API token that the request was authenticated
+// with, or nil when it was authenticated some other way.
+func apiTokenFromContext(r *http.Request) *app.APIToken {
+	t, _ := r.Context().Value(apiTokenKey).(*app.APIToken)
+	return t
+}
+
+// handleTokens manages the API tokens of the user logged in. GET /tokens lists
+// them, POST /tokens creates one and sends back the token, which can't be got
+// again, and DELETE /tokens/{id} revokes one. A token can't be used to manage
+// tokens, so that one that leaks can't be used to make more.
+func (s *server) handleTokens() http.HandlerFunc {
+	// token is an API token as it is listed, without the token itself.
+	type token struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Scope   string `json:"scope"`
+		Created string `json:"created"`
+		Expires string `json:"expires,omitempty"`
+		Token   string `json:"token,omitempty"`
+	}
+	toJSON := func(t app.APIToken) token {
+		out := token{ID: t.ID, Name: t.Name, Scope: t.Scope, Created: t.Created.Format(time.RFC3339)}
+		if !t.Expires.IsZero() {
+			out.Expires = t.Expires.Format(time.RFC3339)
+		}
+		return out
+	}
+
+	// newToken is what it takes to create a token. ExpiresIn is a duration
+	// such as "720h", and the token doesn't expire without it.
+	type newToken struct {
+		Name      string `json:"name"`
+		Scope     string `json:"scope"`
+		ExpiresIn string `json:"expires_in"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiTokenFromContext(r) != nil {
+			s.clientError(w, http.StatusForbidden, "API tokens may not be used to manage API tokens")
+			return
+		}
+		u := currentUser(r.Context())
+		id := strings.TrimPrefix(r.URL.Path, "/tokens")
+		id = strings.TrimPrefix(id, "/")
+
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			tokens, err := s.userStore.APITokens(u.Email)
+			if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			list := make([]token, 0, len(tokens))
+			for _, t := range tokens {
+				list = append(list, toJSON(t))
+			}
+			s.writeJSON(w, r, list)
+
+		case r.Method == http.MethodPost && id == "":
+			var in newToken
+			if !s.readJSON(w, r, &in) {
+				return
+			}
+			if strings.TrimSpace(in.Name) == "" {
+				s.clientError(w, http.StatusBadRequest, "a token needs a name")
+				return
+			}
+			if in.Scope == "" {
+				in.Scope = app.ScopeRead
+			}
+			if !app.ValidScope(in.Scope) {
+				s.clientError(w, http.StatusBadRequest, "the scope must be read or write")
+				return
+			}
+			t := app.APIToken{Email: u.Email, Name: in.Name, Scope: in.Scope}
+			if in.ExpiresIn != "" {
+				d, err := time.ParseDuration(in.ExpiresIn)
+				if err != nil || d <= 0 {
+					s.clientError(w, http.StatusBadRequest, "cannot read expires_in")
+					return
+				}
+				t.Expires = time.Now().UTC().Add(d).Truncate(time.Second)
+			}
+
+			secret, err := s.userStore.CreateAPIToken(&t)
+			if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			out := toJSON(t)
+			out.Token = secret
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(out)
+
+		case r.Method == http.MethodDelete && id != "":
+			tokens, err := s.userStore.APITokens(u.Email)
+			if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			found := false
+			for _, t := range tokens {
+				found = found || t.ID == id
+			}
+			if !found {
+				s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+				return
+			}
+			if err := s.userStore.DeleteAPIToken(u.Email, id); err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case id == "" || r.Method == http.MethodDelete:
+			s.clientError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+
+		default:
+			s.clientError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/rand"
+	"github.com/etitcombe/tiddlypom/totp"
+)
+
+const (
+	// pendingLoginTime is how long someone who has given their password has
+	// to give the code from their app, and pendingLoginAttempts how many
+	// codes they may try, before they have to start again.
+	pendingLoginTime     = 5 * time.Minute
+	pendingLoginAttempts = 5
+)
+
+type pendingLogin struct {
+	email    string
+	expires  time.Time
+	attempts int
+}
+
+// pendingLogins keeps track of the logins that are waiting for a code, by a
+// random token that the login page passes back, and of the last step of each
+// user's codes that was used, so that a code can't be used twice.
+type pendingLogins struct {
+	mu       sync.Mutex
+	logins   map[string]*pendingLogin
+	lastStep map[string]int64
+}
+
+func newPendingLogins() *pendingLogins {
+	return &pendingLogins{logins: map[string]*pendingLogin{}, lastStep: map[string]int64{}}
+}
+
+// add starts a login for email that is waiting for a code, and returns its
+// token. Logins that have expired are forgotten along the way.
+func (p *pendingLogins) add(email string) (string, error) {
+	token, err := rand.RememberToken()
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for t, l := range p.logins {
+		if now.After(l.expires) {
+			delete(p.logins, t)
+		}
+	}
+	p.logins[token] = &pendingLogin{email: email, expires: now.Add(pendingLoginTime)}
+	return token, nil
+}
+
+// email returns the email of the user whose login is waiting as token.
+func (p *pendingLogins) email(token string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l, ok := p.logins[token]
+	if !ok || time.Now().After(l.expires) {
+		return "", false
+	}
+	return l.email, true
+}
+
+// fail counts a wrong code against the login, and ends it when it has had
+// too many.
+func (p *pendingLogins) fail(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.logins[token]; ok {
+		l.attempts++
+		if l.attempts >= pendingLoginAttempts {
+			delete(p.logins, token)
+		}
+	}
+}
+
+func (p *pendingLogins) remove(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.logins, token)
+}
+
+// useStep records that the code of email for step has been used, and reports
+// false when it, or a later one, already had been.
+func (p *pendingLogins) useStep(email string, step int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if last, ok := p.lastStep[email]; ok && step <= last {
+		return false
+	}
+	p.lastStep[email] = step
+	return true
+}
+
+// loginSecondStep finishes a login that is waiting for a code, which is either
+// the code from the user's app or one of their recovery codes.
+func (s *server) loginSecondStep(w http.ResponseWriter, r *http.Request, pending string) {
+	email, ok := s.logins.email(pending)
+	if !ok {
+		s.clientError(w, http.StatusUnauthorized, "the login has expired, please log in again")
+		return
+	}
+	if s.tooManyLogins(w, r, email) {
+		return
+	}
+	u, err := s.userStore.ByEmail(email)
+	if err != nil {
+		s.clientError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	ok, err = s.checkSecondFactor(u, r.PostFormValue("code"))
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	if !ok {
+		s.logins.fail(pending)
+		s.loginFailed(r, email, "wrong code")
+		s.clientError(w, http.StatusUnauthorized, "the code is not valid")
+		return
+	}
+	s.logins.remove(pending)
+	s.logIn(w, r, u)
+}
+
+// checkSecondFactor reports whether code is the current code from the app of
+// u, and hasn't been used, or else one of their recovery codes, which is then
+// used up.
+func (s *server) checkSecondFactor(u *app.User, code string) (bool, error) {
+	if step, ok := totp.Validate(u.TOTPSecret, code, time.Now()); ok {
+		return s.logins.useStep(u.Email, step), nil
+	}
+	return s.userStore.UseRecoveryCode(u.Email, code)
+}
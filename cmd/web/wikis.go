@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/etitcombe/tiddlypom/config"
+	"github.com/etitcombe/tiddlypom/db"
+	"github.com/etitcombe/tiddlypom/tiddlywiki"
+)
+
+// wiki is where a server finds the wiki it serves and where it serves it.
+type wiki struct {
+	// base is the path the wiki is served under, which is empty when it is
+	// served at the root.
+	base string
+	// index is the index.html of the wiki.
+	index string
+	// cookie is the name of the cookie that remembers a login to the wiki.
+	cookie string
+	// embed is how many of the user's tiddlers the home page carries.
+	embed string
+	// public lets anyone read the wiki without logging in, except for the
+	// tiddlers tagged with privateTag.
+	public     bool
+	privateTag string
+	// events passes on the changes to the wiki's tiddlers. The servers of a
+	// wiki share it, and a server makes its own when it is nil.
+	events *notifier
+	// limiter keeps track of the failed logins to the wiki, logins of the
+	// logins that are waiting for a code and saves of the revisions that
+	// clients saved last. They are shared in the same way as events.
+	limiter *loginLimiter
+	logins  *pendingLogins
+	saves   *savedRevisions
+	// sessions is how long logins to the wiki are remembered.
+	sessions sessionLimits
+	// secureCookies keeps the cookies of the wiki to HTTPS.
+	secureCookies bool
+}
+
+// mainWiki is the wiki described by .config, which is served at the root.
+var mainWiki = wiki{index: "index.html", cookie: rememberCookieName}
+
+// wikiRouter hands each request to the server of the wiki it is for. A request
+// to one of a wiki's hosts goes to that wiki, as does a request under
+// /w/{name}/. Anything else goes to the main wiki.
+type wikiRouter struct {
+	main   http.Handler
+	byHost map[string]http.Handler
+	byName map[string]http.Handler
+	stores []db.Store
+}
+
+// openWikis opens the store of every wiki in the registry and returns a router
+// that serves them alongside main.
+func openWikis(infoLog, errorLog *log.Logger, main http.Handler, c config.Config) (*wikiRouter, error) {
+	wikis, err := config.LoadWikis(config.WikisFile)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := newSessionLimits(c.Sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	wr := &wikiRouter{
+		main:   main,
+		byHost: map[string]http.Handler{},
+		byName: map[string]http.Handler{},
+	}
+
+	for _, w := range wikis {
+		ts, err := db.OpenTiddlyStore(w.Database, errorLog)
+		if err != nil {
+			wr.Close()
+			return nil, err
+		}
+		wr.stores = append(wr.stores, ts)
+
+		us, err := db.OpenUserStore(ts, w.Dir, c.Pepper)
+		if err != nil {
+			wr.Close()
+			return nil, err
+		}
+
+		if err := checkEmbed(w.Embed); err != nil {
+			wr.Close()
+			return nil, fmt.Errorf("wiki %s: %w", w.Name, err)
+		}
+
+		limiter, err := newLoginLimiter(c.Login)
+		if err != nil {
+			wr.Close()
+			return nil, err
+		}
+
+		wk := wiki{
+			base:          config.WikiPrefix + w.Name,
+			index:         w.Index,
+			cookie:        rememberCookieName + "-" + w.Name,
+			embed:         w.Embed,
+			public:        w.Public,
+			privateTag:    w.PrivateTag,
+			events:        newNotifier(),
+			limiter:       limiter,
+			logins:        newPendingLogins(),
+			saves:         newSavedRevisions(),
+			sessions:      sessions,
+			secureCookies: !c.InsecureCookies,
+		}
+		wr.byName[w.Name] = http.StripPrefix(wk.base, newServer(infoLog, errorLog, ts, us, wk))
+
+		if len(w.Hosts) > 0 {
+			wk.base = ""
+			srv := newServer(infoLog, errorLog, ts, us, wk)
+			for _, host := range w.Hosts {
+				wr.byHost[strings.ToLower(host)] = srv
+			}
+		}
+	}
+	return wr, nil
+}
+
+func (wr *wikiRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if h, ok := wr.byHost[host]; ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, config.WikiPrefix) {
+		name := strings.TrimPrefix(r.URL.Path, config.WikiPrefix)
+		rest := ""
+		if i := strings.Index(name, "/"); i >= 0 {
+			name, rest = name[:i], name[i:]
+		}
+		h, ok := wr.byName[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if rest == "" {
+			http.Redirect(w, r, config.WikiPrefix+name+"/", http.StatusMovedPermanently)
+			return
+		}
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	wr.main.ServeHTTP(w, r)
+}
+
+// Close closes the stores of the wikis.
+func (wr *wikiRouter) Close() {
+	for _, ts := range wr.stores {
+		ts.Close()
+	}
+}
+
+// serveIndex serves the index.html of the wiki with tiddlers added to its store
+// area, along with the module that listens for changes on the server. When the
+// wiki is served under a path prefix, TiddlyWiki is told about it with a
+// $:/config/tiddlyweb/host tiddler so that it syncs against the wiki rather
+// than the root.
+func (s *server) serveIndex(w http.ResponseWriter, r *http.Request, tiddlers []map[string]string) {
+	f, err := os.Open(s.wiki.index)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	page, err := ioutil.ReadAll(f)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	divs := make([]string, 0, len(tiddlers)+4)
+	divs = append(divs, tiddlywiki.TiddlerDiv(eventsModule))
+	if currentUser(r.Context()) != nil {
+		divs = append(divs, tiddlywiki.TiddlerDiv(sessionsModule), tiddlywiki.TiddlerDiv(sessionsTab))
+	}
+	if s.wiki.base != "" {
+		divs = append(divs, tiddlywiki.TiddlerDiv(map[string]string{
+			"title": "$:/config/tiddlyweb/host",
+			"text":  "$protocol$//$host$" + s.wiki.base + "/",
+		}))
+	}
+	for _, fields := range tiddlers {
+		divs = append(divs, tiddlywiki.TiddlerDiv(fields))
+	}
+	page, err = tiddlywiki.AddTiddlers(page, divs...)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	// A page with tiddlers in it is only as current as they are, which the
+	// modification time of the file says nothing about.
+	modTime := fi.ModTime()
+	if len(tiddlers) > 0 {
+		modTime = time.Time{}
+	}
+	http.ServeContent(w, r, filepath.Base(s.wiki.index), modTime, bytes.NewReader(page))
+}
@@ -8,19 +8,72 @@ import (
 )
 
 // DbConfig represents the configuration settings for the database.
+//
+// Driver is either "sqlite3", the default, or "postgres". SQLite only uses
+// Path, while PostgreSQL is reached either through DSN or, when that is empty,
+// the connection settings.
 type DbConfig struct {
+	Driver   string `json:"driver"`
+	Path     string `json:"path"`
+	DSN      string `json:"dsn"`
 	Host     string `json:"host"`
 	Name     string `json:"name"`
 	User     string `json:"user"`
 	Password string `json:"password"`
 	Port     int    `json:"port"`
+	SSLMode  string `json:"sslmode"`
+}
+
+// LoginLimits limits how often logins can fail, both from an IP address and
+// for an account. After FreeFailures failures in a row each attempt has to wait
+// for Backoff, which doubles with each further failure, and after LockoutAfter
+// failures the attempts are locked out for Lockout. Failures are forgotten once
+// there have been none for Lockout, and those for an account when it logs in.
+//
+// The durations are written as Go durations, such as "30s" or "15m". Settings
+// that are left out have the defaults of 5 failures, "1s", 10 failures and
+// "15m". TrustProxy takes the IP address from the X-Forwarded-For header that a
+// reverse proxy in front of the server adds.
+type LoginLimits struct {
+	FreeFailures int    `json:"free_failures"`
+	Backoff      string `json:"backoff"`
+	LockoutAfter int    `json:"lockout_after"`
+	Lockout      string `json:"lockout"`
+	TrustProxy   bool   `json:"trust_proxy"`
+}
+
+// SessionLimits limits how long a login is remembered. A session ends when it
+// hasn't been used for Idle, and in any case once it is Absolute old, and the
+// token in its cookie is replaced every Rotate.
+//
+// The durations are written as Go durations. Settings that are left out have
+// the defaults of "336h" (two weeks), "2160h" (90 days) and "24h".
+type SessionLimits struct {
+	Idle     string `json:"idle"`
+	Absolute string `json:"absolute"`
+	Rotate   string `json:"rotate"`
 }
 
 // Config represents the configuration settings of the application.
+//
+// Embed is how much of the user's recipe the home page carries: "none", the
+// default, "all", "lazy-images" or "lazy-all".
+//
+// Public lets visitors who aren't logged in read the wiki, but not change it.
+// The tiddlers tagged with PrivateTag, when it is set, are hidden from them.
+//
+// The cookies are only sent over HTTPS, unless InsecureCookies is set for a
+// server that is reached over plain HTTP.
 type Config struct {
-	Pepper   string   `json:"pepper"`
-	Port     int      `json:"port"`
-	Database DbConfig `json:"database"`
+	Pepper          string        `json:"pepper"`
+	Port            int           `json:"port"`
+	Embed           string        `json:"embed"`
+	Public          bool          `json:"public"`
+	PrivateTag      string        `json:"private_tag"`
+	Login           LoginLimits   `json:"login"`
+	Sessions        SessionLimits `json:"sessions"`
+	Database        DbConfig      `json:"database"`
+	InsecureCookies bool          `json:"insecure_cookies"`
 }
 
 // LoadConfig loads the configuration from .config.
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+)
+
+// WikisFile is the registry of the wikis that are served alongside the main
+// one.
+const WikisFile = "wikis.json"
+
+// WikiPrefix is the path that the wikis in the registry are served under.
+const WikiPrefix = "/w/"
+
+var validWikiName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidWikiName reports whether name can be the name of a wiki, which is a
+// part of the path that it is served at.
+func ValidWikiName(name string) bool {
+	return validWikiName.MatchString(name)
+}
+
+// Wiki is a wiki in the registry. It is served at /w/{Name}/ and, for requests
+// to one of its Hosts, at /. Each wiki has its own store, its own copy of
+// index.html and its own users, which are kept in Dir. Embed, Public and
+// PrivateTag are as for the main wiki in Config.
+type Wiki struct {
+	Name       string   `json:"name"`
+	Hosts      []string `json:"hosts,omitempty"`
+	Dir        string   `json:"dir"`
+	Index      string   `json:"index"`
+	Embed      string   `json:"embed,omitempty"`
+	Public     bool     `json:"public,omitempty"`
+	PrivateTag string   `json:"private_tag,omitempty"`
+	Database   DbConfig `json:"database"`
+}
+
+// LoadWikis loads the registry of wikis from path. There are no wikis when the
+// file doesn't exist.
+func LoadWikis(path string) ([]Wiki, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []Wiki{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	var wikis []Wiki
+	if err := json.Unmarshal(data, &wikis); err != nil {
+		return nil, fmt.Errorf("error unmarshalling %s: %w", path, err)
+	}
+	return wikis, nil
+}
+
+// FindWiki finds the wiki called name in the registry, or returns the main
+// wiki, which is described by c, when name is empty.
+func FindWiki(name string, c Config) (Wiki, error) {
+	if name == "" {
+		return Wiki{Dir: ".", Index: "index.html", Embed: c.Embed, Public: c.Public, PrivateTag: c.PrivateTag, Database: c.Database}, nil
+	}
+	wikis, err := LoadWikis(WikisFile)
+	if err != nil {
+		return Wiki{}, err
+	}
+	for _, w := range wikis {
+		if w.Name == name {
+			return w, nil
+		}
+	}
+	return Wiki{}, fmt.Errorf("wiki %s does not exist", name)
+}
+
+// SaveWikis replaces the registry of wikis at path.
+func SaveWikis(path string, wikis []Wiki) error {
+	data, err := json.MarshalIndent(wikis, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
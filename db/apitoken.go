@@ -0,0 +1,44 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/rand"
+)
+
+// apiTokenBytes is how much randomness goes into an API token, and
+// apiTokenIDBytes into the ID that names it.
+const (
+	apiTokenBytes   = 32
+	apiTokenIDBytes = 6
+)
+
+// newAPIToken makes a new token for t, filling in its ID, the hash of the
+// token and when it was created.
+func newAPIToken(t *app.APIToken) (string, error) {
+	if !app.ValidScope(t.Scope) {
+		return "", fmt.Errorf("unknown scope %q", t.Scope)
+	}
+	token, err := rand.String(apiTokenBytes)
+	if err != nil {
+		return "", err
+	}
+	if t.ID, err = rand.String(apiTokenIDBytes); err != nil {
+		return "", err
+	}
+	t.Hash = hashToken(token)
+	t.Created = time.Now().UTC()
+	return token, nil
+}
+
+// hashToken hashes an API or remember token to keep. Unlike a password, a
+// token has plenty of randomness of its own, so a fast hash does and it can be
+// looked up by its hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
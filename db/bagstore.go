@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+// Bag gets a bag by its name.
+func (ts *TiddlyStore) Bag(ctx context.Context, name string) (app.Bag, error) {
+	tx, err := ts.begin(ctx)
+	if err != nil {
+		return app.Bag{}, err
+	}
+	defer tx.Rollback()
+
+	b := app.Bag{Name: name}
+	var policy string
+	err = tx.QueryRowContext(ctx, `SELECT description, policy FROM bag WHERE name = ?`, name).Scan(&b.Description, &policy)
+	if err == sql.ErrNoRows {
+		return app.Bag{}, app.ErrNotFound
+	} else if err != nil {
+		return app.Bag{}, err
+	}
+	if b.Policy, err = parsePolicy(policy); err != nil {
+		return app.Bag{}, fmt.Errorf("bag %s: %w", name, err)
+	}
+	return b, nil
+}
+
+// Bags lists all of the bags in name order.
+func (ts *TiddlyStore) Bags(ctx context.Context) ([]app.Bag, error) {
+	tx, err := ts.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT name, description, policy FROM bag ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bags := []app.Bag{}
+
+	for rows.Next() {
+		var b app.Bag
+		var policy string
+		if err := rows.Scan(&b.Name, &b.Description, &policy); err != nil {
+			return nil, err
+		}
+		if b.Policy, err = parsePolicy(policy); err != nil {
+			return nil, fmt.Errorf("bag %s: %w", b.Name, err)
+		}
+		bags = append(bags, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return bags, nil
+}
+
+// PutBag creates a bag or updates its description and policy.
+func (ts *TiddlyStore) PutBag(ctx context.Context, b app.Bag) error {
+	tx, err := ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	policy, err := policyJSON(b.Policy)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO bag (name, description, policy) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET description = excluded.description, policy = excluded.policy`,
+		b.Name, b.Description, policy)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PutRecipe creates or replaces a recipe. All of its bags must exist.
+func (ts *TiddlyStore) PutRecipe(ctx context.Context, r app.Recipe) error {
+	tx, err := ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, bag := range r.Bags {
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM bag WHERE name = ?`, bag).Scan(&n); err != nil {
+			return err
+		} else if n == 0 {
+			return app.ErrNotFound
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO recipe (name, description) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET description = excluded.description`, r.Name, r.Description)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recipe_bag WHERE recipe = ?`, r.Name); err != nil {
+		return err
+	}
+	for i, bag := range r.Bags {
+		_, err := tx.ExecContext(ctx, `INSERT INTO recipe_bag (recipe, position, bag) VALUES (?, ?, ?)`, r.Name, i, bag)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Recipe gets a recipe by its name.
+func (ts *TiddlyStore) Recipe(ctx context.Context, name string) (app.Recipe, error) {
+	tx, err := ts.begin(ctx)
+	if err != nil {
+		return app.Recipe{}, err
+	}
+	defer tx.Rollback()
+
+	r := app.Recipe{Name: name}
+	err = tx.QueryRowContext(ctx, `SELECT description FROM recipe WHERE name = ?`, name).Scan(&r.Description)
+	if err == sql.ErrNoRows {
+		return app.Recipe{}, app.ErrNotFound
+	} else if err != nil {
+		return app.Recipe{}, err
+	}
+
+	if r.Bags, err = recipeBags(ctx, tx, name); err != nil {
+		return app.Recipe{}, err
+	}
+	return r, nil
+}
+
+// Recipes lists all of the recipes in name order.
+func (ts *TiddlyStore) Recipes(ctx context.Context) ([]app.Recipe, error) {
+	tx, err := ts.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT name, description FROM recipe ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipes := []app.Recipe{}
+
+	for rows.Next() {
+		var r app.Recipe
+		if err := rows.Scan(&r.Name, &r.Description); err != nil {
+			return nil, err
+		}
+		recipes = append(recipes, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range recipes {
+		if recipes[i].Bags, err = recipeBags(ctx, tx, recipes[i].Name); err != nil {
+			return nil, err
+		}
+	}
+	return recipes, nil
+}
+
+func recipeBags(ctx context.Context, tx *Tx, recipe string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT bag FROM recipe_bag WHERE recipe = ? ORDER BY position`, recipe)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bags := []string{}
+
+	for rows.Next() {
+		var bag string
+		if err := rows.Scan(&bag); err != nil {
+			return nil, err
+		}
+		bags = append(bags, bag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return bags, nil
+}
+
+// policyJSON encodes the policy of a bag for the policy column, which is empty
+// when the policy is.
+func policyJSON(p app.Policy) (string, error) {
+	if len(p.Read) == 0 && len(p.Write) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(p)
+	return string(data), err
+}
+
+func parsePolicy(s string) (app.Policy, error) {
+	var p app.Policy
+	if s == "" {
+		return p, nil
+	}
+	err := json.Unmarshal([]byte(s), &p)
+	return p, err
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/rand"
+)
+
+const (
+	// rotationGrace is how long the token that a session had before it was
+	// rotated still finds it, for the requests that were already on their way
+	// with it.
+	rotationGrace = time.Minute
+
+	sessionIDBytes = 6
+
+	// maxUserAgent is how much of a user agent is kept.
+	maxUserAgent = 256
+)
+
+// newSession starts a session for email and returns it along with its token.
+func newSession(email, ip, userAgent string) (app.UserToken, string, error) {
+	token, err := rand.RememberToken()
+	if err != nil {
+		return app.UserToken{}, "", err
+	}
+	id, err := rand.String(sessionIDBytes)
+	if err != nil {
+		return app.UserToken{}, "", err
+	}
+	now := time.Now().UTC()
+	t := app.UserToken{
+		ID:        id,
+		Email:     email,
+		TokenHash: hashToken(token),
+		Created:   now,
+		Rotated:   now,
+		LastSeen:  now,
+		IP:        ip,
+		UserAgent: truncateUserAgent(userAgent),
+	}
+	return t, token, nil
+}
+
+// legacySession turns a session from before only the hash of the token was
+// kept into one that is. The start of the hash serves as its ID, so that it
+// is the same every time.
+func legacySession(email, token string, created time.Time) app.UserToken {
+	if created.IsZero() {
+		created = time.Now().UTC()
+	}
+	hash := hashToken(token)
+	return app.UserToken{
+		ID:        hash[:8],
+		Email:     email,
+		TokenHash: hash,
+		Created:   created,
+		Rotated:   created,
+		LastSeen:  created,
+	}
+}
+
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) > maxUserAgent {
+		return userAgent[:maxUserAgent]
+	}
+	return userAgent
+}
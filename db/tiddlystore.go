@@ -3,27 +3,124 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"html"
 	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/config"
+	_ "github.com/lib/pq"           // postgres
 	_ "github.com/mattn/go-sqlite3" // sqlite
 )
 
+// The database drivers that a TiddlyStore can use, and the driver name that
+// picks a TidStore instead.
+const (
+	driverFiles    = "files"
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite3"
+)
+
+// Store is a TiddlyStore that holds on to resources until it is closed.
+type Store interface {
+	app.TiddlyStore
+	Close() error
+}
+
 // TiddlyStore stores data about tiddlers.
 type TiddlyStore struct {
-	db  *sql.DB
-	dsn string
+	db     *sql.DB
+	driver string
+	dsn    string
+
+	// fts is whether the tiddlers are in SQLite's FTS5 full-text index.
+	fts bool
 }
 
-// NewTiddlyStore creates a new instance of a TiddlyStore.
+// NewTiddlyStore creates a new instance of a TiddlyStore backed by SQLite.
 func NewTiddlyStore(dsn string) (*TiddlyStore, error) {
-	return &TiddlyStore{dsn: dsn}, nil
+	return &TiddlyStore{driver: driverSQLite, dsn: dsn}, nil
+}
+
+// NewPostgresTiddlyStore creates a new instance of a TiddlyStore backed by
+// PostgreSQL.
+func NewPostgresTiddlyStore(dsn string) (*TiddlyStore, error) {
+	return &TiddlyStore{driver: driverPostgres, dsn: dsn}, nil
+}
+
+// OpenTiddlyStore creates and opens the store described by c. SQLite is used
+// unless c names another driver. A store that keeps working in the background
+// logs its errors to errorLog, or to the standard logger when it is nil.
+func OpenTiddlyStore(c config.DbConfig, errorLog *log.Logger) (Store, error) {
+	if c.Driver == driverFiles {
+		s, err := NewTidStore(c.Path, errorLog)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Open(); err != nil {
+			s.Close()
+			return nil, err
+		}
+		return s, nil
+	}
+
+	var ts *TiddlyStore
+	var err error
+	switch c.Driver {
+	case "", driverSQLite:
+		path := c.Path
+		if path == "" {
+			path = "./database/tiddly.db"
+		}
+		ts, err = NewTiddlyStore(path)
+	case driverPostgres:
+		ts, err = NewPostgresTiddlyStore(postgresDSN(c))
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", c.Driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ts.Open(); err != nil {
+		ts.Close()
+		return nil, err
+	}
+	return ts, nil
+}
+
+// postgresDSN builds a connection string for lib/pq from c.
+func postgresDSN(c config.DbConfig) string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+
+	u := url.URL{
+		Scheme: "postgres",
+		Host:   c.Host,
+		Path:   "/" + c.Name,
+	}
+	if c.Port != 0 {
+		u.Host = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	}
+	if c.User != "" {
+		u.User = url.UserPassword(c.User, c.Password)
+	}
+	if c.SSLMode != "" {
+		u.RawQuery = url.Values{"sslmode": {c.SSLMode}}.Encode()
+	}
+	return u.String()
 }
 
 // Open opens the connection to the database.
@@ -33,6 +130,10 @@ func (ts *TiddlyStore) Open() error {
 		return fmt.Errorf("dsn required")
 	}
 
+	if ts.driver == driverPostgres {
+		return ts.openPostgres()
+	}
+
 	// Make the parent directory unless using an in-memory db.
 	if ts.dsn != ":memory:" {
 		if err := os.MkdirAll(filepath.Dir(ts.dsn), 0700); err != nil {
@@ -42,7 +143,7 @@ func (ts *TiddlyStore) Open() error {
 
 	// Connect to the database.
 	var err error
-	if ts.db, err = sql.Open("sqlite3", ts.dsn); err != nil {
+	if ts.db, err = sql.Open(driverSQLite, ts.dsn); err != nil {
 		return err
 	}
 
@@ -64,6 +165,66 @@ func (ts *TiddlyStore) Open() error {
 		return fmt.Errorf("migrate: %w", err)
 	}
 
+	if ts.fts, err = ts.indexFullText(); err != nil {
+		return fmt.Errorf("full-text index: %w", err)
+	}
+
+	return nil
+}
+
+// indexFullText makes sure that the tiddlers are in the FTS5 full-text index,
+// if go-sqlite3 was built with the sqlite_fts5 tag that includes FTS5, and
+// reports whether they are. Without it tiddlers are searched word by word
+// instead, which is slower. A database that has the index can only be opened
+// with FTS5, as its triggers need it to write tiddlers.
+func (ts *TiddlyStore) indexFullText() (bool, error) {
+	var fts5 bool
+	if err := ts.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return false, err
+	}
+	var n int
+	err := ts.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name IN
+		('tiddler_fts', 'tiddler_fts_insert', 'tiddler_fts_delete', 'tiddler_fts_update')`).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	if !fts5 {
+		if n > 0 {
+			return false, fmt.Errorf("the database has an FTS5 full-text index, which needs tiddlypom built with -tags sqlite_fts5")
+		}
+		return false, nil
+	}
+	if n == 4 {
+		return true, nil
+	}
+
+	tx, err := ts.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if buf, err := ioutil.ReadFile("migration/fts5/tiddler_fts.sql"); err != nil {
+		return false, err
+	} else if _, err := tx.Exec(string(buf)); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (ts *TiddlyStore) openPostgres() error {
+	var err error
+	if ts.db, err = sql.Open(driverPostgres, ts.dsn); err != nil {
+		return err
+	}
+
+	if err := ts.db.Ping(); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+
+	if err := ts.migrate(); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
 	return nil
 }
 
@@ -75,83 +236,313 @@ func (ts *TiddlyStore) Close() error {
 	return nil
 }
 
-// Delete deletes the tiddler represented by title from the database.
-func (ts *TiddlyStore) Delete(ctx context.Context, title string) error {
-	tx, err := ts.db.BeginTx(ctx, nil)
+// Changes gets the latest change to each tiddler since the change numbered
+// since, oldest first, up to limit of them, with their text when text is true.
+func (ts *TiddlyStore) Changes(ctx context.Context, since int64, limit int, text bool) ([]app.Change, error) {
+	tx, err := ts.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return changes(ctx, tx, since, limit, text)
+}
+
+// Delete deletes the tiddler represented by title from a bag.
+func (ts *TiddlyStore) Delete(ctx context.Context, bag, title string) error {
+	tx, err := ts.begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	if err := delete(ctx, tx, title); err != nil {
+	if err := deleteTiddler(ctx, tx, bag, title); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
-// Get gets a tiddler by its title.
-func (ts *TiddlyStore) Get(ctx context.Context, title string) (app.Tiddler, error) {
-	tx, err := ts.db.BeginTx(ctx, nil)
+// Deleted lists the tombstones of the tiddlers that are currently deleted from
+// a bag.
+func (ts *TiddlyStore) Deleted(ctx context.Context, bag string) ([]app.Revision, error) {
+	tx, err := ts.begin(ctx)
 	if err != nil {
-		return app.Tiddler{}, err
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	t, err := get(ctx, tx, title)
+	revs, err := deleted(ctx, tx, bag)
+	if err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+// Get gets a tiddler in a bag by its title.
+func (ts *TiddlyStore) Get(ctx context.Context, bag, title string) (app.Tiddler, error) {
+	tx, err := ts.begin(ctx)
 	if err != nil {
 		return app.Tiddler{}, err
 	}
+	defer tx.Rollback()
+
+	t, err := get(ctx, tx, bag, title)
+	if err == sql.ErrNoRows {
+		return app.Tiddler{}, app.ErrNotFound
+	} else if err != nil {
+		return app.Tiddler{}, err
+	}
 	return t, nil
 }
 
-// GetList gets a list of all the tiddlers from the database.
-func (ts *TiddlyStore) GetList(ctx context.Context) ([]app.Tiddler, error) {
-	tx, err := ts.db.BeginTx(ctx, nil)
+// GetAll gets every tiddler in a bag, system tiddlers included, with its text.
+func (ts *TiddlyStore) GetAll(ctx context.Context, bag string) ([]app.Tiddler, error) {
+	tx, err := ts.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return getAll(ctx, tx, bag)
+}
+
+// GetList gets a list of all the tiddlers in a bag from the database.
+func (ts *TiddlyStore) GetList(ctx context.Context, bag string) ([]app.Tiddler, error) {
+	tx, err := ts.begin(ctx)
 	if err != nil {
 		return []app.Tiddler{}, err
 	}
 	defer tx.Rollback()
 
-	t, err := getList(ctx, tx)
+	t, err := getList(ctx, tx, bag)
 	if err != nil {
 		return []app.Tiddler{}, err
 	}
 	return t, nil
 }
 
-// Upsert inserts or updates a record in the database.
-func (ts *TiddlyStore) Upsert(ctx context.Context, title string, t app.Tiddler) error {
-	tx, err := ts.db.BeginTx(ctx, nil)
+// GetRevision gets a single stored revision of a tiddler.
+func (ts *TiddlyStore) GetRevision(ctx context.Context, bag, title string, rev int) (app.Tiddler, error) {
+	tx, err := ts.begin(ctx)
 	if err != nil {
-		return err
+		return app.Tiddler{}, err
 	}
 	defer tx.Rollback()
 
-	if err := upsert(ctx, tx, title, t); err != nil {
-		return err
+	t, err := getRevision(ctx, tx, bag, title, rev)
+	if err != nil {
+		return app.Tiddler{}, err
 	}
-	return tx.Commit()
+	return t, nil
+}
+
+// Restore brings back an earlier revision of a tiddler as its newest revision.
+// This also works for tiddlers that have been deleted.
+func (ts *TiddlyStore) Restore(ctx context.Context, bag, title string, rev int) (app.Tiddler, error) {
+	tx, err := ts.begin(ctx)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+	defer tx.Rollback()
+
+	t, err := restore(ctx, tx, bag, title, rev)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+	return t, tx.Commit()
+}
+
+// Revisions lists the stored revisions of a tiddler, newest first.
+func (ts *TiddlyStore) Revisions(ctx context.Context, bag, title string) ([]app.Revision, error) {
+	tx, err := ts.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	revs, err := revisions(ctx, tx, bag, title)
+	if err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+// Search finds the tiddlers in bags matching query, best matches first.
+func (ts *TiddlyStore) Search(ctx context.Context, bags []string, query string, limit, offset int) ([]app.SearchResult, error) {
+	tx, err := ts.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results, err := search(ctx, tx, bags, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
 }
 
-func delete(ctx context.Context, tx *sql.Tx, title string) error {
-	_, err := tx.ExecContext(ctx, `DELETE FROM tiddler WHERE title = ?`, title)
+// Upsert inserts or updates a record in a bag. When ifRev is not zero the
+// record is only updated if its current revision is ifRev, or only inserted
+// when ifRev is app.IfNew.
+func (ts *TiddlyStore) Upsert(ctx context.Context, bag, title string, t app.Tiddler, ifRev int) (app.Tiddler, error) {
+	tx, err := ts.begin(ctx)
 	if err != nil {
+		return app.Tiddler{}, err
+	}
+	defer tx.Rollback()
+
+	t, err = upsert(ctx, tx, bag, title, t, ifRev)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+	return t, tx.Commit()
+}
+
+func deleteTiddler(ctx context.Context, tx *Tx, bag, title string) error {
+	if err := lockTiddler(ctx, tx, bag, title); err != nil {
 		return err
 	}
-	return nil
+	old, err := get(ctx, tx, bag, title)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	seq, err := nextSeq(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tiddler WHERE bag = ? AND title = ?`, bag, title)
+	if err != nil {
+		return err
+	}
+
+	isSystem := 0
+	if old.IsSystem {
+		isSystem = 1
+	}
+
+	// Leave a tombstone so that the deleted tiddler can be listed and restored.
+	_, err = tx.ExecContext(ctx, `INSERT INTO tiddler_revision
+		(bag, title, rev, meta, text, is_system, created, deleted, seq)
+		VALUES (?, ?, ?, ?, '', ?, ?, 1, ?)`, bag, title, old.Rev+1, old.Meta, isSystem, time.Now().UTC().Format(time.RFC3339), seq)
+	return err
+}
+
+func changes(ctx context.Context, tx *Tx, since int64, limit int, text bool) ([]app.Change, error) {
+	textColumn := "''"
+	if text {
+		textColumn = "text"
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT bag, title, rev, meta, `+textColumn+`, is_system, seq, 0 AS deleted FROM tiddler WHERE seq > ?
+		UNION ALL
+		SELECT r.bag, r.title, r.rev, r.meta, '', r.is_system, r.seq, 1 FROM tiddler_revision r
+		WHERE r.deleted = 1 AND r.seq > ?
+		AND r.rev = (SELECT MAX(rev) FROM tiddler_revision WHERE bag = r.bag AND title = r.title)
+		ORDER BY seq
+		LIMIT ?`, since, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []app.Change{}
+
+	for rows.Next() {
+		var c app.Change
+		if err := rows.Scan(&c.Bag, &c.Title, &c.Rev, &c.Meta, &c.Text, &c.IsSystem, &c.Seq, &c.Deleted); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
 }
 
-func get(ctx context.Context, tx *sql.Tx, title string) (app.Tiddler, error) {
-	var t app.Tiddler
-	err := tx.QueryRowContext(ctx, "SELECT rev, meta, text FROM tiddler WHERE title = ?", title).Scan(&t.Rev, &t.Meta, &t.Text)
+// nextSeq takes the next number in the change sequence. The counter is a
+// single row, so in PostgreSQL a second writer waits for the first to commit
+// before taking the next number and the numbers are committed in order. With
+// a sequence they could be committed out of order, and a client that had seen
+// the later number would never see the change with the earlier one.
+func nextSeq(ctx context.Context, tx *Tx) (int64, error) {
+	if _, err := tx.ExecContext(ctx, `UPDATE change_seq SET seq = seq + 1 WHERE id = 1`); err != nil {
+		return 0, err
+	}
+	var seq int64
+	err := tx.QueryRowContext(ctx, `SELECT seq FROM change_seq WHERE id = 1`).Scan(&seq)
+	return seq, err
+}
+
+func deleted(ctx context.Context, tx *Tx, bag string) ([]app.Revision, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT r.title, r.rev, r.created FROM tiddler_revision r
+		WHERE r.bag = ? AND r.deleted = 1 AND r.is_system = 0
+		AND r.rev = (SELECT MAX(rev) FROM tiddler_revision WHERE bag = r.bag AND title = r.title)
+		AND NOT EXISTS (SELECT 1 FROM tiddler WHERE bag = r.bag AND title = r.title)
+		ORDER BY r.created DESC, r.title`, bag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revs := []app.Revision{}
+
+	for rows.Next() {
+		r := app.Revision{Bag: bag, Deleted: true}
+		var created string
+		if err := rows.Scan(&r.Title, &r.Rev, &created); err != nil {
+			return nil, err
+		}
+		if r.Created, err = time.Parse(time.RFC3339, created); err != nil {
+			return nil, err
+		}
+		revs = append(revs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revs, nil
+}
+
+func get(ctx context.Context, tx *Tx, bag, title string) (app.Tiddler, error) {
+	t := app.Tiddler{Bag: bag, Title: title}
+	err := tx.QueryRowContext(ctx, "SELECT rev, meta, text, is_system FROM tiddler WHERE bag = ? AND title = ?", bag, title).Scan(&t.Rev, &t.Meta, &t.Text, &t.IsSystem)
 	if err != nil {
 		return app.Tiddler{}, err
 	}
 	return t, nil
 }
 
-func getList(ctx context.Context, tx *sql.Tx) ([]app.Tiddler, error) {
-	rows, err := tx.QueryContext(ctx, `SELECT meta FROM tiddler WHERE is_system = 0`)
+func getAll(ctx context.Context, tx *Tx, bag string) ([]app.Tiddler, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT title, rev, meta, text, is_system FROM tiddler WHERE bag = ? ORDER BY title`, bag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tiddlers := []app.Tiddler{}
+
+	for rows.Next() {
+		t := app.Tiddler{Bag: bag}
+		if err := rows.Scan(&t.Title, &t.Rev, &t.Meta, &t.Text, &t.IsSystem); err != nil {
+			return nil, err
+		}
+		tiddlers = append(tiddlers, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tiddlers, nil
+}
+
+func getList(ctx context.Context, tx *Tx, bag string) ([]app.Tiddler, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT title, rev, meta FROM tiddler WHERE bag = ? AND is_system = 0`, bag)
 	if err != nil {
 		return nil, err
 	}
@@ -160,8 +551,8 @@ func getList(ctx context.Context, tx *sql.Tx) ([]app.Tiddler, error) {
 	var tiddlers []app.Tiddler
 
 	for rows.Next() {
-		var t app.Tiddler
-		err := rows.Scan(&t.Meta)
+		t := app.Tiddler{Bag: bag}
+		err := rows.Scan(&t.Title, &t.Rev, &t.Meta)
 		if err != nil {
 			return nil, err
 		}
@@ -174,38 +565,302 @@ func getList(ctx context.Context, tx *sql.Tx) ([]app.Tiddler, error) {
 	return tiddlers, nil
 }
 
-func upsert(ctx context.Context, tx *sql.Tx, title string, t app.Tiddler) error {
+func getRevision(ctx context.Context, tx *Tx, bag, title string, rev int) (app.Tiddler, error) {
+	t := app.Tiddler{Bag: bag, Title: title}
+	err := tx.QueryRowContext(ctx, "SELECT rev, meta, text, is_system FROM tiddler_revision WHERE bag = ? AND title = ? AND rev = ? AND deleted = 0", bag, title, rev).Scan(&t.Rev, &t.Meta, &t.Text, &t.IsSystem)
+	if err == sql.ErrNoRows {
+		return app.Tiddler{}, app.ErrNotFound
+	} else if err != nil {
+		return app.Tiddler{}, err
+	}
+	return t, nil
+}
+
+func restore(ctx context.Context, tx *Tx, bag, title string, rev int) (app.Tiddler, error) {
+	t, err := getRevision(ctx, tx, bag, title, rev)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+	return upsert(ctx, tx, bag, title, t, 0)
+}
+
+func revisions(ctx context.Context, tx *Tx, bag, title string) ([]app.Revision, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT rev, created, deleted FROM tiddler_revision WHERE bag = ? AND title = ? ORDER BY rev DESC`, bag, title)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revs := []app.Revision{}
+
+	for rows.Next() {
+		r := app.Revision{Bag: bag, Title: title}
+		var created string
+		if err := rows.Scan(&r.Rev, &created, &r.Deleted); err != nil {
+			return nil, err
+		}
+		if r.Created, err = time.Parse(time.RFC3339, created); err != nil {
+			return nil, err
+		}
+		revs = append(revs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revs, nil
+}
+
+func search(ctx context.Context, tx *Tx, bags []string, query string, limit, offset int) ([]app.SearchResult, error) {
+	results := []app.SearchResult{}
+
+	match := ftsQuery(query)
+	if match == "" || len(bags) == 0 {
+		return results, nil
+	}
+	if tx.driver != driverPostgres && !tx.fts {
+		return searchWords(ctx, tx, bags, query, limit, offset)
+	}
+
+	inBags := "t.bag IN (?" + strings.Repeat(", ?", len(bags)-1) + ")"
+	var args []interface{}
+
+	var rows *sql.Rows
+	var err error
+	if tx.driver == driverPostgres {
+		args = append(args, "StartSel="+markStart+", StopSel="+markStop+", MaxWords=16, MinWords=8", query)
+		for _, bag := range bags {
+			args = append(args, bag)
+		}
+		args = append(args, limit, offset)
+		rows, err = tx.QueryContext(ctx, `SELECT t.bag, t.title, t.rev,
+			ts_headline('simple', t.text, q, ?),
+			ts_rank(t.search, q) AS score
+			FROM tiddler t, plainto_tsquery('simple', ?) q
+			WHERE t.search @@ q AND t.is_system = 0 AND `+inBags+`
+			ORDER BY score DESC
+			LIMIT ? OFFSET ?`, args...)
+	} else {
+		args = append(args, markStart, markStop, match)
+		for _, bag := range bags {
+			args = append(args, bag)
+		}
+		args = append(args, limit, offset)
+		// bm25 ranks better matches lower, so flip the sign to get a score
+		// where higher is better. Matches in the title count for more.
+		rows, err = tx.QueryContext(ctx, `SELECT t.bag, t.title, t.rev,
+			snippet(tiddler_fts, 2, ?, ?, '…', 16),
+			-bm25(tiddler_fts, 10.0, 1.0, 1.0) AS score
+			FROM tiddler_fts
+			JOIN tiddler t ON t.id = tiddler_fts.rowid
+			WHERE tiddler_fts MATCH ? AND t.is_system = 0 AND `+inBags+`
+			ORDER BY score DESC
+			LIMIT ? OFFSET ?`, args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r app.SearchResult
+		if err := rows.Scan(&r.Bag, &r.Title, &r.Rev, &r.Snippet, &r.Score); err != nil {
+			return nil, err
+		}
+		r.Snippet = markSnippet(r.Snippet)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// searchWords searches SQLite without a full-text index, for tiddlers that
+// have each of the words of query in their title, fields or text, whatever
+// its case. As with the TidStore, a word in the title counts for more.
+func searchWords(ctx context.Context, tx *Tx, bags []string, query string, limit, offset int) ([]app.SearchResult, error) {
+	results := []app.SearchResult{}
+
+	words := strings.Fields(strings.ToLower(query))
+	var score, where []string
+	var args []interface{}
+	for _, w := range words {
+		score = append(score, `10 * (instr(lower(t.title), ?) > 0) + (instr(lower(t.meta), ?) > 0) + (instr(lower(t.text), ?) > 0)`)
+		args = append(args, w, w, w)
+	}
+	for _, w := range words {
+		where = append(where, `(instr(lower(t.title), ?) > 0 OR instr(lower(t.meta), ?) > 0 OR instr(lower(t.text), ?) > 0)`)
+		args = append(args, w, w, w)
+	}
+	for _, bag := range bags {
+		args = append(args, bag)
+	}
+	args = append(args, limit, offset)
+
+	rows, err := tx.QueryContext(ctx, `SELECT t.bag, t.title, t.rev, t.text, `+strings.Join(score, " + ")+` AS score
+		FROM tiddler t
+		WHERE `+strings.Join(where, " AND ")+` AND t.is_system = 0
+		AND t.bag IN (?`+strings.Repeat(", ?", len(bags)-1)+`)
+		ORDER BY score DESC, t.title, t.bag
+		LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r app.SearchResult
+		var text string
+		if err := rows.Scan(&r.Bag, &r.Title, &r.Rev, &text, &r.Score); err != nil {
+			return nil, err
+		}
+		r.Snippet = snippet(text, words)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// markStart and markStop are put around the matching terms of a snippet in
+// place of the <mark> tags, which can only be added once the text has been
+// escaped as HTML.
+const (
+	markStart = "\x02"
+	markStop  = "\x03"
+)
+
+// markSnippet escapes a snippet as HTML and then marks the matching terms that
+// markStart and markStop are around.
+func markSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, markStart, "<mark>")
+	return strings.ReplaceAll(snippet, markStop, "</mark>")
+}
+
+// ftsQuery turns what a user typed into an FTS5 query that matches tiddlers
+// containing all of the words. Each word is quoted so that characters which
+// mean something to FTS5 are searched for rather than causing syntax errors.
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// upsert writes t as the next revision of title in bag. The compare against
+// ifRev and the write happen in the same transaction, so two clients that both
+// read revision n cannot both replace it.
+func upsert(ctx context.Context, tx *Tx, bag, title string, t app.Tiddler, ifRev int) (app.Tiddler, error) {
+	if err := lockTiddler(ctx, tx, bag, title); err != nil {
+		return app.Tiddler{}, err
+	}
+	var current int
+	err := tx.QueryRowContext(ctx, `SELECT rev FROM tiddler WHERE bag = ? AND title = ?`, bag, title).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return app.Tiddler{}, err
+	}
+	if ifRev != 0 && ifRev != current && (ifRev != app.IfNew || current != 0) {
+		return app.Tiddler{}, app.ErrConflict
+	}
+
+	// Number from the history rather than the current row so that a tiddler
+	// written after being deleted carries on after its tombstone.
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(rev), 0) + 1 FROM tiddler_revision WHERE bag = ? AND title = ?`, bag, title).Scan(&t.Rev); err != nil {
+		return app.Tiddler{}, err
+	}
+	if t.Meta, err = setRevision(t.Meta, bag, t.Rev); err != nil {
+		return app.Tiddler{}, err
+	}
+	t.Bag, t.Title = bag, title
+	seq, err := nextSeq(ctx, tx)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+
 	isSystem := 0
 	if t.IsSystem {
 		isSystem = 1
 	}
 
-	_, err := tx.ExecContext(ctx, `INSERT INTO tiddler
-		(title, rev, meta, text, is_system)
-		VALUES (?, ?, ?, ?, ?)
-		ON CONFLICT(title) DO UPDATE SET rev = excluded.rev,
+	_, err = tx.ExecContext(ctx, `INSERT INTO tiddler
+		(bag, title, rev, meta, text, is_system, seq)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(bag, title) DO UPDATE SET rev = excluded.rev,
 		meta = excluded.meta,
 		text = excluded.text,
-		is_system = excluded.is_system`, title, t.Rev, t.Meta, t.Text, isSystem)
+		is_system = excluded.is_system,
+		seq = excluded.seq`, bag, title, t.Rev, t.Meta, t.Text, isSystem, seq)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+
+	// Keep every revision so that earlier versions can be listed and fetched.
+	_, err = tx.ExecContext(ctx, `INSERT INTO tiddler_revision
+		(bag, title, rev, meta, text, is_system, created, seq)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, bag, title, t.Rev, t.Meta, t.Text, isSystem, time.Now().UTC().Format(time.RFC3339), seq)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+	return t, nil
+}
+
+// lockTiddler makes a concurrent write of the same tiddler wait until tx ends,
+// so that it then numbers its revision after this one. In PostgreSQL locking
+// the row isn't enough, as there is no row to lock until a tiddler is first
+// written. SQLite only allows one writer, but a transaction that has read
+// can't wait for the others to finish, so tx starts by writing.
+func lockTiddler(ctx context.Context, tx *Tx, bag, title string) error {
+	if tx.driver != driverPostgres {
+		_, err := tx.ExecContext(ctx, `UPDATE change_seq SET seq = seq WHERE id = 1`)
+		return err
+	}
+	h := fnv.New64a()
+	h.Write([]byte(bag + "\x00" + title))
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(?)`, int64(h.Sum64()))
 	return err
 }
 
+// setRevision updates the bag and revision recorded in a tiddler's meta data.
+func setRevision(meta, bag string, rev int) (string, error) {
+	var js map[string]interface{}
+	if err := json.Unmarshal([]byte(meta), &js); err != nil {
+		return "", err
+	}
+	js["bag"] = bag
+	js["revision"] = rev
+	data, err := json.Marshal(js)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 // migrate sets up migration tracking and executes pending migration files.
 //
 // Migration files are embedded in the migration folder and are executed
-// in lexicographical order.
+// in lexicographical order. PostgreSQL has its own set of migration files in
+// migration/postgres, numbered to match their SQLite counterparts.
 //
-// Once a migration is run, its name is used to update the user_version property
-// of the database so that it is not re-executed. Migrations run in a transaction
-// to prevent partial migrations.
+// Once a migration is run, its name is used to update the schema version of the
+// database so that it is not re-executed. Migrations run in a transaction to
+// prevent partial migrations.
 func (ts *TiddlyStore) migrate() error {
-	var userVersion int
-	err := ts.db.QueryRow("PRAGMA user_version;").Scan(&userVersion)
+	userVersion, err := ts.schemaVersion()
 	if err != nil {
-		return fmt.Errorf("cannot get user_version: %w", err)
+		return fmt.Errorf("cannot get schema version: %w", err)
 	}
 
-	names, err := filepath.Glob("migration/*.sql")
+	pattern := "migration/*.sql"
+	if ts.driver == driverPostgres {
+		pattern = "migration/postgres/*.sql"
+	}
+	names, err := filepath.Glob(pattern)
 	if err != nil {
 		return err
 	}
@@ -221,7 +876,7 @@ func (ts *TiddlyStore) migrate() error {
 }
 
 // migrate runs a single migration file within a transaction. On success, the
-// user_version of the database file is updated to prevent re-running.
+// schema version of the database is updated to prevent re-running.
 func (ts *TiddlyStore) migrateFile(userVersion int, name string) error {
 	fileVersion, err := strconv.Atoi(strings.ReplaceAll(filepath.Base(name), filepath.Ext(name), ""))
 	if err != nil {
@@ -251,10 +906,36 @@ func (ts *TiddlyStore) migrateFile(userVersion int, name string) error {
 		return err
 	}
 
-	// Update user_version to prevent re-running migration. Trying to parameterize the statement results in an error: 'near "?": syntax error'
-	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", fileVersion)); err != nil {
-		return fmt.Errorf("failed to update user_version: %w", err)
+	if err := ts.setSchemaVersion(tx, fileVersion); err != nil {
+		return fmt.Errorf("failed to update schema version: %w", err)
 	}
 
 	return tx.Commit()
 }
+
+// schemaVersion returns the version of the last migration that was run. SQLite
+// keeps it in the user_version pragma, PostgreSQL in the schema_version table.
+func (ts *TiddlyStore) schemaVersion() (int, error) {
+	var version int
+	if ts.driver != driverPostgres {
+		err := ts.db.QueryRow("PRAGMA user_version;").Scan(&version)
+		return version, err
+	}
+
+	if _, err := ts.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return 0, err
+	}
+	err := ts.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+func (ts *TiddlyStore) setSchemaVersion(tx *sql.Tx, version int) error {
+	if ts.driver == driverPostgres {
+		_, err := tx.Exec(`INSERT INTO schema_version (version) VALUES ($1)`, version)
+		return err
+	}
+
+	// Trying to parameterize the statement results in an error: 'near "?": syntax error'
+	_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", version))
+	return err
+}
@@ -0,0 +1,310 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+// postgresTestDSN names the environment variable with the DSN of a PostgreSQL
+// database that the store tests may write to. They are skipped without one.
+const postgresTestDSN = "TIDDLYPOM_TEST_POSTGRES_DSN"
+
+// openTestTiddlyStore opens ts with the migrations of the web application,
+// which are found relative to the folder it runs from.
+func openTestTiddlyStore(t *testing.T, ts *TiddlyStore) *TiddlyStore {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../cmd/web"); err != nil {
+		t.Fatal(err)
+	}
+	err = ts.Open()
+	if err := os.Chdir(wd); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ts.Close() })
+	return ts
+}
+
+func TestTidStore(t *testing.T) {
+	s, err := NewTidStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	testStore(t, s)
+}
+
+func TestSQLiteTiddlyStore(t *testing.T) {
+	ts, err := NewTiddlyStore(filepath.Join(t.TempDir(), "tiddly.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, openTestTiddlyStore(t, ts))
+	testUserStoreSQL(t, ts)
+}
+
+// TestSQLiteFullTextIndex checks that a database with a full-text index isn't
+// opened without FTS5, whose triggers would fail every write.
+func TestSQLiteFullTextIndex(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "tiddly.db")
+	ts, err := NewTiddlyStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openTestTiddlyStore(t, ts)
+	if ts.fts {
+		t.Skip("go-sqlite3 has FTS5")
+	}
+	if _, err := ts.db.Exec(`CREATE TABLE tiddler_fts (title TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	ts, _ = NewTiddlyStore(dsn)
+	defer ts.Close()
+	if err := ts.Open(); err == nil || !strings.Contains(err.Error(), "sqlite_fts5") {
+		t.Errorf("Open = %v, want an error naming the sqlite_fts5 tag", err)
+	}
+}
+
+func TestPostgresTiddlyStore(t *testing.T) {
+	dsn := os.Getenv(postgresTestDSN)
+	if dsn == "" {
+		t.Skip(postgresTestDSN + " is not set")
+	}
+	ts, err := NewPostgresTiddlyStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, openTestTiddlyStore(t, ts))
+	testUserStoreSQL(t, ts)
+}
+
+// testUserStoreSQL runs the user store tests against the database of ts.
+func testUserStoreSQL(t *testing.T, ts *TiddlyStore) {
+	us, err := NewUserStoreSQL(ts, "pepper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("set password", func(t *testing.T) {
+		testUserStoreSetPassword(t, us)
+	})
+}
+
+// testStore runs the tests that every kind of store has to pass. The titles
+// are made unique so that a database can be used more than once.
+func testStore(t *testing.T, s app.TiddlyStore) {
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	t.Run("revisions", func(t *testing.T) {
+		testStoreRevisions(t, s, "Revisions "+suffix)
+	})
+	t.Run("concurrent creates", func(t *testing.T) {
+		testStoreConcurrentCreates(t, s, "Concurrent "+suffix)
+	})
+	t.Run("search", func(t *testing.T) {
+		testStoreSearch(t, s, suffix)
+	})
+	t.Run("changes", func(t *testing.T) {
+		testStoreChanges(t, s, suffix)
+	})
+}
+
+func testTiddler(title, text string) app.Tiddler {
+	return app.Tiddler{Meta: fmt.Sprintf(`{"title":%q}`, title), Text: text}
+}
+
+// testStoreRevisions writes, deletes and restores a tiddler and checks the
+// revisions that are kept along the way.
+func testStoreRevisions(t *testing.T, s app.TiddlyStore, title string) {
+	ctx := context.Background()
+	bag := app.DefaultBag
+
+	steps := []struct {
+		text  string
+		ifRev int
+		want  int
+		err   error
+	}{
+		{"one", app.IfNew, 1, nil},
+		{"again", app.IfNew, 0, app.ErrConflict},
+		{"two", 1, 2, nil},
+		{"stale", 1, 0, app.ErrConflict},
+		{"three", 0, 3, nil},
+	}
+	for _, step := range steps {
+		got, err := s.Upsert(ctx, bag, title, testTiddler(title, step.text), step.ifRev)
+		if err != step.err {
+			t.Fatalf("Upsert(%q, %d) error = %v, want %v", step.text, step.ifRev, err, step.err)
+		}
+		if err == nil && got.Rev != step.want {
+			t.Fatalf("Upsert(%q, %d) rev = %d, want %d", step.text, step.ifRev, got.Rev, step.want)
+		}
+	}
+
+	if err := s.Delete(ctx, bag, title); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, bag, title); err == nil {
+		t.Fatal("Get after Delete found the tiddler")
+	}
+
+	revs, err := s.Revisions(ctx, bag, title)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, r := range revs {
+		got = append(got, r.Rev)
+	}
+	if fmt.Sprint(got) != "[4 3 2 1]" || !revs[0].Deleted || revs[1].Deleted {
+		t.Fatalf("Revisions = %+v, want 4 (deleted), 3, 2, 1", revs)
+	}
+
+	old, err := s.GetRevision(ctx, bag, title, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Text != "two" {
+		t.Fatalf("GetRevision(2) text = %q, want %q", old.Text, "two")
+	}
+
+	restored, err := s.Restore(ctx, bag, title, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Rev != 5 || restored.Text != "two" {
+		t.Fatalf("Restore(2) = rev %d %q, want rev 5 %q", restored.Rev, restored.Text, "two")
+	}
+	current, err := s.Get(ctx, bag, title)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Rev != 5 || current.Text != "two" {
+		t.Fatalf("Get after Restore = rev %d %q, want rev 5 %q", current.Rev, current.Text, "two")
+	}
+}
+
+// testStoreConcurrentCreates creates the same tiddler from many goroutines at
+// once, of which exactly one may succeed.
+func testStoreConcurrentCreates(t *testing.T, s app.TiddlyStore, title string) {
+	ctx := context.Background()
+
+	const n = 10
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.Upsert(ctx, app.DefaultBag, title, testTiddler(title, fmt.Sprint(i)), app.IfNew)
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			created++
+		case app.ErrConflict:
+		default:
+			t.Errorf("Upsert error = %v, want nil or a conflict", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d goroutines created the tiddler, want 1", created)
+	}
+}
+
+// testStoreSearch searches for a word made unique by suffix, which a match in
+// the title ranks first.
+func testStoreSearch(t *testing.T, s app.TiddlyStore, suffix string) {
+	ctx := context.Background()
+	word := "zq" + suffix
+
+	for title, text := range map[string]string{
+		"Search " + word:    "nothing",
+		"Search " + suffix:  "it has " + word + " in the text",
+		"Missing " + suffix: "it doesn't",
+	} {
+		if _, err := s.Upsert(ctx, app.DefaultBag, title, testTiddler(title, text), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := s.Search(ctx, []string{app.DefaultBag}, strings.ToUpper(word), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Title)
+	}
+	if want := []string{"Search " + word, "Search " + suffix}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Search = %q, want %q", got, want)
+	}
+	if want := "<mark>" + word + "</mark>"; !strings.Contains(results[1].Snippet, want) {
+		t.Errorf("snippet = %q, want it to have %q", results[1].Snippet, want)
+	}
+}
+
+// testStoreChanges writes two tiddlers and deletes the first, and then reads
+// the changes back a page at a time.
+func testStoreChanges(t *testing.T, s app.TiddlyStore, suffix string) {
+	ctx := context.Background()
+
+	var since int64
+	all, err := s.Changes(ctx, 0, math.MaxInt32, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) > 0 {
+		since = all[len(all)-1].Seq
+	}
+
+	first, second := "First "+suffix, "Second "+suffix
+	for _, title := range []string{first, second} {
+		if _, err := s.Upsert(ctx, app.DefaultBag, title, testTiddler(title, title), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Delete(ctx, app.DefaultBag, first); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := s.Changes(ctx, since, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Title != second || changes[0].Text != second ||
+		changes[1].Title != first || !changes[1].Deleted || changes[0].Seq >= changes[1].Seq {
+		t.Fatalf("Changes = %+v, want %q with its text and then %q deleted", changes, second, first)
+	}
+
+	page, err := s.Changes(ctx, since, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].Title != second || page[0].Text != "" {
+		t.Errorf("Changes(limit 1, no text) = %+v, want just %q without its text", page, second)
+	}
+}
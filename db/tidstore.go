@@ -0,0 +1,1153 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/tiddlywiki"
+)
+
+// tidPollInterval is how often the tiddlers folder is checked for changes
+// made by other programs.
+const tidPollInterval = 2 * time.Second
+
+// TidStore stores tiddlers as files in the tiddlers folder of a Node.js
+// TiddlyWiki wiki folder, so that the same folder can be served by either and
+// kept in git as plain files. Changes made to the files by other programs are
+// picked up while the store is open.
+//
+// The tiddlers folder holds the default bag. Node.js TiddlyWiki has no bags, so
+// any other bag is kept in a folder of its own under bags in the wiki folder,
+// where Node.js TiddlyWiki doesn't look, and the list of bags and recipes is
+// kept in .tiddlypom/bags.json and .tiddlypom/recipes.json.
+//
+// Node.js TiddlyWiki doesn't keep a history either, so the revisions are kept
+// in .tiddlypom/history.jsonl in the wiki folder, one JSON object per line.
+// The store remembers where each revision is in the file, so that getting one
+// doesn't read the whole history.
+type TidStore struct {
+	dir      string
+	errorLog *log.Logger
+
+	lock     sync.Mutex
+	bags     map[string]app.Bag
+	recipes  map[string]app.Recipe
+	tiddlers map[tidKey]tidEntry      // the current tiddlers
+	files    map[string][]string      // the titles in each tiddler file
+	stamps   map[string]fileStamp     // every file as of the last scan
+	history  map[tidKey][]tidRevision // every revision of each tiddler, oldest first, without content
+	seq      int64                    // the number of the latest change
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// tidKey identifies a tiddler by its bag and title.
+type tidKey struct {
+	bag   string
+	title string
+}
+
+type tidEntry struct {
+	path    string
+	tiddler app.Tiddler
+}
+
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+// tidRevision is a line of the history file. Revisions written before there
+// were bags have no bag and belong to the default bag, even those whose meta
+// data says they are in the old "bag" bag. Those written before there was a
+// change sequence are numbered by their place in the file.
+type tidRevision struct {
+	Bag      string    `json:"bag,omitempty"`
+	Title    string    `json:"title"`
+	Rev      int       `json:"rev"`
+	Meta     string    `json:"meta"`
+	Text     string    `json:"text"`
+	IsSystem bool      `json:"is_system,omitempty"`
+	Created  time.Time `json:"created"`
+	Deleted  bool      `json:"deleted,omitempty"`
+	Seq      int64     `json:"seq,omitempty"`
+
+	offset int64 // where the line starts in the history file
+}
+
+// tidBag is a bag in bags.json.
+type tidBag struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Policy      *app.Policy `json:"policy,omitempty"`
+}
+
+// tidRecipe is a recipe in recipes.json.
+type tidRecipe struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Bags        []string `json:"bags"`
+}
+
+// NewTidStore creates a new instance of a TidStore for the wiki folder dir.
+// Errors in picking up changes made by other programs go to errorLog, or to
+// the standard logger when it is nil.
+func NewTidStore(dir string, errorLog *log.Logger) (*TidStore, error) {
+	if errorLog == nil {
+		errorLog = log.Default()
+	}
+	return &TidStore{dir: dir, errorLog: errorLog}, nil
+}
+
+// Open reads the wiki folder and starts watching it for changes.
+func (s *TidStore) Open() error {
+	if s.dir == "" {
+		return fmt.Errorf("wiki folder required")
+	}
+	if err := os.MkdirAll(s.bagDir(app.DefaultBag), 0700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.historyPath()), 0700); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tiddlers = map[tidKey]tidEntry{}
+	s.files = map[string][]string{}
+	s.stamps = map[string]fileStamp{}
+	s.history = map[tidKey][]tidRevision{}
+
+	if err := s.loadSpaces(); err != nil {
+		return fmt.Errorf("bags: %w", err)
+	}
+	if err := s.loadHistory(); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	if err := s.scan(); err != nil {
+		return fmt.Errorf("scan: %w", err)
+	}
+
+	// Whatever is still without a file was deleted while we weren't looking.
+	for key, e := range s.tiddlers {
+		if e.path == "" {
+			delete(s.tiddlers, key)
+			if err := s.appendHistory(key, e.tiddler, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.done = make(chan struct{})
+	s.wg.Add(1)
+	go s.watch()
+	return nil
+}
+
+// Close stops watching the wiki folder.
+func (s *TidStore) Close() error {
+	if s.done != nil {
+		close(s.done)
+		s.wg.Wait()
+		s.done = nil
+	}
+	return nil
+}
+
+// Bag gets a bag by its name.
+func (s *TidStore) Bag(ctx context.Context, name string) (app.Bag, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	b, ok := s.bags[name]
+	if !ok {
+		return app.Bag{}, app.ErrNotFound
+	}
+	return b, nil
+}
+
+// Bags lists all of the bags in name order.
+func (s *TidStore) Bags(ctx context.Context) ([]app.Bag, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	bags := make([]app.Bag, 0, len(s.bags))
+	for _, b := range s.bags {
+		bags = append(bags, b)
+	}
+	sort.Slice(bags, func(i, j int) bool { return bags[i].Name < bags[j].Name })
+	return bags, nil
+}
+
+// PutBag creates a bag, and its folder, or updates its description and policy.
+func (s *TidStore) PutBag(ctx context.Context, b app.Bag) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if b.Name == "" || b.Name != filepath.Base(b.Name) || strings.HasPrefix(b.Name, ".") {
+		return fmt.Errorf("invalid bag name %q", b.Name)
+	}
+	if err := os.MkdirAll(s.bagDir(b.Name), 0700); err != nil {
+		return err
+	}
+	old, existed := s.bags[b.Name]
+	s.bags[b.Name] = b
+	if err := s.saveSpaces(); err != nil {
+		if existed {
+			s.bags[b.Name] = old
+		} else {
+			delete(s.bags, b.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// PutRecipe creates or replaces a recipe. All of its bags must exist.
+func (s *TidStore) PutRecipe(ctx context.Context, r app.Recipe) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, bag := range r.Bags {
+		if _, ok := s.bags[bag]; !ok {
+			return app.ErrNotFound
+		}
+	}
+	old, existed := s.recipes[r.Name]
+	s.recipes[r.Name] = r
+	if err := s.saveSpaces(); err != nil {
+		if existed {
+			s.recipes[r.Name] = old
+		} else {
+			delete(s.recipes, r.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Recipe gets a recipe by its name.
+func (s *TidStore) Recipe(ctx context.Context, name string) (app.Recipe, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r, ok := s.recipes[name]
+	if !ok {
+		return app.Recipe{}, app.ErrNotFound
+	}
+	return r, nil
+}
+
+// Recipes lists all of the recipes in name order.
+func (s *TidStore) Recipes(ctx context.Context) ([]app.Recipe, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	recipes := make([]app.Recipe, 0, len(s.recipes))
+	for _, r := range s.recipes {
+		recipes = append(recipes, r)
+	}
+	sort.Slice(recipes, func(i, j int) bool { return recipes[i].Name < recipes[j].Name })
+	return recipes, nil
+}
+
+// Changes gets the latest change to each tiddler since the change numbered
+// since, oldest first, up to limit of them, with their text when text is true.
+func (s *TidStore) Changes(ctx context.Context, since int64, limit int, text bool) ([]app.Change, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := []app.Change{}
+	for key, history := range s.history {
+		h := history[len(history)-1]
+		if h.Seq <= since {
+			continue
+		}
+		c := app.Change{Seq: h.Seq, Deleted: h.Deleted}
+		if e, ok := s.tiddlers[key]; ok && !h.Deleted {
+			c.Tiddler = e.tiddler
+			if !text {
+				c.Text = ""
+			}
+		} else {
+			c.Tiddler = app.Tiddler{Bag: key.bag, Title: key.title, Rev: h.Rev, IsSystem: h.IsSystem}
+		}
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Seq < list[j].Seq })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// Delete deletes the tiddler's file and leaves a tombstone in the history.
+func (s *TidStore) Delete(ctx context.Context, bag, title string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := tidKey{bag, title}
+	e, ok := s.tiddlers[key]
+	if !ok {
+		return nil
+	}
+	if err := s.removeFromFile(e.path, key); err != nil {
+		return err
+	}
+	delete(s.tiddlers, key)
+	return s.appendHistory(key, e.tiddler, true)
+}
+
+// Deleted lists the tombstones of the tiddlers that are currently deleted from
+// a bag.
+func (s *TidStore) Deleted(ctx context.Context, bag string) ([]app.Revision, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	revs := []app.Revision{}
+	for key, history := range s.history {
+		h := history[len(history)-1]
+		if _, ok := s.tiddlers[key]; ok || key.bag != bag || !h.Deleted || h.IsSystem {
+			continue
+		}
+		revs = append(revs, app.Revision{Bag: bag, Title: key.title, Rev: h.Rev, Created: h.Created, Deleted: true})
+	}
+	sort.Slice(revs, func(i, j int) bool {
+		if !revs[i].Created.Equal(revs[j].Created) {
+			return revs[i].Created.After(revs[j].Created)
+		}
+		return revs[i].Title < revs[j].Title
+	})
+	return revs, nil
+}
+
+// Get gets a tiddler in a bag by its title.
+func (s *TidStore) Get(ctx context.Context, bag, title string) (app.Tiddler, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.tiddlers[tidKey{bag, title}]
+	if !ok {
+		return app.Tiddler{}, app.ErrNotFound
+	}
+	return e.tiddler, nil
+}
+
+// GetAll gets every tiddler in a bag, system tiddlers included, with its text.
+func (s *TidStore) GetAll(ctx context.Context, bag string) ([]app.Tiddler, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var titles []string
+	for key := range s.tiddlers {
+		if key.bag == bag {
+			titles = append(titles, key.title)
+		}
+	}
+	sort.Strings(titles)
+
+	tiddlers := make([]app.Tiddler, 0, len(titles))
+	for _, title := range titles {
+		tiddlers = append(tiddlers, s.tiddlers[tidKey{bag, title}].tiddler)
+	}
+	return tiddlers, nil
+}
+
+// GetList gets a list of all the tiddlers in a bag that aren't system
+// tiddlers.
+func (s *TidStore) GetList(ctx context.Context, bag string) ([]app.Tiddler, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var titles []string
+	for key, e := range s.tiddlers {
+		if key.bag == bag && !e.tiddler.IsSystem {
+			titles = append(titles, key.title)
+		}
+	}
+	sort.Strings(titles)
+
+	tiddlers := make([]app.Tiddler, 0, len(titles))
+	for _, title := range titles {
+		t := s.tiddlers[tidKey{bag, title}].tiddler
+		tiddlers = append(tiddlers, app.Tiddler{Bag: bag, Title: title, Rev: t.Rev, Meta: t.Meta})
+	}
+	return tiddlers, nil
+}
+
+// GetRevision gets a single stored revision of a tiddler.
+func (s *TidStore) GetRevision(ctx context.Context, bag, title string, rev int) (app.Tiddler, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.getRevision(tidKey{bag, title}, rev)
+}
+
+// Restore brings back an earlier revision of a tiddler as its newest revision.
+// This also works for tiddlers that have been deleted.
+func (s *TidStore) Restore(ctx context.Context, bag, title string, rev int) (app.Tiddler, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := tidKey{bag, title}
+	t, err := s.getRevision(key, rev)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+	return s.upsert(key, t, 0)
+}
+
+// Revisions lists the stored revisions of a tiddler, newest first.
+func (s *TidStore) Revisions(ctx context.Context, bag, title string) ([]app.Revision, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	history := s.history[tidKey{bag, title}]
+	revs := make([]app.Revision, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		r := history[i]
+		revs = append(revs, app.Revision{Bag: bag, Title: title, Rev: r.Rev, Created: r.Created, Deleted: r.Deleted})
+	}
+	return revs, nil
+}
+
+// Search finds the tiddlers in bags containing every word of query, best
+// matches first. There is no index, every tiddler is looked at.
+func (s *TidStore) Search(ctx context.Context, bags []string, query string, limit, offset int) ([]app.SearchResult, error) {
+	results := []app.SearchResult{}
+
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return results, nil
+	}
+	inBags := map[string]bool{}
+	for _, bag := range bags {
+		inBags[bag] = true
+	}
+
+	s.lock.Lock()
+	for key, e := range s.tiddlers {
+		if e.tiddler.IsSystem || !inBags[key.bag] {
+			continue
+		}
+		lowerTitle := strings.ToLower(key.title)
+		lowerMeta := strings.ToLower(e.tiddler.Meta)
+		lowerText := strings.ToLower(e.tiddler.Text)
+
+		// Like the SQLite search, matches in the title count for more.
+		score := 0
+		for _, w := range words {
+			n := 10*strings.Count(lowerTitle, w) + strings.Count(lowerMeta, w) + strings.Count(lowerText, w)
+			if n == 0 {
+				score = 0
+				break
+			}
+			score += n
+		}
+		if score == 0 {
+			continue
+		}
+		results = append(results, app.SearchResult{
+			Bag:     key.bag,
+			Title:   key.title,
+			Rev:     e.tiddler.Rev,
+			Snippet: snippet(e.tiddler.Text, words),
+			Score:   float64(score),
+		})
+	}
+	s.lock.Unlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Title != results[j].Title {
+			return results[i].Title < results[j].Title
+		}
+		return results[i].Bag < results[j].Bag
+	})
+
+	if offset >= len(results) {
+		return []app.SearchResult{}, nil
+	}
+	results = results[offset:]
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// Upsert writes the tiddler to its file, creating the file if need be. When
+// ifRev is not zero the tiddler is only written if its current revision is
+// ifRev, or only created when ifRev is app.IfNew.
+func (s *TidStore) Upsert(ctx context.Context, bag, title string, t app.Tiddler, ifRev int) (app.Tiddler, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.bags[bag]; !ok {
+		return app.Tiddler{}, fmt.Errorf("no such bag %q", bag)
+	}
+	return s.upsert(tidKey{bag, title}, t, ifRev)
+}
+
+func (s *TidStore) upsert(key tidKey, t app.Tiddler, ifRev int) (app.Tiddler, error) {
+	current := s.tiddlers[key].tiddler.Rev
+	if ifRev != 0 && ifRev != current && (ifRev != app.IfNew || current != 0) {
+		return app.Tiddler{}, app.ErrConflict
+	}
+
+	var err error
+	t.Rev = s.latest(key).Rev + 1
+	if t.Meta, err = setRevision(t.Meta, key.bag, t.Rev); err != nil {
+		return app.Tiddler{}, err
+	}
+	t.Bag, t.Title = key.bag, key.title
+
+	fields, err := tiddlywiki.Fields(t)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+	fields["title"] = key.title
+
+	path, err := s.write(key, fields)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+	s.tiddlers[key] = tidEntry{path: path, tiddler: t}
+	if err := s.appendHistory(key, t, false); err != nil {
+		return app.Tiddler{}, err
+	}
+	return t, nil
+}
+
+func (s *TidStore) getRevision(key tidKey, rev int) (app.Tiddler, error) {
+	for _, h := range s.history[key] {
+		if h.Rev != rev || h.Deleted {
+			continue
+		}
+		r, err := s.readRevision(h.offset)
+		if err != nil {
+			return app.Tiddler{}, err
+		}
+		if r.Bag != key.bag || r.Title != key.title || r.Rev != rev {
+			return app.Tiddler{}, fmt.Errorf("history: revision %d of %q in bag %s is not where it was", rev, key.title, key.bag)
+		}
+		return app.Tiddler{Bag: r.Bag, Title: r.Title, Rev: r.Rev, Meta: r.Meta, Text: r.Text, IsSystem: r.IsSystem}, nil
+	}
+	return app.Tiddler{}, app.ErrNotFound
+}
+
+// latest returns the latest revision of a tiddler, or the zero revision when
+// it has none.
+func (s *TidStore) latest(key tidKey) tidRevision {
+	revs := s.history[key]
+	if len(revs) == 0 {
+		return tidRevision{}
+	}
+	return revs[len(revs)-1]
+}
+
+// bagDir returns the folder that a bag's tiddlers are kept in.
+func (s *TidStore) bagDir(bag string) string {
+	if bag == app.DefaultBag {
+		return filepath.Join(s.dir, "tiddlers")
+	}
+	return filepath.Join(s.dir, "bags", bag)
+}
+
+// pathBag returns the bag that the tiddlers in a file belong to.
+func (s *TidStore) pathBag(path string) string {
+	rel, err := filepath.Rel(filepath.Join(s.dir, "bags"), path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return app.DefaultBag
+	}
+	return strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+}
+
+func (s *TidStore) historyPath() string {
+	return filepath.Join(s.dir, ".tiddlypom", "history.jsonl")
+}
+
+func (s *TidStore) bagsPath() string {
+	return filepath.Join(s.dir, ".tiddlypom", "bags.json")
+}
+
+func (s *TidStore) recipesPath() string {
+	return filepath.Join(s.dir, ".tiddlypom", "recipes.json")
+}
+
+// loadSpaces reads the bags and recipes. The default bag and recipe are there
+// even when the files are not.
+func (s *TidStore) loadSpaces() error {
+	s.bags = map[string]app.Bag{app.DefaultBag: {Name: app.DefaultBag}}
+	s.recipes = map[string]app.Recipe{app.DefaultRecipe: {Name: app.DefaultRecipe, Bags: []string{app.DefaultBag}}}
+
+	var bags []tidBag
+	if err := readJSONFile(s.bagsPath(), &bags); err != nil {
+		return err
+	}
+	for _, b := range bags {
+		nb := app.Bag{Name: b.Name, Description: b.Description}
+		if b.Policy != nil {
+			nb.Policy = *b.Policy
+		}
+		s.bags[b.Name] = nb
+	}
+
+	var recipes []tidRecipe
+	if err := readJSONFile(s.recipesPath(), &recipes); err != nil {
+		return err
+	}
+	for _, r := range recipes {
+		s.recipes[r.Name] = app.Recipe{Name: r.Name, Description: r.Description, Bags: r.Bags}
+	}
+	return nil
+}
+
+// saveSpaces writes the bags and recipes out again.
+func (s *TidStore) saveSpaces() error {
+	bags := make([]tidBag, 0, len(s.bags))
+	for _, b := range s.bags {
+		tb := tidBag{Name: b.Name, Description: b.Description}
+		if len(b.Policy.Read) > 0 || len(b.Policy.Write) > 0 {
+			policy := b.Policy
+			tb.Policy = &policy
+		}
+		bags = append(bags, tb)
+	}
+	sort.Slice(bags, func(i, j int) bool { return bags[i].Name < bags[j].Name })
+	if err := writeJSONFile(s.bagsPath(), bags); err != nil {
+		return err
+	}
+
+	recipes := make([]tidRecipe, 0, len(s.recipes))
+	for _, r := range s.recipes {
+		recipes = append(recipes, tidRecipe{Name: r.Name, Description: r.Description, Bags: r.Bags})
+	}
+	sort.Slice(recipes, func(i, j int) bool { return recipes[i].Name < recipes[j].Name })
+	return writeJSONFile(s.recipesPath(), recipes)
+}
+
+// watch checks the tiddlers folder for changes until the store is closed.
+func (s *TidStore) watch() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(tidPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.lock.Lock()
+			if err := s.scan(); err != nil {
+				s.errorLog.Println("error scanning tiddlers folder", s.dir, err)
+			}
+			s.lock.Unlock()
+		}
+	}
+}
+
+// scan brings the store up to date with the files in the bag folders,
+// reading again only the files that have changed since the last scan.
+func (s *TidStore) scan() error {
+	stamps := map[string]fileStamp{}
+	for bag := range s.bags {
+		root := s.bagDir(bag)
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// Skip hidden files and folders such as .git.
+			if path != root && strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() {
+				stamps[path] = fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// A change to a .meta file is a change to the file that it describes.
+	changed := map[string]bool{}
+	for path, stamp := range stamps {
+		if old, ok := s.stamps[path]; !ok || old != stamp {
+			changed[strings.TrimSuffix(path, ".meta")] = true
+		}
+	}
+	for path := range s.stamps {
+		if _, ok := stamps[path]; !ok {
+			changed[strings.TrimSuffix(path, ".meta")] = true
+		}
+	}
+	s.stamps = stamps
+
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if err := s.reloadFile(path); err != nil {
+			s.errorLog.Println("error reading tiddler file", path, err)
+		}
+	}
+	return nil
+}
+
+// reloadFile reads the tiddlers in a file that was added, changed or removed.
+func (s *TidStore) reloadFile(path string) error {
+	var loaded []map[string]string
+	if _, err := os.Stat(path); err == nil {
+		if loaded, err = readTiddlerFile(path); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	bag := s.pathBag(path)
+	old := s.files[path]
+	delete(s.files, path)
+
+	seen := map[string]bool{}
+	for _, fields := range loaded {
+		title := fields["title"]
+		if title == "" || seen[title] {
+			continue
+		}
+		seen[title] = true
+		s.files[path] = append(s.files[path], title)
+		if err := s.record(tidKey{bag, title}, path, fields); err != nil {
+			return err
+		}
+	}
+
+	for _, title := range old {
+		if seen[title] {
+			continue
+		}
+		// The tiddler may have moved to another file already.
+		key := tidKey{bag, title}
+		if e, ok := s.tiddlers[key]; ok && e.path == path {
+			delete(s.tiddlers, key)
+			if err := s.appendHistory(key, e.tiddler, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// record notes that a tiddler was read from path, adding a revision to the
+// history when it isn't the same as the latest one.
+func (s *TidStore) record(key tidKey, path string, fields map[string]string) error {
+	if e, ok := s.tiddlers[key]; ok {
+		current, err := tiddlywiki.Fields(e.tiddler)
+		if err == nil && reflect.DeepEqual(current, fields) {
+			e.path = path
+			s.tiddlers[key] = e
+			return nil
+		}
+	}
+
+	t, err := tiddlywiki.NewTiddler(fields, key.bag, s.latest(key).Rev+1)
+	if err != nil {
+		return err
+	}
+	t.Bag, t.Title = key.bag, key.title
+	s.tiddlers[key] = tidEntry{path: path, tiddler: t}
+	return s.appendHistory(key, t, false)
+}
+
+// write saves a tiddler to a file the way Node.js TiddlyWiki would: as a .tid
+// file, as a .json file when its fields don't fit in a .tid header, or for the
+// types that have a file extension, as a file of that type with a .meta file.
+// It returns the path of the file.
+func (s *TidStore) write(key tidKey, fields map[string]string) (string, error) {
+	ext, data, meta, err := tiddlywiki.MarshalFile(fields)
+	if err != nil {
+		return "", err
+	}
+
+	// Keep using the tiddler's file when the tiddler has it to itself and it is
+	// still the right kind of file.
+	path := ""
+	if e, ok := s.tiddlers[key]; ok && e.path != "" {
+		_, hasMeta := s.stamps[e.path+".meta"]
+		if len(s.files[e.path]) == 1 && strings.HasSuffix(e.path, ext) && hasMeta == (meta != nil) {
+			path = e.path
+		} else if err := s.removeFromFile(e.path, key); err != nil {
+			return "", err
+		}
+	}
+	if path == "" {
+		path = s.newPath(key, ext)
+	}
+
+	if err := s.writeFile(path, data); err != nil {
+		return "", err
+	}
+	if meta != nil {
+		if err := s.writeFile(path+".meta", meta); err != nil {
+			return "", err
+		}
+	}
+	s.files[path] = []string{key.title}
+	return path, nil
+}
+
+// newPath finds an unused file name for a tiddler.
+func (s *TidStore) newPath(key tidKey, ext string) string {
+	base := tiddlywiki.Filename(strings.TrimSuffix(key.title, ext))
+	for n := 0; ; n++ {
+		name := base
+		if n > 0 {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		path := filepath.Join(s.bagDir(key.bag), name+ext)
+		if _, ok := s.stamps[path]; ok {
+			continue
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+	}
+}
+
+// removeFromFile takes a tiddler out of its file, deleting the file when no
+// other tiddlers are kept in it.
+func (s *TidStore) removeFromFile(path string, key tidKey) error {
+	if path == "" {
+		return nil
+	}
+
+	var others []string
+	for _, t := range s.files[path] {
+		if t != key.title {
+			others = append(others, t)
+		}
+	}
+
+	if len(others) == 0 {
+		for _, p := range []string{path, path + ".meta"} {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			delete(s.stamps, p)
+		}
+		delete(s.files, path)
+		return nil
+	}
+
+	// Only .json files hold more than one tiddler, so write it out again
+	// without this one.
+	list := make([]map[string]string, 0, len(others))
+	for _, t := range others {
+		fields, err := tiddlywiki.Fields(s.tiddlers[tidKey{key.bag, t}].tiddler)
+		if err != nil {
+			return err
+		}
+		list = append(list, fields)
+	}
+	data, err := tiddlywiki.MarshalJSONTiddlers(list)
+	if err != nil {
+		return err
+	}
+	if err := s.writeFile(path, data); err != nil {
+		return err
+	}
+	s.files[path] = others
+	return nil
+}
+
+// writeFile writes a file and remembers it so that the next scan doesn't take
+// it for a change made by someone else.
+func (s *TidStore) writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	s.stamps[path] = fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+	return nil
+}
+
+// loadHistory reads where every revision is in the history file, and the
+// latest revision of every tiddler. Live tiddlers are added to the store
+// without a file, which scan then fills in.
+func (s *TidStore) loadHistory() error {
+	return s.readHistory(func(r tidRevision) bool {
+		if r.Seq == 0 {
+			r.Seq = s.seq + 1
+		}
+		s.seq = r.Seq
+		key := tidKey{r.Bag, r.Title}
+		if r.Deleted {
+			delete(s.tiddlers, key)
+		} else {
+			s.tiddlers[key] = tidEntry{tiddler: app.Tiddler{Bag: r.Bag, Title: r.Title, Rev: r.Rev, Meta: r.Meta, Text: r.Text, IsSystem: r.IsSystem}}
+		}
+		r.Meta, r.Text = "", ""
+		s.history[key] = append(s.history[key], r)
+		return true
+	})
+}
+
+// readHistory calls fn for each revision in the history file, oldest first,
+// until fn returns false.
+func (s *TidStore) readHistory(fn func(tidRevision) bool) error {
+	f, err := os.Open(s.historyPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for {
+		var r tidRevision
+		offset := dec.InputOffset()
+		if err := dec.Decode(&r); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		r.upgrade()
+		r.offset = offset
+		if !fn(r) {
+			return nil
+		}
+	}
+}
+
+// readRevision reads the revision at offset in the history file.
+func (s *TidStore) readRevision(offset int64) (tidRevision, error) {
+	f, err := os.Open(s.historyPath())
+	if err != nil {
+		return tidRevision{}, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return tidRevision{}, err
+	}
+	var r tidRevision
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		return tidRevision{}, err
+	}
+	r.upgrade()
+	r.offset = offset
+	return r, nil
+}
+
+// upgrade puts a revision written before there were bags in the default bag.
+func (r *tidRevision) upgrade() {
+	if r.Bag == "" {
+		r.Bag = app.DefaultBag
+		r.Meta = strings.Replace(r.Meta, `"bag":"bag"`, `"bag":"default"`, 1)
+	}
+}
+
+// appendHistory adds a revision, or a tombstone when deleted is true, to the
+// end of the history file.
+func (s *TidStore) appendHistory(key tidKey, t app.Tiddler, deleted bool) error {
+	r := tidRevision{
+		Bag:      key.bag,
+		Title:    key.title,
+		Rev:      t.Rev,
+		Meta:     t.Meta,
+		Text:     t.Text,
+		IsSystem: t.IsSystem,
+		Created:  time.Now().UTC().Truncate(time.Second),
+		Deleted:  deleted,
+		Seq:      s.seq + 1,
+	}
+	if deleted {
+		r.Rev = s.latest(key).Rev + 1
+		r.Text = ""
+	}
+
+	f, err := os.OpenFile(s.historyPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if r.offset, err = f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(r); err != nil {
+		return err
+	}
+
+	s.seq = r.Seq
+	r.Meta, r.Text = "", ""
+	s.history[key] = append(s.history[key], r)
+	return nil
+}
+
+// readJSONFile reads the JSON in path into v. It is not an error for the file
+// not to exist.
+func readJSONFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile replaces the file at path with v as JSON.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readTiddlerFile reads the tiddlers in a file of the tiddlers folder, along
+// with its .meta file if it has one.
+func readTiddlerFile(path string) ([]map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ioutil.ReadFile(path + ".meta")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	hasMeta := err == nil
+
+	ext := strings.ToLower(filepath.Ext(path))
+	var list []map[string]string
+	switch {
+	case hasMeta:
+		list = []map[string]string{fileFields(path, data, tiddlywiki.ParseFieldBlock(string(meta)))}
+	case ext == ".tid":
+		list = []map[string]string{tiddlywiki.ParseTid(data)}
+	case ext == ".json":
+		if list, err = tiddlywiki.ParseJSONTiddlers(data); err != nil {
+			// Not tiddlers, so it's a JSON file like any other.
+			list = []map[string]string{fileFields(path, data, map[string]string{})}
+		}
+	default:
+		if tiddlywiki.TypeForExtension(ext) == "" && !utf8.Valid(data) {
+			return nil, nil
+		}
+		list = []map[string]string{fileFields(path, data, map[string]string{})}
+	}
+
+	// Tidy the fields up the same way that tiddlywiki.Fields does so that they
+	// can be compared with what we already have.
+	for _, fields := range list {
+		if fields["text"] == "" {
+			delete(fields, "text")
+		}
+		if tags, ok := fields["tags"]; ok {
+			fields["tags"] = tiddlywiki.StringifyList(tiddlywiki.ParseStringList(tags))
+		}
+	}
+	return list, nil
+}
+
+// fileFields makes a tiddler of a file that isn't in a tiddler format, with
+// the fields found in its .meta file.
+func fileFields(path string, data []byte, fields map[string]string) map[string]string {
+	if fields["type"] == "" {
+		if typ := tiddlywiki.TypeForExtension(filepath.Ext(path)); typ != "" {
+			fields["type"] = typ
+		}
+	}
+	if tiddlywiki.IsBinaryType(fields["type"]) {
+		fields["text"] = base64.StdEncoding.EncodeToString(data)
+	} else {
+		fields["text"] = string(data)
+	}
+	if fields["title"] == "" {
+		fields["title"] = filepath.Base(path)
+	}
+	return fields
+}
+
+// snippet returns a few words of text around the first of words that it
+// contains, escaped as HTML, with the words wrapped in <mark> tags.
+func snippet(text string, words []string) string {
+	const size = 16
+
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	re := regexp.MustCompile(`(?i)` + strings.Join(quoted, "|"))
+
+	tokens := strings.Fields(text)
+	start := 0
+	for i, token := range tokens {
+		if re.MatchString(token) {
+			start = i - size/4
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + size
+	if end > len(tokens) {
+		end = len(tokens)
+	}
+
+	marked := make([]string, 0, end-start)
+	for _, token := range tokens[start:end] {
+		marked = append(marked, re.ReplaceAllString(token, markStart+"$0"+markStop))
+	}
+	out := markSnippet(strings.Join(marked, " "))
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(tokens) {
+		out += "…"
+	}
+	return out
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+func openTestTidStore(t *testing.T, dir string) *TidStore {
+	t.Helper()
+	s, err := NewTidStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// TestTidStoreReopen makes sure that the revisions written before the store
+// was opened are found in the history file.
+func TestTidStoreReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := openTestTidStore(t, dir)
+	texts := []string{"one", "two\nlines", "three"}
+	for _, title := range []string{"A", "B"} {
+		for _, text := range texts {
+			if _, err := s.Upsert(ctx, app.DefaultBag, title, testTiddler(title, title+" "+text), 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	s.Close()
+
+	s = openTestTidStore(t, dir)
+	defer s.Close()
+	for _, title := range []string{"A", "B"} {
+		for i, text := range texts {
+			got, err := s.GetRevision(ctx, app.DefaultBag, title, i+1)
+			if err != nil {
+				t.Fatalf("GetRevision(%q, %d): %v", title, i+1, err)
+			}
+			if want := title + " " + text; got.Text != want {
+				t.Errorf("GetRevision(%q, %d) text = %q, want %q", title, i+1, got.Text, want)
+			}
+		}
+	}
+	if _, err := s.GetRevision(ctx, app.DefaultBag, "A", 4); err != app.ErrNotFound {
+		t.Errorf("GetRevision(%q, 4) error = %v, want %v", "A", err, app.ErrNotFound)
+	}
+}
+
+// TestTidStoreOldHistory reads a history file written before there were bags.
+func TestTidStoreOldHistory(t *testing.T) {
+	dir := t.TempDir()
+	history := filepath.Join(dir, ".tiddlypom", "history.jsonl")
+	if err := os.MkdirAll(filepath.Dir(history), 0700); err != nil {
+		t.Fatal(err)
+	}
+	lines := `{"title":"Old","rev":1,"meta":"{\"bag\":\"bag\",\"title\":\"Old\"}","text":"old","created":"2021-01-31T00:00:00Z"}
+{"title":"Old","rev":2,"meta":"","text":"","created":"2021-02-01T00:00:00Z","deleted":true}
+`
+	if err := ioutil.WriteFile(history, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openTestTidStore(t, dir)
+	defer s.Close()
+
+	ctx := context.Background()
+	revs, err := s.Revisions(ctx, app.DefaultBag, "Old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 || revs[0].Rev != 2 || !revs[0].Deleted || revs[1].Rev != 1 {
+		t.Fatalf("Revisions = %+v, want 2 (deleted), 1", revs)
+	}
+	got, err := s.GetRevision(ctx, app.DefaultBag, "Old", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != "old" || got.Meta != `{"bag":"default","title":"Old"}` {
+		t.Errorf("GetRevision = %q %q, want the text old in the default bag", got.Meta, got.Text)
+	}
+}
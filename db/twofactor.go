@@ -0,0 +1,33 @@
+package db
+
+import (
+	"github.com/etitcombe/tiddlypom/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashRecoveryCodes hashes recovery codes to keep, as passwords are.
+func hashRecoveryCodes(codes []string, pepper string) ([]string, error) {
+	hashes := make([]string, 0, len(codes))
+	for _, c := range codes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(totp.NormalizeRecoveryCode(c)+pepper), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, string(hash))
+	}
+	return hashes, nil
+}
+
+// matchRecoveryCode returns the index of the hash of code in hashes, or -1.
+func matchRecoveryCode(hashes []string, code, pepper string) int {
+	code = totp.NormalizeRecoveryCode(code)
+	if code == "" {
+		return -1
+	}
+	for i, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code+pepper)) == nil {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Tx wraps a database transaction so that the queries in this package can be
+// written once, with ? placeholders, and run against every supported driver.
+type Tx struct {
+	*sql.Tx
+	driver string
+	fts    bool
+}
+
+func (ts *TiddlyStore) begin(ctx context.Context) (*Tx, error) {
+	tx, err := ts.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, driver: ts.driver, fts: ts.fts}, nil
+}
+
+// ExecContext executes a query that doesn't return rows.
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.rebind(query), args...)
+}
+
+// QueryContext executes a query that returns rows.
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, tx.rebind(query), args...)
+}
+
+// QueryRowContext executes a query that is expected to return at most one row.
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.rebind(query), args...)
+}
+
+// rebind replaces the ? placeholders in query with $1, $2, ... for PostgreSQL.
+// Question marks inside quoted strings are left alone.
+func (tx *Tx) rebind(query string) string {
+	if tx.driver != driverPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,742 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+	"github.com/etitcombe/tiddlypom/rand"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserStoreSQL implements the UserStore interface in the database of a
+// TiddlyStore, in the user_account, login_session and api_token tables.
+type UserStoreSQL struct {
+	ts           *TiddlyStore
+	UserPwPepper string
+}
+
+// NewUserStoreSQL creates and returns a new instance of a UserStoreSQL that
+// keeps its users in the database of ts, which has to be open.
+func NewUserStoreSQL(ts *TiddlyStore, pepper string) (*UserStoreSQL, error) {
+	return &UserStoreSQL{ts: ts, UserPwPepper: pepper}, nil
+}
+
+// OpenUserStore returns the store for the users of the wiki whose tiddlers are
+// in s. Users are kept in the same database as the tiddlers, and any users.gob
+// and usertokens.gob files in dir are moved into it, as are the sessions from
+// before only the hashes of their tokens were kept. A wiki kept as files has no
+// database, so its users stay in those files.
+func OpenUserStore(s Store, dir, pepper string) (app.UserStore, error) {
+	ts, ok := s.(*TiddlyStore)
+	if !ok {
+		return NewUserStoreFile(dir, pepper)
+	}
+
+	us, err := NewUserStoreSQL(ts, pepper)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.importFiles(context.Background(), dir); err != nil {
+		return nil, fmt.Errorf("importing users: %w", err)
+	}
+	if err := us.importSessions(context.Background()); err != nil {
+		return nil, fmt.Errorf("importing sessions: %w", err)
+	}
+	return us, nil
+}
+
+// Authenticate authenticates a user based on email and password
+func (s *UserStoreSQL) Authenticate(email, password string) (*app.User, error) {
+	foundUser, err := s.ByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password+s.UserPwPepper))
+	if err != nil {
+		return nil, err
+	}
+	return foundUser, nil
+}
+
+// Close does nothing, since the database belongs to the TiddlyStore.
+func (s *UserStoreSQL) Close() {
+}
+
+// Create creates a new user with the given password.
+func (s *UserStoreSQL) Create(user *app.User, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password+s.UserPwPepper), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := userByEmail(ctx, tx, user.Email); err == nil {
+		return fmt.Errorf("user %s already exists", user.Email)
+	} else if err != errNotFound {
+		return err
+	}
+
+	user.PasswordHash = string(hash)
+	if err := insertUser(ctx, tx, *user); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ByEmail retrieves a user by their email address.
+func (s *UserStoreSQL) ByEmail(email string) (*app.User, error) {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return userByEmail(ctx, tx, email)
+}
+
+// ByRememberToken retrieves a user and their session by its remember token.
+func (s *UserStoreSQL) ByRememberToken(token string) (*app.User, *app.UserToken, error) {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	hash := hashToken(token)
+	rows, err := tx.QueryContext(ctx, `SELECT `+sessionColumns+` FROM login_session
+		WHERE token_hash = ? OR previous_hash = ?`, hash, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	sessions, err := scanSessions(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, t := range sessions {
+		if t.TokenHash == hash || time.Since(t.Rotated) < rotationGrace {
+			u, err := userByEmail(ctx, tx, t.Email)
+			if err != nil {
+				return nil, nil, err
+			}
+			return u, &t, nil
+		}
+	}
+	return nil, nil, errNotFound
+}
+
+// CreateRememberToken starts a new session for a user and returns its token.
+func (s *UserStoreSQL) CreateRememberToken(user *app.User, ip, userAgent string) (string, error) {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	u, err := userByEmail(ctx, tx, user.Email)
+	if err != nil {
+		return "", err
+	}
+	t, token, err := newSession(u.Email, ip, userAgent)
+	if err != nil {
+		return "", err
+	}
+	if err := insertSession(ctx, tx, t); err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// TouchRememberToken records the use of a session and, with rotate, replaces
+// its token. Nothing is rotated, and no token is returned, when token has
+// already been replaced.
+func (s *UserStoreSQL) TouchRememberToken(token, ip, userAgent string, rotate bool) (string, error) {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	userAgent = truncateUserAgent(userAgent)
+	if !rotate {
+		if _, err := tx.ExecContext(ctx, `UPDATE login_session SET last_seen = ?, ip = ?, user_agent = ?
+			WHERE token_hash = ?`, now, ip, userAgent, hashToken(token)); err != nil {
+			return "", err
+		}
+		return "", tx.Commit()
+	}
+
+	newToken, err := rand.RememberToken()
+	if err != nil {
+		return "", err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE login_session
+		SET token_hash = ?, previous_hash = token_hash, rotated = ?, last_seen = ?, ip = ?, user_agent = ?
+		WHERE token_hash = ?`, hashToken(newToken), now, now, ip, userAgent, hashToken(token))
+	if err != nil {
+		return "", err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return "", err
+	}
+	return newToken, tx.Commit()
+}
+
+// ClearRememberToken ends the session of a remember token.
+func (s *UserStoreSQL) ClearRememberToken(token string) error {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	hash := hashToken(token)
+	if _, err := tx.ExecContext(ctx, `DELETE FROM login_session WHERE token_hash = ? OR previous_hash = ?`, hash, hash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// List lists all of the users in email order.
+func (s *UserStoreSQL) List() ([]app.User, error) {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+userColumns("user_account")+` FROM user_account ORDER BY lower(email)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []app.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// Delete deletes a user and their remember and API tokens.
+func (s *UserStoreSQL) Delete(email string) error {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	u, err := userByEmail(ctx, tx, email)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM login_session WHERE email = ?`, u.Email); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM api_token WHERE email = ?`, u.Email); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_account WHERE email = ?`, u.Email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetPassword changes the password of a user and ends all of their sessions.
+func (s *UserStoreSQL) SetPassword(email, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password+s.UserPwPepper), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	u, err := userByEmail(ctx, tx, email)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE user_account SET password_hash = ? WHERE email = ?`, string(hash), u.Email); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM login_session WHERE email = ?`, u.Email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetRole changes the role of a user.
+func (s *UserStoreSQL) SetRole(email, role string) error {
+	if !app.ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	u, err := userByEmail(ctx, tx, email)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE user_account SET role = ? WHERE email = ?`, role, u.Email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Sessions lists the sessions of a user, oldest first.
+func (s *UserStoreSQL) Sessions(email string) ([]app.UserToken, error) {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+sessionColumns+` FROM login_session
+		WHERE lower(email) = lower(?)
+		ORDER BY created, id`, email)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessions(rows)
+}
+
+// DeleteSession ends the session of a user with the given ID.
+func (s *UserStoreSQL) DeleteSession(email, id string) error {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM login_session WHERE lower(email) = lower(?) AND id = ?`, email, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNotFound
+	}
+	return tx.Commit()
+}
+
+// ClearRememberTokens ends all of the sessions of a user.
+func (s *UserStoreSQL) ClearRememberTokens(email string) error {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM login_session WHERE lower(email) = lower(?)`, email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetTOTP enrolls a user in two-factor authentication, or takes them out of it
+// when secret is empty.
+func (s *UserStoreSQL) SetTOTP(email, secret string, recoveryCodes []string) error {
+	hashes, err := hashRecoveryCodes(recoveryCodes, s.UserPwPepper)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		hashes = nil
+	}
+
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	u, err := userByEmail(ctx, tx, email)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE user_account SET totp_secret = ?, recovery_codes = ? WHERE email = ?`,
+		secret, strings.Join(hashes, " "), u.Email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode checks a recovery code of a user and uses it up.
+func (s *UserStoreSQL) UseRecoveryCode(email, code string) (bool, error) {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	u, err := userByEmail(ctx, tx, email)
+	if err != nil {
+		return false, err
+	}
+	i := matchRecoveryCode(u.RecoveryCodeHashes, code, s.UserPwPepper)
+	if i < 0 {
+		return false, nil
+	}
+	left := append(u.RecoveryCodeHashes[:i:i], u.RecoveryCodeHashes[i+1:]...)
+	res, err := tx.ExecContext(ctx, `UPDATE user_account SET recovery_codes = ? WHERE email = ? AND recovery_codes = ?`,
+		strings.Join(left, " "), u.Email, strings.Join(u.RecoveryCodeHashes, " "))
+	if err != nil {
+		return false, err
+	}
+	// Someone else used a code at the same time, and this one may be gone.
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// CreateAPIToken creates a new API token for a user.
+func (s *UserStoreSQL) CreateAPIToken(t *app.APIToken) (string, error) {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	u, err := userByEmail(ctx, tx, t.Email)
+	if err != nil {
+		return "", err
+	}
+	token, err := newAPIToken(t)
+	if err != nil {
+		return "", err
+	}
+	t.Email = u.Email
+	_, err = tx.ExecContext(ctx, `INSERT INTO api_token (id, email, name, hash, scope, created, expires)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.Email, t.Name, t.Hash, t.Scope, t.Created.Format(time.RFC3339), formatExpiry(t.Expires))
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// ByAPIToken retrieves an API token and its user by the token.
+func (s *UserStoreSQL) ByAPIToken(token string) (*app.User, *app.APIToken, error) {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, email, name, hash, scope, created, expires FROM api_token
+		WHERE hash = ?`, hashToken(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens, err := scanAPITokens(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, errNotFound
+	}
+	u, err := userByEmail(ctx, tx, tokens[0].Email)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, &tokens[0], nil
+}
+
+// APITokens lists the API tokens of a user, oldest first.
+func (s *UserStoreSQL) APITokens(email string) ([]app.APIToken, error) {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, email, name, hash, scope, created, expires FROM api_token
+		WHERE lower(email) = lower(?)
+		ORDER BY created, id`, email)
+	if err != nil {
+		return nil, err
+	}
+	return scanAPITokens(rows)
+}
+
+// DeleteAPIToken deletes the API token of a user with the given ID.
+func (s *UserStoreSQL) DeleteAPIToken(email, id string) error {
+	ctx := context.Background()
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM api_token WHERE lower(email) = lower(?) AND id = ?`, email, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNotFound
+	}
+	return tx.Commit()
+}
+
+// importFiles moves the users and remember tokens in the users.gob and
+// usertokens.gob files in dir, if there are any, into the database. Users that
+// are already there are left as they are. The files are then renamed so that
+// they aren't imported again, but are still around if they're needed.
+func (s *UserStoreSQL) importFiles(ctx context.Context, dir string) error {
+	files := &UserStoreFile{Dir: dir}
+	users, err := files.retrieveUsers()
+	if err != nil {
+		return err
+	}
+	userTokens, err := files.retrieveUserTokens()
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 && len(userTokens) == 0 {
+		return nil
+	}
+
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, u := range users {
+		if _, err := userByEmail(ctx, tx, u.Email); err == nil {
+			continue
+		} else if err != errNotFound {
+			return err
+		}
+		if err := insertUser(ctx, tx, u); err != nil {
+			return err
+		}
+	}
+	for _, t := range userTokens {
+		u, err := userByEmail(ctx, tx, t.Email)
+		if err == errNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		session := t.UserToken()
+		session.Email = u.Email
+		if err := insertSession(ctx, tx, session); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, name := range []string{usersFile, userTokensFile} {
+		path := filepath.Join(dir, name)
+		if err := os.Rename(path, path+".imported"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func userByEmail(ctx context.Context, tx *Tx, email string) (*app.User, error) {
+	return scanUser(tx.QueryRowContext(ctx, `SELECT `+userColumns("user_account")+` FROM user_account
+		WHERE lower(email) = lower(?)`, email))
+}
+
+// userColumns lists the columns of the user_account table, named as table,
+// that scanUser reads.
+func userColumns(table string) string {
+	columns := []string{"email", "password_hash", "role", "totp_secret", "recovery_codes"}
+	for i, c := range columns {
+		columns[i] = table + "." + c
+	}
+	return strings.Join(columns, ", ")
+}
+
+// scanUser reads a user from a row with the columns of userColumns.
+func scanUser(row interface{ Scan(...interface{}) error }) (*app.User, error) {
+	var u app.User
+	var codes string
+	err := row.Scan(&u.Email, &u.PasswordHash, &u.Role, &u.TOTPSecret, &codes)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	u.RecoveryCodeHashes = strings.Fields(codes)
+	return &u, nil
+}
+
+// insertUser adds a user, who is an admin unless they have another role.
+func insertUser(ctx context.Context, tx *Tx, u app.User) error {
+	if u.Role == "" {
+		u.Role = app.RoleAdmin
+	}
+	if !app.ValidRole(u.Role) {
+		return fmt.Errorf("unknown role %q", u.Role)
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO user_account (email, password_hash, role, totp_secret, recovery_codes, created)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		u.Email, u.PasswordHash, u.Role, u.TOTPSecret, strings.Join(u.RecoveryCodeHashes, " "), time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// importSessions moves the sessions in user_session, which kept the tokens
+// themselves, into login_session, which only keeps their hashes.
+func (s *UserStoreSQL) importSessions(ctx context.Context) error {
+	tx, err := s.ts.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT token, email, created FROM user_session`)
+	if err != nil {
+		return err
+	}
+	var sessions []app.UserToken
+	for rows.Next() {
+		var token, email, created string
+		if err := rows.Scan(&token, &email, &created); err != nil {
+			rows.Close()
+			return err
+		}
+		t, _ := time.Parse(time.RFC3339, created)
+		sessions = append(sessions, legacySession(email, token, t))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	for _, t := range sessions {
+		if err := insertSession(ctx, tx, t); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_session`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertSession(ctx context.Context, tx *Tx, t app.UserToken) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO login_session
+		(id, email, token_hash, created, rotated, last_seen, ip, user_agent)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		t.ID, t.Email, t.TokenHash, t.Created.UTC().Format(time.RFC3339), t.Rotated.UTC().Format(time.RFC3339),
+		t.LastSeen.UTC().Format(time.RFC3339), t.IP, t.UserAgent)
+	return err
+}
+
+// sessionColumns lists the columns of login_session that scanSessions reads.
+const sessionColumns = `id, email, token_hash, created, rotated, last_seen, ip, user_agent`
+
+// scanSessions reads the sessions in rows and closes them.
+func scanSessions(rows *sql.Rows) ([]app.UserToken, error) {
+	defer rows.Close()
+
+	sessions := []app.UserToken{}
+	for rows.Next() {
+		var t app.UserToken
+		var created, rotated, lastSeen string
+		if err := rows.Scan(&t.ID, &t.Email, &t.TokenHash, &created, &rotated, &lastSeen, &t.IP, &t.UserAgent); err != nil {
+			return nil, err
+		}
+		var err error
+		if t.Created, err = time.Parse(time.RFC3339, created); err != nil {
+			return nil, err
+		}
+		if t.Rotated, err = time.Parse(time.RFC3339, rotated); err != nil {
+			return nil, err
+		}
+		if t.LastSeen, err = time.Parse(time.RFC3339, lastSeen); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, t)
+	}
+	return sessions, rows.Err()
+}
+
+// scanAPITokens reads the API tokens in rows and closes them.
+func scanAPITokens(rows *sql.Rows) ([]app.APIToken, error) {
+	defer rows.Close()
+
+	tokens := []app.APIToken{}
+	for rows.Next() {
+		var t app.APIToken
+		var created, expires string
+		if err := rows.Scan(&t.ID, &t.Email, &t.Name, &t.Hash, &t.Scope, &created, &expires); err != nil {
+			return nil, err
+		}
+		var err error
+		if t.Created, err = time.Parse(time.RFC3339, created); err != nil {
+			return nil, err
+		}
+		if expires != "" {
+			if t.Expires, err = time.Parse(time.RFC3339, expires); err != nil {
+				return nil, err
+			}
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// formatExpiry formats when an API token expires, which is empty when it
+// doesn't.
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -1,11 +1,16 @@
 package db
 
 import (
+	"crypto/subtle"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	app "github.com/etitcombe/tiddlypom"
 	"github.com/etitcombe/tiddlypom/rand"
@@ -14,15 +19,42 @@ import (
 
 var errNotFound = errors.New("not found")
 
+// The files that a UserStoreFile keeps its users and their tokens in.
+const (
+	usersFile      = "users.gob"
+	userTokensFile = "usertokens.gob"
+	apiTokensFile  = "apitokens.gob"
+)
+
 // UserStoreFile implements the UserStore interface against the file system.
+// The users and their tokens are kept in users.gob, usertokens.gob and
+// apitokens.gob in Dir.
+//
+// Each method holds lock from reading the files to writing them back, so that
+// concurrent changes don't undo each other. The unexported methods expect it
+// to be held already.
+//
+// What was read from each file is kept in cache, so that a file is only read
+// again once it has changed, such as when the admin tool adds a user.
 type UserStoreFile struct {
+	Dir          string
 	UserPwPepper string
 	lock         sync.Mutex
+	cache        map[string]cachedFile
 }
 
-// NewUserStoreFile creates and returns a new instance of a UserStoreFile.
-func NewUserStoreFile(pepper string) (*UserStoreFile, error) {
-	return &UserStoreFile{UserPwPepper: pepper}, nil
+// cachedFile is what was last read from one of the files, along with the
+// file's modification time and size when it was read.
+type cachedFile struct {
+	modTime time.Time
+	size    int64
+	value   interface{}
+}
+
+// NewUserStoreFile creates and returns a new instance of a UserStoreFile that
+// keeps its files in dir.
+func NewUserStoreFile(dir, pepper string) (*UserStoreFile, error) {
+	return &UserStoreFile{Dir: dir, UserPwPepper: pepper}, nil
 }
 
 // Authenticate authenticates a user based on email and password
@@ -47,13 +79,43 @@ func (s *UserStoreFile) Authenticate(email, password string) (*app.User, error)
 func (s *UserStoreFile) Close() {
 }
 
-// Create creates a new user.
-func (s *UserStoreFile) Create(user *app.User) error {
-	return nil
+// Create creates a new user with the given password.
+func (s *UserStoreFile) Create(user *app.User, password string) error {
+	if user.Role == "" {
+		user.Role = app.RoleAdmin
+	}
+	if !app.ValidRole(user.Role) {
+		return fmt.Errorf("unknown role %q", user.Role)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password+s.UserPwPepper), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = string(hash)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.byEmail(user.Email); err == nil {
+		return fmt.Errorf("user %s already exists", user.Email)
+	} else if err != errNotFound {
+		return err
+	}
+	users, err := s.retrieveUsers()
+	if err != nil {
+		return err
+	}
+	return s.saveUsers(append(users, *user))
 }
 
 // ByEmail retrieves a user by their email address.
 func (s *UserStoreFile) ByEmail(email string) (*app.User, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.byEmail(email)
+}
+
+func (s *UserStoreFile) byEmail(email string) (*app.User, error) {
 	users, err := s.retrieveUsers()
 	if err != nil {
 		return nil, err
@@ -66,36 +128,43 @@ func (s *UserStoreFile) ByEmail(email string) (*app.User, error) {
 	return nil, errNotFound
 }
 
-// ByRememberToken retrieves a user by their remember token.
-func (s *UserStoreFile) ByRememberToken(token string) (*app.User, error) {
+// ByRememberToken retrieves a user and their session by its remember token.
+func (s *UserStoreFile) ByRememberToken(token string) (*app.User, *app.UserToken, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	userTokens, err := s.retrieveUserTokens()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	for _, t := range userTokens {
-		if strings.EqualFold(t.RememberToken, token) {
-			return s.ByEmail(t.Email)
-		}
+	i := findSession(userTokens, token, true)
+	if i < 0 {
+		return nil, nil, errNotFound
 	}
-	return nil, errNotFound
+	u, err := s.byEmail(userTokens[i].Email)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := userTokens[i].UserToken()
+	return u, &t, nil
 }
 
-// CreateRememberToken creates a new remember token for a user.
-func (s *UserStoreFile) CreateRememberToken(user *app.User) (string, error) {
-	token, err := rand.RememberToken()
+// CreateRememberToken starts a new session for a user and returns its token.
+func (s *UserStoreFile) CreateRememberToken(user *app.User, ip, userAgent string) (string, error) {
+	t, token, err := newSession(user.Email, ip, userAgent)
 	if err != nil {
 		return "", err
 	}
-	userToken := app.UserToken{
-		Email:         user.Email,
-		RememberToken: token,
-	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
 	userTokens, err := s.retrieveUserTokens()
 	if err != nil {
 		return "", err
 	}
-	userTokens = append(userTokens, userToken)
+	userTokens = append(userTokens, storedUserToken{ID: t.ID, Email: t.Email, TokenHash: t.TokenHash,
+		Created: t.Created, Rotated: t.Rotated, LastSeen: t.LastSeen, IP: t.IP, UserAgent: t.UserAgent})
 	err = s.saveUserTokens(userTokens)
 	if err != nil {
 		return "", err
@@ -103,94 +172,548 @@ func (s *UserStoreFile) CreateRememberToken(user *app.User) (string, error) {
 	return token, nil
 }
 
-// ClearRememberToken clears the remember token in the store.
+// TouchRememberToken records the use of a session and, with rotate, replaces
+// its token. Nothing is rotated, and no token is returned, when token has
+// already been replaced.
+func (s *UserStoreFile) TouchRememberToken(token, ip, userAgent string, rotate bool) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	userTokens, err := s.retrieveUserTokens()
+	if err != nil {
+		return "", err
+	}
+	i := findSession(userTokens, token, false)
+	if i < 0 {
+		return "", nil
+	}
+
+	now := time.Now().UTC()
+	t := &userTokens[i]
+	t.LastSeen, t.IP, t.UserAgent = now, ip, truncateUserAgent(userAgent)
+	newToken := ""
+	if rotate {
+		if newToken, err = rand.RememberToken(); err != nil {
+			return "", err
+		}
+		t.PreviousHash, t.TokenHash, t.Rotated = t.TokenHash, hashToken(newToken), now
+	}
+	return newToken, s.saveUserTokens(userTokens)
+}
+
+// ClearRememberToken ends the session of a remember token.
 func (s *UserStoreFile) ClearRememberToken(token string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	userTokens, err := s.retrieveUserTokens()
 	if err != nil {
 		return err
 	}
-	tokenIndex := -1
-	for i, t := range userTokens {
-		if strings.EqualFold(t.RememberToken, token) {
-			tokenIndex = i
-			break
+	hash := hashToken(token)
+	kept := userTokens[:0]
+	for _, t := range userTokens {
+		if t.TokenHash != hash && t.PreviousHash != hash {
+			kept = append(kept, t)
 		}
 	}
-	if tokenIndex > -1 {
-		// https://yourbasic.org/golang/delete-element-slice/
-		userTokens[tokenIndex] = userTokens[len(userTokens)-1]
-		userTokens[len(userTokens)-1] = app.UserToken{}
-		userTokens = userTokens[:len(userTokens)-1]
+	return s.saveUserTokens(kept)
+}
+
+// List lists all of the users in email order.
+func (s *UserStoreFile) List() ([]app.User, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	users, err := s.retrieveUsers()
+	if err != nil {
+		return nil, err
 	}
-	return s.saveUserTokens(userTokens)
+	sort.Slice(users, func(i, j int) bool {
+		return strings.ToLower(users[i].Email) < strings.ToLower(users[j].Email)
+	})
+	return users, nil
 }
 
-func (s *UserStoreFile) retrieveUsers() ([]app.User, error) {
+// Delete deletes a user and their remember and API tokens.
+func (s *UserStoreFile) Delete(email string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	users, err := s.retrieveUsers()
+	if err != nil {
+		return err
+	}
+	kept := users[:0]
+	for _, u := range users {
+		if !strings.EqualFold(u.Email, email) {
+			kept = append(kept, u)
+		}
+	}
+	if len(kept) == len(users) {
+		return errNotFound
+	}
+	if err := s.clearRememberTokens(email); err != nil {
+		return err
+	}
+	apiTokens, err := s.retrieveAPITokens()
+	if err != nil {
+		return err
+	}
+	keptTokens := apiTokens[:0]
+	for _, t := range apiTokens {
+		if !strings.EqualFold(t.Email, email) {
+			keptTokens = append(keptTokens, t)
+		}
+	}
+	if err := s.saveAPITokens(keptTokens); err != nil {
+		return err
+	}
+	return s.saveUsers(kept)
+}
+
+// SetPassword changes the password of a user and ends all of their sessions.
+func (s *UserStoreFile) SetPassword(email, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password+s.UserPwPepper), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	users, err := s.retrieveUsers()
+	if err != nil {
+		return err
+	}
+	for i, u := range users {
+		if strings.EqualFold(u.Email, email) {
+			users[i].PasswordHash = string(hash)
+			if err := s.saveUsers(users); err != nil {
+				return err
+			}
+			return s.clearRememberTokens(email)
+		}
+	}
+	return errNotFound
+}
+
+// SetRole changes the role of a user.
+func (s *UserStoreFile) SetRole(email, role string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !app.ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	users, err := s.retrieveUsers()
+	if err != nil {
+		return err
+	}
+	for i, u := range users {
+		if strings.EqualFold(u.Email, email) {
+			users[i].Role = role
+			return s.saveUsers(users)
+		}
+	}
+	return errNotFound
+}
+
+// Sessions lists the sessions of a user, oldest first.
+func (s *UserStoreFile) Sessions(email string) ([]app.UserToken, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	f, err := os.Open("users.gob")
+	userTokens, err := s.retrieveUserTokens()
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	sessions := []app.UserToken{}
+	for _, t := range userTokens {
+		if strings.EqualFold(t.Email, email) {
+			sessions = append(sessions, t.UserToken())
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+	return sessions, nil
+}
 
-	users := []app.User{}
-	dec := gob.NewDecoder(f)
-	err = dec.Decode(&users)
+// DeleteSession ends the session of a user with the given ID.
+func (s *UserStoreFile) DeleteSession(email, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	userTokens, err := s.retrieveUserTokens()
+	if err != nil {
+		return err
+	}
+	kept := userTokens[:0]
+	for _, t := range userTokens {
+		if !strings.EqualFold(t.Email, email) || t.ID != id {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == len(userTokens) {
+		return errNotFound
+	}
+	return s.saveUserTokens(kept)
+}
+
+// ClearRememberTokens ends all of the sessions of a user.
+func (s *UserStoreFile) ClearRememberTokens(email string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.clearRememberTokens(email)
+}
+
+func (s *UserStoreFile) clearRememberTokens(email string) error {
+	userTokens, err := s.retrieveUserTokens()
+	if err != nil {
+		return err
+	}
+	kept := userTokens[:0]
+	for _, t := range userTokens {
+		if !strings.EqualFold(t.Email, email) {
+			kept = append(kept, t)
+		}
+	}
+	return s.saveUserTokens(kept)
+}
+
+// SetTOTP enrolls a user in two-factor authentication, or takes them out of it
+// when secret is empty.
+func (s *UserStoreFile) SetTOTP(email, secret string, recoveryCodes []string) error {
+	hashes, err := hashRecoveryCodes(recoveryCodes, s.UserPwPepper)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		hashes = nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	users, err := s.retrieveUsers()
+	if err != nil {
+		return err
+	}
+	for i, u := range users {
+		if strings.EqualFold(u.Email, email) {
+			users[i].TOTPSecret = secret
+			users[i].RecoveryCodeHashes = hashes
+			return s.saveUsers(users)
+		}
+	}
+	return errNotFound
+}
+
+// UseRecoveryCode checks a recovery code of a user and uses it up.
+func (s *UserStoreFile) UseRecoveryCode(email, code string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	users, err := s.retrieveUsers()
+	if err != nil {
+		return false, err
+	}
+	for i, u := range users {
+		if strings.EqualFold(u.Email, email) {
+			j := matchRecoveryCode(u.RecoveryCodeHashes, code, s.UserPwPepper)
+			if j < 0 {
+				return false, nil
+			}
+			users[i].RecoveryCodeHashes = append(u.RecoveryCodeHashes[:j:j], u.RecoveryCodeHashes[j+1:]...)
+			return true, s.saveUsers(users)
+		}
+	}
+	return false, errNotFound
+}
+
+// CreateAPIToken creates a new API token for a user.
+func (s *UserStoreFile) CreateAPIToken(t *app.APIToken) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	u, err := s.byEmail(t.Email)
+	if err != nil {
+		return "", err
+	}
+	token, err := newAPIToken(t)
+	if err != nil {
+		return "", err
+	}
+	t.Email = u.Email
+
+	apiTokens, err := s.retrieveAPITokens()
+	if err != nil {
+		return "", err
+	}
+	if err := s.saveAPITokens(append(apiTokens, *t)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ByAPIToken retrieves an API token and its user by the token.
+func (s *UserStoreFile) ByAPIToken(token string) (*app.User, *app.APIToken, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	apiTokens, err := s.retrieveAPITokens()
+	if err != nil {
+		return nil, nil, err
+	}
+	hash := hashToken(token)
+	for _, t := range apiTokens {
+		if t.Hash == hash {
+			u, err := s.byEmail(t.Email)
+			if err != nil {
+				return nil, nil, err
+			}
+			return u, &t, nil
+		}
+	}
+	return nil, nil, errNotFound
+}
+
+// APITokens lists the API tokens of a user, oldest first.
+func (s *UserStoreFile) APITokens(email string) ([]app.APIToken, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	apiTokens, err := s.retrieveAPITokens()
 	if err != nil {
 		return nil, err
 	}
-	return users, nil
+	tokens := []app.APIToken{}
+	for _, t := range apiTokens {
+		if strings.EqualFold(t.Email, email) {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+	return tokens, nil
 }
 
-func (s *UserStoreFile) retrieveUserTokens() ([]app.UserToken, error) {
+// DeleteAPIToken deletes the API token of a user with the given ID.
+func (s *UserStoreFile) DeleteAPIToken(email, id string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	userTokens := []app.UserToken{}
+	apiTokens, err := s.retrieveAPITokens()
+	if err != nil {
+		return err
+	}
+	kept := apiTokens[:0]
+	for _, t := range apiTokens {
+		if !strings.EqualFold(t.Email, email) || t.ID != id {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == len(apiTokens) {
+		return errNotFound
+	}
+	return s.saveAPITokens(kept)
+}
+
+func (s *UserStoreFile) retrieveUsers() ([]app.User, error) {
+	users := []app.User{}
 
-	f, err := os.Open("usertokens.gob")
+	f, fi, cached, err := s.openFile(usersFile)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return userTokens, nil
+			return users, nil
 		}
 		return nil, err
 	}
+	if cached != nil {
+		return append(users, cached.([]app.User)...), nil
+	}
 	defer f.Close()
 
 	dec := gob.NewDecoder(f)
-	err = dec.Decode(&userTokens)
+	err = dec.Decode(&users)
 	if err != nil {
 		return nil, err
 	}
-	return userTokens, nil
+	for i := range users {
+		if users[i].Role == "" {
+			users[i].Role = app.RoleAdmin
+		}
+	}
+	s.keep(usersFile, fi, users)
+	return append([]app.User{}, users...), nil
+}
+
+// storedUserToken is a session as it is kept in usertokens.gob. RememberToken
+// is the token itself, which was all that was kept before there were hashes,
+// and is only read to be replaced by its hash.
+type storedUserToken struct {
+	ID            string
+	Email         string
+	TokenHash     string
+	PreviousHash  string
+	RememberToken string
+	Created       time.Time
+	Rotated       time.Time
+	LastSeen      time.Time
+	IP            string
+	UserAgent     string
+}
+
+// UserToken returns the session without the hash of the token it replaced.
+func (t storedUserToken) UserToken() app.UserToken {
+	return app.UserToken{ID: t.ID, Email: t.Email, TokenHash: t.TokenHash, Created: t.Created,
+		Rotated: t.Rotated, LastSeen: t.LastSeen, IP: t.IP, UserAgent: t.UserAgent}
+}
+
+// findSession returns the index of the session whose token is token, or, with
+// previous, whose token was token until it was rotated just now. It is -1 when
+// there is none. The hashes are compared in constant time.
+func findSession(userTokens []storedUserToken, token string, previous bool) int {
+	hash := []byte(hashToken(token))
+	for i, t := range userTokens {
+		if subtle.ConstantTimeCompare([]byte(t.TokenHash), hash) == 1 {
+			return i
+		}
+		if previous && subtle.ConstantTimeCompare([]byte(t.PreviousHash), hash) == 1 &&
+			time.Since(t.Rotated) < rotationGrace {
+			return i
+		}
+	}
+	return -1
+}
+
+// retrieveUserTokens reads the sessions, and hashes the tokens of any from
+// before there were hashes.
+func (s *UserStoreFile) retrieveUserTokens() ([]storedUserToken, error) {
+	userTokens, legacy, err := s.readUserTokens()
+	if err != nil || !legacy {
+		return userTokens, err
+	}
+	return userTokens, s.saveUserTokens(userTokens)
+}
+
+func (s *UserStoreFile) readUserTokens() ([]storedUserToken, bool, error) {
+	userTokens := []storedUserToken{}
+
+	f, fi, cached, err := s.openFile(userTokensFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return userTokens, false, nil
+		}
+		return nil, false, err
+	}
+	if cached != nil {
+		return append(userTokens, cached.([]storedUserToken)...), false, nil
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(f)
+	err = dec.Decode(&userTokens)
+	if err != nil {
+		return nil, false, err
+	}
+
+	legacy := false
+	for i, t := range userTokens {
+		if t.RememberToken != "" {
+			l := legacySession(t.Email, t.RememberToken, t.Created)
+			userTokens[i] = storedUserToken{ID: l.ID, Email: l.Email, TokenHash: l.TokenHash,
+				Created: l.Created, Rotated: l.Rotated, LastSeen: l.LastSeen}
+			legacy = true
+		}
+	}
+	if !legacy {
+		s.keep(userTokensFile, fi, userTokens)
+	}
+	return append([]storedUserToken{}, userTokens...), legacy, nil
 }
 
 func (s *UserStoreFile) saveUsers(users []app.User) error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+	return s.writeFile(usersFile, users)
+}
 
-	f, err := os.OpenFile("users.gob", os.O_CREATE|os.O_RDWR, 0666)
+func (s *UserStoreFile) saveUserTokens(userTokens []storedUserToken) error {
+	return s.writeFile(userTokensFile, userTokens)
+}
+
+func (s *UserStoreFile) retrieveAPITokens() ([]app.APIToken, error) {
+	apiTokens := []app.APIToken{}
+
+	f, fi, cached, err := s.openFile(apiTokensFile)
 	if err != nil {
-		return err
+		if os.IsNotExist(err) {
+			return apiTokens, nil
+		}
+		return nil, err
+	}
+	if cached != nil {
+		return append(apiTokens, cached.([]app.APIToken)...), nil
 	}
 	defer f.Close()
 
-	enc := gob.NewEncoder(f)
-	return enc.Encode(users)
+	if err := gob.NewDecoder(f).Decode(&apiTokens); err != nil {
+		return nil, err
+	}
+	s.keep(apiTokensFile, fi, apiTokens)
+	return append([]app.APIToken{}, apiTokens...), nil
 }
 
-func (s *UserStoreFile) saveUserTokens(userTokens []app.UserToken) error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+// openFile opens the named file in Dir to be read, unless what was read from
+// it last is still in the cache, in which case that is returned instead and
+// the file is nil. The callers copy what is cached before changing it.
+func (s *UserStoreFile) openFile(name string) (*os.File, os.FileInfo, interface{}, error) {
+	f, err := os.Open(filepath.Join(s.Dir, name))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	if c, ok := s.cache[name]; ok && c.modTime.Equal(fi.ModTime()) && c.size == fi.Size() {
+		f.Close()
+		return nil, fi, c.value, nil
+	}
+	return f, fi, nil, nil
+}
 
-	f, err := os.OpenFile("usertokens.gob", os.O_CREATE|os.O_RDWR, 0666)
+// keep caches what was read from the named file, which fi describes. A file
+// changed within the last second isn't cached, since another change in the
+// same tick of the file system's clock wouldn't change its modification time.
+func (s *UserStoreFile) keep(name string, fi os.FileInfo, value interface{}) {
+	if time.Since(fi.ModTime()) < time.Second {
+		delete(s.cache, name)
+		return
+	}
+	if s.cache == nil {
+		s.cache = map[string]cachedFile{}
+	}
+	s.cache[name] = cachedFile{modTime: fi.ModTime(), size: fi.Size(), value: value}
+}
+
+func (s *UserStoreFile) saveAPITokens(apiTokens []app.APIToken) error {
+	return s.writeFile(apiTokensFile, apiTokens)
+}
+
+// writeFile replaces the contents of the named file in Dir with v. Only its
+// owner may read it, even when it was written before that was the case.
+func (s *UserStoreFile) writeFile(name string, v interface{}) error {
+	f, err := os.OpenFile(filepath.Join(s.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	enc := gob.NewEncoder(f)
-	return enc.Encode(userTokens)
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
+	return gob.NewEncoder(f).Encode(v)
 }
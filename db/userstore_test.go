@@ -0,0 +1,170 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+func newTestUserStoreFile(t *testing.T) *UserStoreFile {
+	t.Helper()
+	s, err := NewUserStoreFile(t.TempDir(), "pepper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(&app.User{Email: "a@b.c"}, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// TestUserStoreFileConcurrentSessions makes sure that sessions started and
+// used at the same time don't undo each other.
+func TestUserStoreFileConcurrentSessions(t *testing.T) {
+	s := newTestUserStoreFile(t)
+	u, err := s.ByEmail("a@b.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 50
+	tokens := make([]string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := s.CreateRememberToken(u, "127.0.0.1", "test")
+			if err != nil {
+				t.Error(err)
+			}
+			tokens[i] = token
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := s.TouchRememberToken(tokens[i], "127.0.0.2", "test", i%2 == 0); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	sessions, err := s.Sessions("a@b.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != n {
+		t.Fatalf("got %d sessions, want %d", len(sessions), n)
+	}
+	for _, session := range sessions {
+		if session.IP != "127.0.0.2" {
+			t.Errorf("session %s was last seen from %q", session.ID, session.IP)
+		}
+	}
+}
+
+func TestUserStoreFileModes(t *testing.T) {
+	s := newTestUserStoreFile(t)
+	u, err := s.ByEmail("a@b.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateRememberToken(u, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateAPIToken(&app.APIToken{Email: u.Email, Name: "ci", Scope: app.ScopeRead}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{usersFile, userTokensFile, apiTokensFile} {
+		fi, err := os.Stat(filepath.Join(s.Dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := fi.Mode().Perm(); mode&0077 != 0 {
+			t.Errorf("%s has mode %v", name, mode)
+		}
+	}
+}
+
+// TestUserStoreFileCache makes sure that the users are only read again once
+// their file has changed.
+func TestUserStoreFileCache(t *testing.T) {
+	s := newTestUserStoreFile(t)
+	name := filepath.Join(s.Dir, usersFile)
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(name, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ByEmail("a@b.c"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Garbage of the same size and age isn't noticed.
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, make([]byte, fi.Size()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ByEmail("a@b.c"); err != nil {
+		t.Fatalf("ByEmail read the file again: %v", err)
+	}
+
+	// A user added by another program, such as the admin tool, is.
+	other, err := NewUserStoreFile(s.Dir, "pepper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.saveUsers([]app.User{{Email: "d@e.f", Role: app.RoleReader}}); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := s.ByEmail("d@e.f"); err != nil || u.Role != app.RoleReader {
+		t.Fatalf("ByEmail of the new user = %v, %v", u, err)
+	}
+}
+
+func TestUserStoreFileSetPassword(t *testing.T) {
+	testUserStoreSetPassword(t, newTestUserStoreFile(t))
+}
+
+// testUserStoreSetPassword makes sure that changing a user's password logs
+// them out everywhere. The email address is made unique so that a database can
+// be used more than once.
+func testUserStoreSetPassword(t *testing.T, us app.UserStore) {
+	email := fmt.Sprintf("Password%d@example.com", time.Now().UnixNano())
+	u := &app.User{Email: email, Role: app.RoleWriter}
+	if err := us.Create(u, "old"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := us.CreateRememberToken(u, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := us.SetPassword(strings.ToLower(email), "new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(email, "new"); err != nil {
+		t.Errorf("Authenticate with the new password: %v", err)
+	}
+	if _, _, err := us.ByRememberToken(token); err == nil {
+		t.Error("the session outlived the change of password")
+	}
+}
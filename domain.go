@@ -1,42 +1,239 @@
 package app
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when the requested item does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when a tiddler has changed since the revision that
+// an update was based on.
+var ErrConflict = errors.New("conflict")
+
+// IfNew is the ifRev of an Upsert that only creates a tiddler, and doesn't
+// replace one that already exists.
+const IfNew = -1
+
+// DefaultBag and DefaultRecipe are the bag and recipe that every wiki has. The
+// default recipe is made up of the default bag alone.
+const (
+	DefaultBag    = "default"
+	DefaultRecipe = "default"
+)
 
 // Tiddler represents a tiddlywiki tiddler.
 type Tiddler struct {
+	Bag      string
+	Title    string
 	Rev      int
 	Meta     string
 	Text     string
 	IsSystem bool
 }
 
+// Revision describes one stored revision of a tiddler. A deleted revision is
+// the tombstone left behind when a tiddler is deleted.
+type Revision struct {
+	Bag     string
+	Title   string
+	Rev     int
+	Created time.Time
+	Deleted bool
+}
+
+// SearchResult is a tiddler that matched a full-text search. Snippet is an
+// extract of the text, escaped as HTML, with the matching terms wrapped in
+// <mark> tags.
+type SearchResult struct {
+	Bag     string
+	Title   string
+	Rev     int
+	Snippet string
+	Score   float64
+}
+
+// Change is an entry in the change feed of a store: a tiddler as a write left
+// it, or its tombstone when Deleted is true. Seq numbers the writes to every bag
+// of the store in the order they were made.
+type Change struct {
+	Tiddler
+	Seq     int64
+	Deleted bool
+}
+
+// Bag is a named collection of tiddlers. A title is unique within a bag.
+type Bag struct {
+	Name        string
+	Description string
+	Policy      Policy
+}
+
+// Policy says who may read and who may write the tiddlers in a bag, as in
+// TiddlyWeb. Each list holds email addresses, roles written as R:{role}, or ANY
+// for anyone who is logged in. An empty list leaves it to each user's role.
+// Admins aren't bound by policies.
+type Policy struct {
+	Read  []string `json:"read,omitempty"`
+	Write []string `json:"write,omitempty"`
+}
+
+// Recipe is an ordered list of bags that together make up a wiki. When more
+// than one of the bags has a tiddler with the same title, the one in the bag
+// that comes last is used, and new tiddlers are saved in the last bag.
+type Recipe struct {
+	Name        string
+	Description string
+	Bags        []string
+}
+
 // TiddlyStore represents the actions that can be taken about tiddlers.
+//
+// Upsert assigns the next revision number and returns the stored tiddler. When
+// ifRev is not zero the tiddler is only written if its current revision is
+// ifRev, or if it doesn't exist when ifRev is IfNew, otherwise ErrConflict is
+// returned.
+//
+// GetList leaves out system tiddlers and text, while GetAll includes both.
+//
+// Changes gives the latest change to each tiddler that was written or deleted
+// after the change numbered since, in order, up to limit of them and with
+// their text only when text is true.
+//
+// Search looks in all of bags.
 type TiddlyStore interface {
-	Delete(ctx context.Context, title string) error
-	Get(ctx context.Context, title string) (Tiddler, error)
-	GetList(ctx context.Context) ([]Tiddler, error)
-	Upsert(ctx context.Context, title string, t Tiddler) error
+	Bag(ctx context.Context, name string) (Bag, error)
+	Bags(ctx context.Context) ([]Bag, error)
+	PutBag(ctx context.Context, b Bag) error
+	PutRecipe(ctx context.Context, r Recipe) error
+	Recipe(ctx context.Context, name string) (Recipe, error)
+	Recipes(ctx context.Context) ([]Recipe, error)
+
+	Changes(ctx context.Context, since int64, limit int, text bool) ([]Change, error)
+	Delete(ctx context.Context, bag, title string) error
+	Deleted(ctx context.Context, bag string) ([]Revision, error)
+	Get(ctx context.Context, bag, title string) (Tiddler, error)
+	GetAll(ctx context.Context, bag string) ([]Tiddler, error)
+	GetList(ctx context.Context, bag string) ([]Tiddler, error)
+	GetRevision(ctx context.Context, bag, title string, rev int) (Tiddler, error)
+	Restore(ctx context.Context, bag, title string, rev int) (Tiddler, error)
+	Revisions(ctx context.Context, bag, title string) ([]Revision, error)
+	Search(ctx context.Context, bags []string, query string, limit, offset int) ([]SearchResult, error)
+	Upsert(ctx context.Context, bag, title string, t Tiddler, ifRev int) (Tiddler, error)
 }
 
-// UserStore represents the actions that can be taken about users.
+// UserStore represents the actions that can be taken about users. Users are
+// found by their email address whatever its case.
+//
+// A user who logs in gets a session, which their browser remembers by a token.
+// ByRememberToken finds a session by its token, whether or not it has timed
+// out, and the token it replaced is still good for a moment after it has been
+// rotated. TouchRememberToken records that a session was used, and with rotate
+// replaces its token with a new one, which it returns. Sessions lists the
+// sessions of a user, oldest first, DeleteSession ends one of them and
+// ClearRememberTokens logs the user out everywhere, as SetPassword does too.
+//
+// SetTOTP enrolls a user in two-factor authentication with the secret and
+// recovery codes given, replacing any they had, or takes them out of it when
+// secret is empty. UseRecoveryCode reports whether code is one of the user's
+// recovery codes and, when it is, uses it up.
+//
+// CreateAPIToken gives t its ID, hash and creation time and returns the token,
+// which can't be got again. ByAPIToken finds a token, expired or not, along
+// with its user, and APITokens lists the tokens of a user, oldest first.
 type UserStore interface {
 	Close()
 	Authenticate(email, password string) (*User, error)
-	Create(user *User) error
+	Create(user *User, password string) error
 	ByEmail(email string) (*User, error)
-	ByRememberToken(token string) (*User, error)
-	CreateRememberToken(user *User) (string, error)
+	ByRememberToken(token string) (*User, *UserToken, error)
+	CreateRememberToken(user *User, ip, userAgent string) (string, error)
+	TouchRememberToken(token, ip, userAgent string, rotate bool) (string, error)
 	ClearRememberToken(token string) error
+	List() ([]User, error)
+	Delete(email string) error
+	SetPassword(email, password string) error
+	SetRole(email, role string) error
+	Sessions(email string) ([]UserToken, error)
+	DeleteSession(email, id string) error
+	ClearRememberTokens(email string) error
+	SetTOTP(email, secret string, recoveryCodes []string) error
+	UseRecoveryCode(email, code string) (bool, error)
+	CreateAPIToken(t *APIToken) (string, error)
+	ByAPIToken(token string) (*User, *APIToken, error)
+	APITokens(email string) ([]APIToken, error)
+	DeleteAPIToken(email, id string) error
 }
 
-// User represents a user in our system.
+// The roles that a user can have. A reader can only read tiddlers, a writer
+// can also save and delete them, and an admin can also change bags and recipes
+// and isn't bound by the policies of bags. Users from before there were roles
+// have none and are admins.
+const (
+	RoleReader = "reader"
+	RoleWriter = "writer"
+	RoleAdmin  = "admin"
+)
+
+// ValidRole reports whether role is one of the roles.
+func ValidRole(role string) bool {
+	return role == RoleReader || role == RoleWriter || role == RoleAdmin
+}
+
+// User represents a user in our system. A user who has enrolled in two-factor
+// authentication has a TOTPSecret, shared with their authenticator app, and
+// the bcrypt hashes of the recovery codes they haven't used yet.
 type User struct {
-	Email        string
-	PasswordHash string
+	Email              string
+	PasswordHash       string
+	Role               string
+	TOTPSecret         string
+	RecoveryCodeHashes []string
 }
 
-// UserToken is a remember token created by a user.
+// UserToken is the session of a user who logged in, which is remembered by a
+// token in a cookie. Only the hash of the token is kept, and ID names the
+// session when it is listed or ended. Rotated is when the token was last
+// replaced, and LastSeen, IP and UserAgent are from when it was last used.
 type UserToken struct {
-	Email         string
-	RememberToken string
+	ID        string
+	Email     string
+	TokenHash string
+	Created   time.Time
+	Rotated   time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+}
+
+// The scopes of an API token. A token with the read scope can only read, and
+// one with the write scope can do whatever its user can.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// ValidScope reports whether scope is one of the scopes of API tokens.
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeWrite
+}
+
+// APIToken is a token that a script sends in an Authorization: Bearer header
+// to act as a user. Only the hash of the token is kept, and ID names it when
+// it is listed or revoked. It never expires when Expires is zero.
+type APIToken struct {
+	ID      string
+	Email   string
+	Name    string
+	Scope   string
+	Hash    string
+	Created time.Time
+	Expires time.Time
+}
+
+// Expired reports whether the token has expired by now.
+func (t APIToken) Expired(now time.Time) bool {
+	return !t.Expires.IsZero() && !now.Before(t.Expires)
 }
@@ -0,0 +1,200 @@
+// Package filter runs TiddlyWiki filters, such as
+//
+//	[tag[Meeting]days[-7]!sort[modified]]
+//
+// against a set of tiddlers on the server. Only part of the filter language is
+// understood: runs with any of the prefixes, and the operators all, days,
+// field, has, is, limit, prefix, search, sort, suffix, tag and title. As in
+// TiddlyWiki, any other operator name is taken to be a field name, so that
+// [type[image/png]] is short for [field:type[image/png]]. There are no
+// variables or text references on the server, so operands must be literal.
+package filter
+
+import (
+	"sort"
+	"strings"
+)
+
+// A Filter is a parsed filter expression.
+type Filter struct {
+	runs []run
+}
+
+// run is one run of a filter: a list of steps and how its results are
+// combined with those of the runs before it.
+type run struct {
+	// prefix is one of '+', '-', '~' and '=', or 0 for none.
+	prefix byte
+	steps  []step
+}
+
+// step is one operator of a run, like !tag[x] or field:title[y].
+type step struct {
+	op      string
+	suffix  string
+	negate  bool
+	operand string
+}
+
+// A Set is the tiddlers a filter is run against.
+type Set struct {
+	titles   []string
+	tiddlers map[string]map[string]string
+}
+
+// NewSet makes a set of tiddlers, each given as its fields. A filter sees them
+// in order of title.
+func NewSet(tiddlers []map[string]string) *Set {
+	s := &Set{tiddlers: make(map[string]map[string]string, len(tiddlers))}
+	for _, fields := range tiddlers {
+		title := fields["title"]
+		if _, ok := s.tiddlers[title]; !ok {
+			s.titles = append(s.titles, title)
+		}
+		s.tiddlers[title] = fields
+	}
+	sort.Strings(s.titles)
+	return s
+}
+
+// field returns a field of a tiddler. The title of a tiddler that isn't in the
+// set is still known.
+func (s *Set) field(title, name string) string {
+	if name == "title" {
+		return title
+	}
+	return s.tiddlers[title][name]
+}
+
+func (s *Set) exists(title string) bool {
+	_, ok := s.tiddlers[title]
+	return ok
+}
+
+// Run runs the filter against set and returns the titles it selects, in order.
+// Titles can be selected that aren't in the set, such as those named by a title
+// run like [[Some title]].
+func (f *Filter) Run(set *Set) ([]string, error) {
+	results := []string{}
+	for _, r := range f.runs {
+		input := set.titles
+		if r.prefix == '+' {
+			input = results
+		} else if r.prefix == '~' && len(results) > 0 {
+			continue
+		}
+
+		titles, err := r.eval(set, input)
+		if err != nil {
+			return nil, err
+		}
+
+		switch r.prefix {
+		case 0:
+			results = pushTop(results, titles)
+		case '=':
+			results = append(results, titles...)
+		case '-':
+			results = without(results, titles)
+		case '+', '~':
+			results = titles
+		}
+	}
+	return results, nil
+}
+
+// UsesText reports whether the filter looks at the text of tiddlers, which can
+// be left out of the set when it doesn't.
+func (f *Filter) UsesText() bool {
+	for _, r := range f.runs {
+		for _, st := range r.steps {
+			if st.usesText() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (st step) usesText() bool {
+	switch st.op {
+	case "field", "days":
+		return st.suffix == "text"
+	case "has":
+		return st.operand == "text"
+	case "sort":
+		return st.operand == "text"
+	case "search":
+		for _, name := range searchFields(st.suffix) {
+			if name == "text" || name == "*" {
+				return true
+			}
+		}
+		return false
+	case "all", "is", "limit", "prefix", "suffix", "tag", "title":
+		return false
+	default:
+		return st.op == "text"
+	}
+}
+
+// eval runs the steps of r in turn, starting from input.
+func (r run) eval(set *Set, input []string) ([]string, error) {
+	titles := input
+	for _, st := range r.steps {
+		op, ok := operators[st.op]
+		if !ok {
+			op, st.suffix = fieldOp, st.op
+		}
+		var err error
+		if titles, err = op(set, titles, st); err != nil {
+			return nil, err
+		}
+	}
+	return titles, nil
+}
+
+// pushTop adds titles to the end of results, moving any already there.
+func pushTop(results, titles []string) []string {
+	return append(without(results, titles), dedupe(titles)...)
+}
+
+// without returns the titles of results that aren't in titles.
+func without(results, titles []string) []string {
+	drop := make(map[string]bool, len(titles))
+	for _, title := range titles {
+		drop[title] = true
+	}
+	kept := make([]string, 0, len(results))
+	for _, title := range results {
+		if !drop[title] {
+			kept = append(kept, title)
+		}
+	}
+	return kept
+}
+
+func dedupe(titles []string) []string {
+	seen := make(map[string]bool, len(titles))
+	out := make([]string, 0, len(titles))
+	for _, title := range titles {
+		if !seen[title] {
+			seen[title] = true
+			out = append(out, title)
+		}
+	}
+	return out
+}
+
+// searchFields returns the fields that a search step with suffix looks in,
+// which is given before any flags, as in search:title,caption:literal.
+func searchFields(suffix string) []string {
+	list := suffix
+	if i := strings.IndexByte(list, ':'); i >= 0 {
+		list = list[:i]
+	}
+	if list == "" {
+		return []string{"title", "tags", "text"}
+	}
+	return strings.Split(list, ",")
+}
@@ -0,0 +1,168 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testSet is the set of tiddlers that the filters are run against. Its titles
+// in order are $:/config/X, Alpha, Beta, Gamma and Meeting.
+func testSet() *Set {
+	return NewSet([]map[string]string{
+		{"title": "Alpha", "tags": "Meeting [[Big Plans]]", "modified": "20260110120000000", "text": "hello world", "color": "red"},
+		{"title": "Beta", "tags": "Meeting", "modified": "20260101120000000", "text": "goodbye", "color": ""},
+		{"title": "Gamma", "tags": "Other", "modified": "20251201120000000", "text": "Hello again", "type": "image/png"},
+		{"title": "Meeting", "text": "the meetings"},
+		{"title": "$:/config/X", "text": "system"},
+	})
+}
+
+func TestRun(t *testing.T) {
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return time.Date(2026, time.January, 10, 12, 0, 0, 0, time.Local) }
+
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		// Operators.
+		{"[all[tiddlers]]", "[$:/config/X Alpha Beta Gamma Meeting]"},
+		{"[all[shadows]]", "[]"},
+		{"[tag[Meeting]]", "[Alpha Beta]"},
+		{"[tag[Big Plans]]", "[Alpha]"},
+		{"[!tag[Meeting]]", "[$:/config/X Gamma Meeting]"},
+		{"[is[system]]", "[$:/config/X]"},
+		{"[!is[system]]", "[Alpha Beta Gamma Meeting]"},
+		{"[is[tag]]", "[Meeting]"},
+		{"[is[image]]", "[Gamma]"},
+		{"[title[Nowhere]is[missing]]", "[Nowhere]"},
+		{"[title[Alpha]is[missing]]", "[]"},
+		{"[has[color]]", "[Alpha]"},
+		{"[has:field[color]]", "[Alpha Beta]"},
+		{"[field:color[red]]", "[Alpha]"},
+		{"[color[red]]", "[Alpha]"},
+		{"[prefix[Al]]", "[Alpha]"},
+		{"[suffix[ta]]", "[Beta]"},
+		{"[!title[Alpha]!is[system]]", "[Beta Gamma Meeting]"},
+		{"[search[hello]]", "[Alpha Gamma]"},
+		{"[search[world hello]]", "[Alpha]"},
+		{"[search:text:literal[world hello]]", "[]"},
+		{"[search:text:casesensitive[Hello]]", "[Gamma]"},
+		{"[search:title[amm]]", "[Gamma]"},
+		{"[search:*[red]]", "[Alpha]"},
+		{"[tag[Meeting]sort[modified]]", "[Beta Alpha]"},
+		{"[tag[Meeting]!sort[modified]]", "[Alpha Beta]"},
+		{"[!is[system]limit[2]]", "[Alpha Beta]"},
+		{"[!is[system]!limit[1]]", "[Meeting]"},
+		{"[!is[system]limit[-1]]", "[]"},
+		{"[days[-7]]", "[Alpha]"},
+		{"[days[-14]]", "[Alpha Beta]"},
+		{"[!days[-14]]", "[Gamma]"},
+
+		// Runs and their prefixes.
+		{"Alpha \"Beta Two\" 'x'", "[Alpha Beta Two x]"},
+		{"[[Beta]] [[Alpha]] [[Beta]]", "[Alpha Beta]"},
+		{"[[Alpha]] =[[Alpha]]", "[Alpha Alpha]"},
+		{"[tag[Meeting]] [tag[Other]]", "[Alpha Beta Gamma]"},
+		{"[tag[Meeting]] -[[Beta]]", "[Alpha]"},
+		{"[tag[Meeting]] :except[[Beta]]", "[Alpha]"},
+		{"[tag[Meeting]] +[prefix[B]]", "[Beta]"},
+		{"[tag[Meeting]] :and[prefix[B]]", "[Beta]"},
+		{"[tag[Nothing]] ~[[Fallback]]", "[Fallback]"},
+		{"[tag[Meeting]] :else[[Fallback]]", "[Alpha Beta]"},
+		{"[[Alpha]] :all[[Alpha]]", "[Alpha Alpha]"},
+		{"[tag[Meeting]] :or[tag[Other]]", "[Alpha Beta Gamma]"},
+		{"", "[]"},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.filter)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.filter, err)
+			continue
+		}
+		got, err := f.Run(testSet())
+		if err != nil {
+			t.Errorf("Run(%q): %v", tt.filter, err)
+			continue
+		}
+		if s := fmt.Sprint(got); s != tt.want {
+			t.Errorf("Run(%q) = %s, want %s", tt.filter, s, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"[tag[x]",
+		"[tag[x",
+		"[tag]",
+		"[[x",
+		`"x`,
+		"'x",
+		"]",
+		"+",
+		":nope[x]",
+		"[tag{x}]",
+		"[tag<x>]",
+		"[regexp/x/]",
+	}
+	for _, filter := range tests {
+		if _, err := Parse(filter); !errors.Is(err, ErrSyntax) {
+			t.Errorf("Parse(%q) error = %v, want %v", filter, err, ErrSyntax)
+		}
+	}
+}
+
+func TestRunOperandErrors(t *testing.T) {
+	tests := []string{
+		"[all[everything]]",
+		"[days[soon]]",
+		"[limit[some]]",
+		"[is[nope]]",
+		"[field[x]]",
+	}
+	for _, filter := range tests {
+		f, err := Parse(filter)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", filter, err)
+			continue
+		}
+		if _, err := f.Run(testSet()); !errors.Is(err, ErrOperand) {
+			t.Errorf("Run(%q) error = %v, want %v", filter, err, ErrOperand)
+		}
+	}
+}
+
+func TestUsesText(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"[tag[x]]", false},
+		{"[[Alpha]]", false},
+		{"[search[x]]", true},
+		{"[search:title[x]]", false},
+		{"[search:title,text[x]]", true},
+		{"[search:*[x]]", true},
+		{"[field:text[x]]", true},
+		{"[text[x]]", true},
+		{"[color[x]]", false},
+		{"[has[text]]", true},
+		{"[has[color]]", false},
+		{"[sort[text]]", true},
+		{"[sort[title]]", false},
+		{"[tag[x]] +[search[y]]", true},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.filter)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.filter, err)
+			continue
+		}
+		if got := f.UsesText(); got != tt.want {
+			t.Errorf("UsesText(%q) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
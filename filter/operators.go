@@ -0,0 +1,285 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etitcombe/tiddlypom/tiddlywiki"
+)
+
+// An operator takes the titles selected by the steps before it, or the whole
+// set for the first step of a run, and selects titles of its own.
+type operator func(set *Set, input []string, st step) ([]string, error)
+
+// operators are the filter operators that are understood, by name.
+var operators = map[string]operator{
+	"all":    allOp,
+	"days":   daysOp,
+	"field":  fieldOp,
+	"has":    hasOp,
+	"is":     isOp,
+	"limit":  limitOp,
+	"prefix": prefixOp,
+	"search": searchOp,
+	"sort":   sortOp,
+	"suffix": suffixOp,
+	"tag":    tagOp,
+	"title":  titleOp,
+}
+
+// now is the time that days counts back from.
+var now = time.Now
+
+// selectTitles keeps the titles of input for which keep returns true, or false
+// when the step is negated.
+func selectTitles(input []string, st step, keep func(title string) bool) []string {
+	out := []string{}
+	for _, title := range input {
+		if keep(title) != st.negate {
+			out = append(out, title)
+		}
+	}
+	return out
+}
+
+// allOp selects all the tiddlers in the set with all[tiddlers]. There are no
+// shadow tiddlers on the server, so all[shadows] selects nothing.
+func allOp(set *Set, input []string, st step) ([]string, error) {
+	var out []string
+	for _, kind := range strings.Split(st.operand, "+") {
+		switch kind {
+		case "tiddlers":
+			out = append(out, set.titles...)
+		case "shadows":
+		default:
+			return nil, fmt.Errorf("%w: all can't select %q", ErrOperand, kind)
+		}
+	}
+	return dedupe(out), nil
+}
+
+// daysOp selects the tiddlers whose date field, modified unless the suffix
+// names another, falls within a number of days of today: the last days for a
+// negative number and the next ones for a positive one.
+func daysOp(set *Set, input []string, st step) ([]string, error) {
+	days, err := strconv.Atoi(strings.TrimSpace(st.operand))
+	if err != nil {
+		return nil, fmt.Errorf("%w: days needs a number of days, not %q", ErrOperand, st.operand)
+	}
+	name := st.suffix
+	if name == "" {
+		name = "modified"
+	}
+
+	t := now()
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	target := today.AddDate(0, 0, days)
+
+	out := []string{}
+	for _, title := range input {
+		date, ok := tiddlywiki.ParseDate(set.field(title, name))
+		if !ok {
+			continue
+		}
+		d := date.In(time.Local)
+		day := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.Local)
+		within := day.Equal(target) ||
+			days < 0 && day.After(target) ||
+			days > 0 && day.Before(target)
+		if within != st.negate {
+			out = append(out, title)
+		}
+	}
+	return out, nil
+}
+
+// fieldOp selects the tiddlers with a field, named by the suffix, set to the
+// operand.
+func fieldOp(set *Set, input []string, st step) ([]string, error) {
+	if st.suffix == "" {
+		return nil, fmt.Errorf("%w: field needs the name of a field, as in field:name[value]", ErrOperand)
+	}
+	return selectTitles(input, st, func(title string) bool {
+		return set.exists(title) && set.field(title, st.suffix) == st.operand
+	}), nil
+}
+
+// hasOp selects the tiddlers that have a field that isn't empty, or with
+// has:field, that have the field at all.
+func hasOp(set *Set, input []string, st step) ([]string, error) {
+	return selectTitles(input, st, func(title string) bool {
+		value, ok := set.tiddlers[title][st.operand]
+		if st.suffix == "field" {
+			return ok
+		}
+		return value != ""
+	}), nil
+}
+
+func isOp(set *Set, input []string, st step) ([]string, error) {
+	var keep func(title string) bool
+	switch st.operand {
+	case "binary":
+		keep = func(title string) bool { return tiddlywiki.IsBinaryType(set.field(title, "type")) }
+	case "image":
+		keep = func(title string) bool { return strings.HasPrefix(set.field(title, "type"), "image/") }
+	case "missing":
+		keep = func(title string) bool { return !set.exists(title) }
+	case "shadow":
+		keep = func(title string) bool { return false }
+	case "system":
+		keep = func(title string) bool { return strings.HasPrefix(title, "$:/") }
+	case "tag":
+		tags := map[string]bool{}
+		for _, title := range set.titles {
+			for _, tag := range tiddlywiki.ParseStringList(set.field(title, "tags")) {
+				tags[tag] = true
+			}
+		}
+		keep = func(title string) bool { return tags[title] }
+	case "tiddler":
+		keep = set.exists
+	default:
+		return nil, fmt.Errorf("%w: is doesn't know %q", ErrOperand, st.operand)
+	}
+	return selectTitles(input, st, keep), nil
+}
+
+// limitOp keeps the first so many titles, or the last with !limit.
+func limitOp(set *Set, input []string, st step) ([]string, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(st.operand))
+	if err != nil {
+		return nil, fmt.Errorf("%w: limit needs a number, not %q", ErrOperand, st.operand)
+	}
+	if n < 0 {
+		n = 0
+	}
+	if n >= len(input) {
+		return input, nil
+	}
+	if st.negate {
+		return input[len(input)-n:], nil
+	}
+	return input[:n], nil
+}
+
+func prefixOp(set *Set, input []string, st step) ([]string, error) {
+	return selectTitles(input, st, func(title string) bool {
+		return strings.HasPrefix(title, st.operand)
+	}), nil
+}
+
+// searchOp selects the tiddlers in which every word of the operand appears,
+// ignoring case. The words are looked for in the title, tags and text, or in
+// the fields listed in the suffix, where * means all of them. The flags after
+// the fields can be literal, to look for the operand as it is, and
+// casesensitive.
+func searchOp(set *Set, input []string, st step) ([]string, error) {
+	fields := searchFields(st.suffix)
+	var literal, caseSensitive bool
+	if i := strings.IndexByte(st.suffix, ':'); i >= 0 {
+		for _, flag := range strings.Split(st.suffix[i+1:], ",") {
+			switch flag {
+			case "literal":
+				literal = true
+			case "casesensitive":
+				caseSensitive = true
+			}
+		}
+	}
+
+	words := strings.Fields(st.operand)
+	if literal {
+		words = []string{st.operand}
+	}
+	if !caseSensitive {
+		for i, word := range words {
+			words[i] = strings.ToLower(word)
+		}
+	}
+	if len(words) == 0 || words[0] == "" {
+		return input, nil
+	}
+
+	return selectTitles(input, st, func(title string) bool {
+		if !set.exists(title) {
+			return false
+		}
+		var values []string
+		if len(fields) == 1 && fields[0] == "*" {
+			for name := range set.tiddlers[title] {
+				values = append(values, set.field(title, name))
+			}
+		} else {
+			for _, name := range fields {
+				values = append(values, set.field(title, name))
+			}
+		}
+		for _, word := range words {
+			found := false
+			for _, value := range values {
+				if !caseSensitive {
+					value = strings.ToLower(value)
+				}
+				if strings.Contains(value, word) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}), nil
+}
+
+// sortOp sorts by a field, the title unless the operand names another,
+// ignoring case. !sort sorts the other way.
+func sortOp(set *Set, input []string, st step) ([]string, error) {
+	name := st.operand
+	if name == "" {
+		name = "title"
+	}
+	out := append([]string{}, input...)
+	sort.SliceStable(out, func(i, j int) bool {
+		a := strings.ToLower(set.field(out[i], name))
+		b := strings.ToLower(set.field(out[j], name))
+		if st.negate {
+			return a > b
+		}
+		return a < b
+	})
+	return out, nil
+}
+
+func suffixOp(set *Set, input []string, st step) ([]string, error) {
+	return selectTitles(input, st, func(title string) bool {
+		return strings.HasSuffix(title, st.operand)
+	}), nil
+}
+
+func tagOp(set *Set, input []string, st step) ([]string, error) {
+	return selectTitles(input, st, func(title string) bool {
+		for _, tag := range tiddlywiki.ParseStringList(set.field(title, "tags")) {
+			if tag == st.operand {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// titleOp selects the title given as the operand, whether or not there is such
+// a tiddler, or with !title, all but that title.
+func titleOp(set *Set, input []string, st step) ([]string, error) {
+	if st.negate {
+		return selectTitles(input, step{}, func(title string) bool {
+			return title != st.operand
+		}), nil
+	}
+	return []string{st.operand}, nil
+}
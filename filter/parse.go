@@ -0,0 +1,148 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrSyntax is returned, wrapped, for a filter that can't be parsed.
+	ErrSyntax = errors.New("filter: syntax error")
+	// ErrOperand is returned, wrapped, when a filter is run with an operand
+	// that its operator can't use.
+	ErrOperand = errors.New("filter: bad operand")
+)
+
+// namedPrefixes are the long forms of the run prefixes.
+var namedPrefixes = map[string]byte{
+	":or":     0,
+	":all":    '=',
+	":except": '-',
+	":and":    '+',
+	":else":   '~',
+}
+
+// Parse parses a filter expression in the same way as TiddlyWiki.
+func Parse(s string) (*Filter, error) {
+	f := &Filter{}
+	for i := 0; ; {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i == len(s) {
+			return f, nil
+		}
+
+		var r run
+		switch c := s[i]; {
+		case c == '+' || c == '-' || c == '~' || c == '=':
+			r.prefix = c
+			i++
+		case c == ':':
+			j := i
+			for j < len(s) && s[j] != '[' && !isSpace(s[j]) {
+				j++
+			}
+			prefix, ok := namedPrefixes[s[i:j]]
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown run prefix %q", ErrSyntax, s[i:j])
+			}
+			r.prefix = prefix
+			i = j
+		}
+		if i == len(s) {
+			return nil, fmt.Errorf("%w: missing run after prefix", ErrSyntax)
+		}
+
+		switch {
+		case strings.HasPrefix(s[i:], "[["):
+			end := strings.Index(s[i+2:], "]]")
+			if end < 0 {
+				return nil, fmt.Errorf("%w: missing ]] in filter", ErrSyntax)
+			}
+			r.steps = []step{{op: "title", operand: s[i+2 : i+2+end]}}
+			i += end + 4
+		case s[i] == '[':
+			steps, n, err := parseSteps(s[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			r.steps = steps
+			i += n + 1
+		case s[i] == '"' || s[i] == '\'':
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end < 0 {
+				return nil, fmt.Errorf("%w: missing closing quote in filter", ErrSyntax)
+			}
+			r.steps = []step{{op: "title", operand: s[i+1 : i+1+end]}}
+			i += end + 2
+		default:
+			j := i
+			for j < len(s) && !isSpace(s[j]) && s[j] != '[' && s[j] != ']' {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("%w: unexpected %q", ErrSyntax, s[i])
+			}
+			r.steps = []step{{op: "title", operand: s[i:j]}}
+			i = j
+		}
+		f.runs = append(f.runs, r)
+	}
+}
+
+// parseSteps parses the steps of a run, which s starts just inside of, and
+// returns them with the length of s that they took up, the closing bracket
+// included.
+func parseSteps(s string) ([]step, int, error) {
+	var steps []step
+	for i := 0; ; {
+		var st step
+		if i < len(s) && s[i] == '!' {
+			st.negate = true
+			i++
+		}
+
+		end := strings.IndexAny(s[i:], "[{</")
+		if end < 0 {
+			return nil, 0, fmt.Errorf("%w: missing [ in filter", ErrSyntax)
+		}
+		name := s[i : i+end]
+		if j := strings.IndexByte(name, ':'); j >= 0 {
+			name, st.suffix = name[:j], name[j+1:]
+		}
+		if name == "" {
+			name = "title"
+		}
+		st.op = name
+		i += end
+
+		switch s[i] {
+		case '{':
+			return nil, 0, fmt.Errorf("%w: text references aren't supported", ErrSyntax)
+		case '<':
+			return nil, 0, fmt.Errorf("%w: variables aren't supported", ErrSyntax)
+		case '/':
+			return nil, 0, fmt.Errorf("%w: regular expressions aren't supported", ErrSyntax)
+		}
+		n := strings.IndexByte(s[i+1:], ']')
+		if n < 0 {
+			return nil, 0, fmt.Errorf("%w: missing ] in filter", ErrSyntax)
+		}
+		st.operand = s[i+1 : i+1+n]
+		i += n + 2
+		steps = append(steps, st)
+
+		if i == len(s) {
+			return nil, 0, fmt.Errorf("%w: missing ] at the end of a run", ErrSyntax)
+		}
+		if s[i] == ']' {
+			return steps, i + 1, nil
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
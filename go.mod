@@ -1,11 +1,12 @@
 module github.com/etitcombe/tiddlypom
 
-go 1.16
+go 1.20
 
 // replace github.com/etitcombe/logifymw => /home/etitcombe/dev/logifymw
 
 require (
 	github.com/etitcombe/logifymw v0.6.2
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.6
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 )
@@ -0,0 +1,151 @@
+package merge
+
+import "strings"
+
+// splitLines splits text into lines, each keeping its line ending so that the
+// lines can be joined back into exactly the same text.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// matches finds the longest common subsequence of a and b using the linear
+// space variant of Myers' diff algorithm. For each line of a it returns the
+// index of the line of b that it is matched with, or -1 when the line was
+// removed.
+func matches(a, b []string) []int {
+	d := differ{a: a, b: b, match: make([]int, len(a))}
+	for i := range d.match {
+		d.match[i] = -1
+	}
+	d.compare(0, len(a), 0, len(b))
+	return d.match
+}
+
+// differ holds the lines being compared and the matches found so far.
+type differ struct {
+	a, b  []string
+	match []int
+}
+
+// compare matches the lines of a[aLo:aHi] with those of b[bLo:bHi]. It splits
+// them where a shortest edit script crosses the middle, so that only two
+// arrays the size of the ranges are needed at a time, rather than one for
+// every step of the script.
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	// Lines at the start and end that haven't changed are matched straight
+	// away, which leaves only the part that was edited.
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.match[aLo] = bLo
+		aLo++
+		bLo++
+	}
+	for aHi > aLo && bHi > bLo && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+		d.match[aHi] = bHi
+	}
+	if aLo == aHi || bLo == bHi {
+		return
+	}
+
+	x, y, ok := d.middle(aLo, aHi, bLo, bHi)
+	if !ok || (x == aLo && y == bLo) || (x == aHi && y == bHi) {
+		// Nothing in common, or nowhere to split that makes progress.
+		return
+	}
+	d.compare(aLo, x, bLo, y)
+	d.compare(x, aHi, y, bHi)
+}
+
+// middle finds a point that a shortest edit script from a[aLo:aHi] to
+// b[bLo:bHi] goes through, by searching forwards from the start and backwards
+// from the end at the same time until the two searches meet. It reports false
+// when they don't, which means that the ranges have no lines in common.
+func (d *differ) middle(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	n, m := aHi-aLo, bHi-bLo
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	// forward holds, for each diagonal k = x - y, the furthest x reached from
+	// the start, and backward the furthest reached from the end, counted
+	// from the end. They are offset so that negative diagonals can be indexed.
+	forward := make([]int, 2*maxD+2)
+	backward := make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+
+	delta := n - m
+	// When delta is odd the searches meet while going forwards, and when it
+	// is even while going backwards.
+	odd := delta%2 != 0
+	// The diagonals that have run off the edges are skipped.
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+
+	for step := 0; step < maxD; step++ {
+		for k := -step + fStart; k <= step-fEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -step || (k != step && forward[i-1] < forward[i+1]) {
+				x = forward[i+1]
+			} else {
+				x = forward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[i] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case odd:
+				j := offset + delta - k
+				if j >= 0 && j < len(backward) && backward[j] != -1 && x >= n-backward[j] {
+					return aLo + x, bLo + y, true
+				}
+			}
+		}
+
+		for k := -step + bStart; k <= step-bEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -step || (k != step && backward[i-1] < backward[i+1]) {
+				x = backward[i+1]
+			} else {
+				x = backward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aHi-x-1] == d.b[bHi-y-1] {
+				x++
+				y++
+			}
+			backward[i] = x
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !odd:
+				j := offset + delta - k
+				if j >= 0 && j < len(forward) && forward[j] != -1 {
+					fx := forward[j]
+					fy := fx - (j - offset)
+					if fx >= n-x {
+						return aLo + fx, bLo + fy, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,116 @@
+package merge
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// lcsLength is the length of the longest common subsequence of a and b, worked
+// out the slow way to check matches against.
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// checkMatches fails t unless match pairs equal lines of a and b in order, and
+// as many of them as there can be.
+func checkMatches(t *testing.T, a, b []string, match []int) {
+	t.Helper()
+	if len(match) != len(a) {
+		t.Fatalf("got %d matches for %d lines", len(match), len(a))
+	}
+	last, n := -1, 0
+	for i, j := range match {
+		if j < 0 {
+			continue
+		}
+		if j <= last || j >= len(b) || a[i] != b[j] {
+			t.Fatalf("a[%d] is matched with b[%d], after b[%d]: %q %q", i, j, last, a, b)
+		}
+		last = j
+		n++
+	}
+	if want := lcsLength(a, b); n != want {
+		t.Fatalf("matched %d lines of %q and %q, want %d", n, a, b, want)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"", ""},
+		{"a", ""},
+		{"", "a"},
+		{"a", "a"},
+		{"a", "b"},
+		{"abc", "abc"},
+		{"abc", "axc"},
+		{"abcabba", "cbabac"},
+		{"xaby", "ab"},
+		{"ab", "xaby"},
+		{"abcdef", "fedcba"},
+		{"aaaa", "aa"},
+	}
+	for _, tt := range tests {
+		a, b := strings.Split(tt.a, ""), strings.Split(tt.b, "")
+		checkMatches(t, a, b, matches(a, b))
+	}
+}
+
+func TestMatchesRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		out := make([]string, r.Intn(30))
+		for i := range out {
+			out[i] = string(rune('a' + r.Intn(4)))
+		}
+		return out
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := lines(), lines()
+		checkMatches(t, a, b, matches(a, b))
+	}
+}
+
+// TestMatchesMemory makes sure that comparing two large texts with nothing in
+// common takes space in proportion to their size, not to its square.
+func TestMatchesMemory(t *testing.T) {
+	const n = 4000
+	a, b := make([]string, n), make([]string, n)
+	for i := range a {
+		a[i] = fmt.Sprintf("a%d\n", i)
+		b[i] = fmt.Sprintf("b%d\n", i)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	match := matches(a, b)
+	runtime.ReadMemStats(&after)
+
+	for i, j := range match {
+		if j >= 0 {
+			t.Fatalf("a[%d] is matched with b[%d]", i, j)
+		}
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Errorf("allocated %d MiB", alloc>>20)
+	}
+}
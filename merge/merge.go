@@ -0,0 +1,118 @@
+// Package merge combines two edits of the same tiddler that were both based on
+// a common earlier revision.
+package merge
+
+import "strings"
+
+// Text does a three-way merge of the lines of mine and theirs, which were both
+// edited from base. A change made on only one side is taken from that side.
+// When both sides changed the same lines differently the merge is not clean,
+// and both versions of those lines are included between conflict markers
+// labelled with mineLabel and theirsLabel.
+func Text(base, mine, theirs, mineLabel, theirsLabel string) (string, bool) {
+	baseLines := splitLines(base)
+	mineLines := splitLines(mine)
+	theirsLines := splitLines(theirs)
+	matchMine := matches(baseLines, mineLines)
+	matchTheirs := matches(baseLines, theirsLines)
+
+	var sb strings.Builder
+	clean := true
+	i, a, b := 0, 0, 0
+
+	for i < len(baseLines) || a < len(mineLines) || b < len(theirsLines) {
+		// Copy the lines that are the same on all three sides.
+		if i < len(baseLines) && matchMine[i] == a && matchTheirs[i] == b {
+			sb.WriteString(baseLines[i])
+			i, a, b = i+1, a+1, b+1
+			continue
+		}
+
+		// Otherwise find the next base line that both sides kept. Everything
+		// up to it has been changed on one side or both.
+		next := i
+		for next < len(baseLines) && (matchMine[next] < 0 || matchTheirs[next] < 0) {
+			next++
+		}
+		nextA, nextB := len(mineLines), len(theirsLines)
+		if next < len(baseLines) {
+			nextA, nextB = matchMine[next], matchTheirs[next]
+		}
+
+		baseChunk := baseLines[i:next]
+		mineChunk := mineLines[a:nextA]
+		theirsChunk := theirsLines[b:nextB]
+
+		switch {
+		case equal(mineChunk, baseChunk):
+			writeLines(&sb, theirsChunk)
+		case equal(theirsChunk, baseChunk), equal(mineChunk, theirsChunk):
+			writeLines(&sb, mineChunk)
+		default:
+			clean = false
+			sb.WriteString("<<<<<<< " + mineLabel + "\n")
+			writeConflictLines(&sb, mineChunk)
+			sb.WriteString("=======\n")
+			writeConflictLines(&sb, theirsChunk)
+			sb.WriteString(">>>>>>> " + theirsLabel + "\n")
+		}
+		i, a, b = next, nextA, nextB
+	}
+
+	return sb.String(), clean
+}
+
+// Fields does a three-way merge of the fields of a tiddler. A field changed,
+// added or removed on only one side is taken from that side. When both sides
+// changed a field differently the value from mine is kept.
+func Fields(base, mine, theirs map[string]string) map[string]string {
+	merged := map[string]string{}
+	keys := map[string]bool{}
+	for _, fields := range []map[string]string{base, mine, theirs} {
+		for k := range fields {
+			keys[k] = true
+		}
+	}
+
+	for k := range keys {
+		baseValue, inBase := base[k]
+		mineValue, inMine := mine[k]
+		theirsValue, inTheirs := theirs[k]
+
+		if inMine == inBase && mineValue == baseValue {
+			if inTheirs {
+				merged[k] = theirsValue
+			}
+		} else if inMine {
+			merged[k] = mineValue
+		}
+	}
+	return merged
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(sb *strings.Builder, lines []string) {
+	for _, line := range lines {
+		sb.WriteString(line)
+	}
+}
+
+// writeConflictLines writes one side of a conflict, making sure that the
+// marker which follows it starts on a line of its own.
+func writeConflictLines(sb *strings.Builder, lines []string) {
+	writeLines(sb, lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		sb.WriteString("\n")
+	}
+}
@@ -0,0 +1,121 @@
+package merge
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, mine, theirs string
+		want               string
+		clean              bool
+	}{
+		{
+			name: "unchanged",
+			base: "a\nb\nc\n", mine: "a\nb\nc\n", theirs: "a\nb\nc\n",
+			want: "a\nb\nc\n", clean: true,
+		},
+		{
+			name: "only mine",
+			base: "a\nb\nc\n", mine: "a\nB\nc\n", theirs: "a\nb\nc\n",
+			want: "a\nB\nc\n", clean: true,
+		},
+		{
+			name: "only theirs",
+			base: "a\nb\nc\n", mine: "a\nb\nc\n", theirs: "a\nb\nC\n",
+			want: "a\nb\nC\n", clean: true,
+		},
+		{
+			name: "different lines",
+			base: "a\nb\nc\nd\n", mine: "A\nb\nc\nd\n", theirs: "a\nb\nc\nD\n",
+			want: "A\nb\nc\nD\n", clean: true,
+		},
+		{
+			name: "same change",
+			base: "a\nb\n", mine: "a\nx\n", theirs: "a\nx\n",
+			want: "a\nx\n", clean: true,
+		},
+		{
+			name: "added at both ends",
+			base: "b\n", mine: "a\nb\n", theirs: "b\nc\n",
+			want: "a\nb\nc\n", clean: true,
+		},
+		{
+			name: "deleted and changed elsewhere",
+			base: "a\nb\nc\nd\n", mine: "a\nc\nd\n", theirs: "a\nb\nc\nD\n",
+			want: "a\nc\nD\n", clean: true,
+		},
+		{
+			name: "conflict",
+			base: "a\nb\nc\n", mine: "a\nmine\nc\n", theirs: "a\ntheirs\nc\n",
+			want:  "a\n<<<<<<< mine\nmine\n=======\ntheirs\n>>>>>>> theirs\nc\n",
+			clean: false,
+		},
+		{
+			name: "conflict without a final newline",
+			base: "a", mine: "b", theirs: "c",
+			want:  "<<<<<<< mine\nb\n=======\nc\n>>>>>>> theirs\n",
+			clean: false,
+		},
+		{
+			name: "deleted on one side, changed on the other",
+			base: "a\nb\nc\n", mine: "a\nc\n", theirs: "a\nB\nc\n",
+			want:  "a\n<<<<<<< mine\n=======\nB\n>>>>>>> theirs\nc\n",
+			clean: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, clean := Text(tt.base, tt.mine, tt.theirs, "mine", "theirs")
+			if got != tt.want || clean != tt.clean {
+				t.Errorf("got %q, %v, want %q, %v", got, clean, tt.want, tt.clean)
+			}
+		})
+	}
+}
+
+func TestFields(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, mine, theirs map[string]string
+		want               map[string]string
+	}{
+		{
+			name:   "changed on each side",
+			base:   map[string]string{"tags": "a", "color": "red"},
+			mine:   map[string]string{"tags": "a b", "color": "red"},
+			theirs: map[string]string{"tags": "a", "color": "blue"},
+			want:   map[string]string{"tags": "a b", "color": "blue"},
+		},
+		{
+			name:   "added and removed",
+			base:   map[string]string{"gone": "x"},
+			mine:   map[string]string{"new": "y"},
+			theirs: map[string]string{"gone": "x", "other": "z"},
+			want:   map[string]string{"new": "y", "other": "z"},
+		},
+		{
+			name:   "removed by theirs",
+			base:   map[string]string{"gone": "x"},
+			mine:   map[string]string{"gone": "x"},
+			theirs: map[string]string{},
+			want:   map[string]string{},
+		},
+		{
+			name:   "changed on both sides keeps mine",
+			base:   map[string]string{"color": "red"},
+			mine:   map[string]string{"color": "green"},
+			theirs: map[string]string{"color": "blue"},
+			want:   map[string]string{"color": "green"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Fields(tt.base, tt.mine, tt.theirs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
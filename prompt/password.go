@@ -0,0 +1,83 @@
+// Package prompt asks the person running a command for things that shouldn't
+// be given as arguments, where they would end up in the shell's history.
+package prompt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// stdin is shared so that nothing read ahead by one prompt is lost to the next.
+var stdin = bufio.NewReader(os.Stdin)
+
+// Password asks for the password of email on stderr and reads a line of stdin.
+// When stdin is a terminal what's typed isn't shown, and with confirm it is
+// asked for a second time to make sure that it was typed as intended.
+func Password(email string, confirm bool) (string, error) {
+	tty := isTerminal(os.Stdin)
+
+	password, err := readPassword(fmt.Sprintf("Password for %s: ", email), tty)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", errors.New("a password is required")
+	}
+
+	if confirm && tty {
+		again, err := readPassword("Again: ", tty)
+		if err != nil {
+			return "", err
+		}
+		if again != password {
+			return "", errors.New("the passwords don't match")
+		}
+	}
+	return password, nil
+}
+
+// Line asks for something on stderr and reads a line of stdin.
+func Line(label string) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func readPassword(label string, tty bool) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	if tty {
+		// Without stty, on Windows for one, the password is shown as it is
+		// typed, which is still better than having it in the history.
+		if err := stty("-echo"); err == nil {
+			defer func() {
+				stty("echo")
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
@@ -0,0 +1,25 @@
+package tiddlywiki
+
+import (
+	"strings"
+	"time"
+)
+
+// ParseDate parses a date field such as created or modified, which TiddlyWiki
+// writes as YYYYMMDDHHMMSSmmm in UTC. Shorter dates, like the YYYYMMDDHHMM of
+// TiddlyWiki Classic, are read with the missing parts as zero.
+func ParseDate(s string) (time.Time, bool) {
+	if len(s) < 8 || len(s) > 17 {
+		return time.Time{}, false
+	}
+	s += strings.Repeat("0", 17-len(s))
+	t, err := time.Parse("20060102150405", s[:14])
+	if err != nil {
+		return time.Time{}, false
+	}
+	ms, err := time.ParseDuration(s[14:] + "ms")
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.Add(ms), true
+}
@@ -0,0 +1,264 @@
+package tiddlywiki
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+// The formats that tiddlers can be exported in.
+const (
+	// FormatHTML is a standalone single-file wiki.
+	FormatHTML = "html"
+	// FormatJSON is a JSON array that any TiddlyWiki can import.
+	FormatJSON = "json"
+	// FormatTar is a tar archive of a Node.js TiddlyWiki tiddlers folder.
+	FormatTar = "tar"
+	// FormatZip is a zip archive of a Node.js TiddlyWiki tiddlers folder.
+	FormatZip = "zip"
+)
+
+// ErrFormat is returned for an export format that isn't one of the above.
+var ErrFormat = errors.New("tiddlywiki: unknown export format")
+
+// standaloneRemoved are the tiddlers that tie a wiki to the server, which are
+// taken out of a standalone copy so that it saves itself like any other
+// single-file wiki.
+var standaloneRemoved = []string{
+	"$:/config/tiddlyweb/host",
+	"$:/plugins/tiddlywiki/tiddlyweb",
+}
+
+// ExportFilter picks the tiddlers to export. The zero value picks them all.
+type ExportFilter struct {
+	// Tag picks the tiddlers with the tag.
+	Tag string
+	// System is "only" to pick just the system tiddlers, the ones whose titles
+	// start with $:/, or "exclude" to leave them out.
+	System string
+	// Since picks the tiddlers modified, or failing that created, at or after
+	// the time.
+	Since time.Time
+}
+
+// NewExportFilter makes a filter from its settings as text, as they are given
+// in a query string or on the command line. since is a date, YYYY-MM-DD, a
+// time in RFC 3339 format or a TiddlyWiki date.
+func NewExportFilter(tag, system, since string) (ExportFilter, error) {
+	f := ExportFilter{Tag: tag, System: system}
+	if system != "" && system != "only" && system != "exclude" {
+		return ExportFilter{}, fmt.Errorf("system must be only or exclude, not %q", system)
+	}
+	if since == "" {
+		return f, nil
+	}
+	if t, err := time.Parse("2006-01-02", since); err == nil {
+		f.Since = t
+	} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+		f.Since = t
+	} else if t, ok := ParseDate(since); ok {
+		f.Since = t
+	} else {
+		return ExportFilter{}, fmt.Errorf("cannot read the date %q", since)
+	}
+	return f, nil
+}
+
+// Match reports whether the filter picks a tiddler.
+func (f ExportFilter) Match(fields map[string]string) bool {
+	system := strings.HasPrefix(fields["title"], "$:/")
+	if (f.System == "only" && !system) || (f.System == "exclude" && system) {
+		return false
+	}
+	if f.Tag != "" && !hasTag(fields, f.Tag) {
+		return false
+	}
+	if !f.Since.IsZero() {
+		date := fields["modified"]
+		if date == "" {
+			date = fields["created"]
+		}
+		t, ok := ParseDate(date)
+		if !ok || t.Before(f.Since) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasTag(fields map[string]string, tag string) bool {
+	for _, t := range ParseStringList(fields["tags"]) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// exportable reports whether a tiddler belongs in an export. Like TiddlyWiki's
+// own save, it leaves out the temporary ones.
+func exportable(title string) bool {
+	return !strings.HasPrefix(title, "$:/temp/") &&
+		!strings.HasPrefix(title, "$:/state/popup/") &&
+		!strings.HasPrefix(title, "$:/status/") &&
+		title != "$:/HistoryList"
+}
+
+// ExportTiddlers gets the fields of every tiddler in bags, system tiddlers
+// included, that the filter picks. Where more than one bag has a tiddler with
+// the same title, the one from the last bag is used. They are sorted by title.
+func ExportTiddlers(ctx context.Context, ts app.TiddlyStore, bags []string, f ExportFilter) ([]map[string]string, error) {
+	byTitle := map[string]map[string]string{}
+	for _, bag := range bags {
+		all, err := ts.GetAll(ctx, bag)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range all {
+			if !exportable(t.Title) {
+				continue
+			}
+			fields, err := Fields(t)
+			if err != nil {
+				return nil, err
+			}
+			fields["title"] = t.Title
+			byTitle[t.Title] = fields
+		}
+	}
+
+	titles := make([]string, 0, len(byTitle))
+	for title, fields := range byTitle {
+		if f.Match(fields) {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+
+	tiddlers := make([]map[string]string, 0, len(titles))
+	for _, title := range titles {
+		tiddlers = append(tiddlers, byTitle[title])
+	}
+	return tiddlers, nil
+}
+
+// Export writes tiddlers to w in format. A standalone wiki is built from the
+// index.html at index, which the other formats don't need.
+func Export(w io.Writer, format string, tiddlers []map[string]string, index string) error {
+	switch format {
+	case FormatHTML:
+		page, err := ioutil.ReadFile(index)
+		if err != nil {
+			return err
+		}
+		if page, err = ReplaceTiddlers(page, tiddlers, standaloneRemoved...); err != nil {
+			return err
+		}
+		_, err = w.Write(page)
+		return err
+	case FormatJSON:
+		data, err := MarshalJSONTiddlers(tiddlers)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case FormatTar:
+		return writeTar(w, tiddlers)
+	case FormatZip:
+		return writeZip(w, tiddlers)
+	default:
+		return ErrFormat
+	}
+}
+
+// tiddlerFile is a file in an exported tiddlers folder.
+type tiddlerFile struct {
+	name     string
+	data     []byte
+	modified time.Time
+}
+
+// eachTiddlerFile lays tiddlers out as the files of a Node.js TiddlyWiki
+// tiddlers folder, giving each file a name of its own, and calls fn with each
+// file in turn.
+func eachTiddlerFile(tiddlers []map[string]string, fn func(tiddlerFile) error) error {
+	used := map[string]bool{}
+	for _, fields := range tiddlers {
+		ext, data, meta, err := MarshalFile(fields)
+		if err != nil {
+			return err
+		}
+
+		base := "tiddlers/" + Filename(strings.TrimSuffix(fields["title"], ext))
+		name := base + ext
+		for n := 1; used[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s_%d%s", base, n, ext)
+		}
+		used[strings.ToLower(name)] = true
+
+		modified, ok := ParseDate(fields["modified"])
+		if !ok {
+			modified = time.Now()
+		}
+		if err := fn(tiddlerFile{name: name, data: data, modified: modified}); err != nil {
+			return err
+		}
+		if meta != nil {
+			if err := fn(tiddlerFile{name: name + ".meta", data: meta, modified: modified}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeTar(w io.Writer, tiddlers []map[string]string) error {
+	tw := tar.NewWriter(w)
+	err := eachTiddlerFile(tiddlers, func(f tiddlerFile) error {
+		hdr := &tar.Header{
+			Name:    f.name,
+			Mode:    0644,
+			Size:    int64(len(f.data)),
+			ModTime: f.modified,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(f.data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeZip(w io.Writer, tiddlers []map[string]string) error {
+	zw := zip.NewWriter(w)
+	err := eachTiddlerFile(tiddlers, func(f tiddlerFile) error {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: f.modified,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(f.data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
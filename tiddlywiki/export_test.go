@@ -0,0 +1,193 @@
+package tiddlywiki
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewExportFilter(t *testing.T) {
+	tests := []struct {
+		system string
+		since  string
+		want   time.Time
+		ok     bool
+	}{
+		{"", "", time.Time{}, true},
+		{"only", "2026-01-10", time.Date(2026, time.January, 10, 0, 0, 0, 0, time.UTC), true},
+		{"exclude", "2026-01-10T12:30:00Z", time.Date(2026, time.January, 10, 12, 30, 0, 0, time.UTC), true},
+		{"", "20260110123000500", time.Date(2026, time.January, 10, 12, 30, 0, 500e6, time.UTC), true},
+		{"", "20260110", time.Date(2026, time.January, 10, 0, 0, 0, 0, time.UTC), true},
+		{"some", "", time.Time{}, false},
+		{"", "yesterday", time.Time{}, false},
+		{"", "2026-13-01", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		f, err := NewExportFilter("tag", tt.system, tt.since)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewExportFilter(%q, %q) error = %v, want ok %v", tt.system, tt.since, err, tt.ok)
+			continue
+		}
+		if tt.ok && (!f.Since.Equal(tt.want) || f.Tag != "tag" || f.System != tt.system) {
+			t.Errorf("NewExportFilter(%q, %q) = %+v", tt.system, tt.since, f)
+		}
+	}
+}
+
+func TestExportFilterMatch(t *testing.T) {
+	since := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	notes := map[string]string{"title": "Notes", "tags": "one [[two words]]", "modified": "20260110120000000"}
+	old := map[string]string{"title": "Old", "modified": "20251231235959999", "created": "20260105000000000"}
+	created := map[string]string{"title": "Created", "created": "20260105000000000"}
+	undated := map[string]string{"title": "Undated"}
+	system := map[string]string{"title": "$:/config/X"}
+
+	tests := []struct {
+		name   string
+		filter ExportFilter
+		fields map[string]string
+		want   bool
+	}{
+		{"zero value", ExportFilter{}, undated, true},
+		{"tag", ExportFilter{Tag: "two words"}, notes, true},
+		{"another tag", ExportFilter{Tag: "three"}, notes, false},
+		{"system only", ExportFilter{System: "only"}, system, true},
+		{"system only, not system", ExportFilter{System: "only"}, notes, false},
+		{"system excluded", ExportFilter{System: "exclude"}, system, false},
+		{"system excluded, not system", ExportFilter{System: "exclude"}, notes, true},
+		{"modified since", ExportFilter{Since: since}, notes, true},
+		{"modified before", ExportFilter{Since: since}, old, false},
+		{"created since", ExportFilter{Since: since}, created, true},
+		{"no date", ExportFilter{Since: since}, undated, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(tt.fields); got != tt.want {
+			t.Errorf("%s: Match = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// exportTiddlers has titles that make the same file name, one of them in
+// another case, and an image that needs a .meta file.
+var exportTiddlers = []map[string]string{
+	{"title": "a/b", "text": "one", "modified": "20260110120000000"},
+	{"title": "a?b", "text": "two"},
+	{"title": "A_B", "text": "three"},
+	{"title": "Picture.png", "type": "image/png", "text": "aGk="},
+}
+
+var exportNames = []string{
+	"tiddlers/a_b.tid",
+	"tiddlers/a_b_1.tid",
+	"tiddlers/A_B_2.tid",
+	"tiddlers/Picture.png",
+	"tiddlers/Picture.png.meta",
+}
+
+func TestExportJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, FormatJSON, exportTiddlers, ""); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseJSONTiddlers(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, exportTiddlers) {
+		t.Errorf("exported %q, want %q", got, exportTiddlers)
+	}
+}
+
+func TestExportZip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, FormatZip, exportTiddlers, ""); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if !reflect.DeepEqual(names, exportNames) {
+		t.Errorf("zip has %q, want %q", names, exportNames)
+	}
+	rc, err := zr.File[3].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if data, _ := ioutil.ReadAll(rc); string(data) != "hi" {
+		t.Errorf("%s is %q, want the decoded image", zr.File[3].Name, data)
+	}
+}
+
+func TestExportTar(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, FormatTar, exportTiddlers, ""); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(&buf)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == "tiddlers/a_b.tid" {
+			want := time.Date(2026, time.January, 10, 12, 0, 0, 0, time.UTC)
+			if !hdr.ModTime.Equal(want) {
+				t.Errorf("%s modified %v, want %v", hdr.Name, hdr.ModTime, want)
+			}
+			data, _ := ioutil.ReadAll(tr)
+			if want := "modified: 20260110120000000\ntitle: a/b\n\none"; string(data) != want {
+				t.Errorf("%s is %q, want %q", hdr.Name, data, want)
+			}
+		}
+	}
+	if !reflect.DeepEqual(names, exportNames) {
+		t.Errorf("tar has %q, want %q", names, exportNames)
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	index := filepath.Join(t.TempDir(), "index.html")
+	if err := ioutil.WriteFile(index, []byte(testPage), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Export(&buf, FormatHTML, exportTiddlers[:1], index); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Tiddlers(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The page's own tiddlers are kept, apart from the tiddlyweb host, which
+	// is taken out so that the copy saves itself.
+	want := []map[string]string{
+		{"created": "20210131120000000", "tags": "one [[two words]]", "title": "Notes", "text": `a <b> & "c"`},
+		exportTiddlers[0],
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("standalone wiki has %q, want %q", got, want)
+	}
+}
+
+func TestExportFormat(t *testing.T) {
+	if err := Export(ioutil.Discard, "rar", exportTiddlers, ""); err != ErrFormat {
+		t.Errorf("Export as rar error = %v, want %v", err, ErrFormat)
+	}
+}
@@ -0,0 +1,55 @@
+package tiddlywiki
+
+import (
+	"context"
+	"strings"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+// Importable reports whether a tiddler read from a single-file wiki should be
+// kept when the wiki moves to the server. The boot code, the core and the
+// plugins that the server's own index.html brings are left behind, as is the
+// state of the page the wiki was last saved from.
+func Importable(title string) bool {
+	switch title {
+	case "$:/core",
+		"$:/config/tiddlyweb/host",
+		"$:/HistoryList",
+		"$:/Import",
+		"$:/isEncrypted",
+		"$:/plugins/tiddlywiki/tiddlyweb",
+		"$:/StoryList",
+		"$:/themes/tiddlywiki/snowwhite",
+		"$:/themes/tiddlywiki/vanilla":
+		return false
+	}
+	return title != "" &&
+		!strings.HasPrefix(title, "$:/boot/") &&
+		!strings.HasPrefix(title, "$:/library/") &&
+		!strings.HasPrefix(title, "$:/state/") &&
+		!strings.HasPrefix(title, "$:/status/") &&
+		!strings.HasPrefix(title, "$:/temp/")
+}
+
+// Import saves the importable tiddlers, given as their fields, in bag. Each
+// one is saved as the next revision of any tiddler already there with the same
+// title. It returns the tiddlers that were saved.
+func Import(ctx context.Context, ts app.TiddlyStore, bag string, tiddlers []map[string]string) ([]app.Tiddler, error) {
+	var saved []app.Tiddler
+	for _, fields := range tiddlers {
+		title := fields["title"]
+		if !Importable(title) {
+			continue
+		}
+		t, err := NewTiddler(fields, bag, 0)
+		if err != nil {
+			return saved, err
+		}
+		if t, err = ts.Upsert(ctx, bag, title, t, 0); err != nil {
+			return saved, err
+		}
+		saved = append(saved, t)
+	}
+	return saved, nil
+}
@@ -0,0 +1,35 @@
+package tiddlywiki
+
+import "encoding/json"
+
+// ParseJSONTiddlers parses tiddlers in TiddlyWiki's JSON format: an array of
+// objects, or a single object, whose properties are the tiddler fields.
+func ParseJSONTiddlers(data []byte) ([]map[string]string, error) {
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		var object map[string]interface{}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, err
+		}
+		objects = []map[string]interface{}{object}
+	}
+
+	list := make([]map[string]string, 0, len(objects))
+	for _, object := range objects {
+		fields := make(map[string]string, len(object))
+		for name, value := range object {
+			fields[name] = fieldString(value)
+		}
+		list = append(list, fields)
+	}
+	return list, nil
+}
+
+// MarshalJSONTiddlers writes tiddlers in TiddlyWiki's JSON format, which can
+// be dragged into, or imported by, any TiddlyWiki.
+func MarshalJSONTiddlers(list []map[string]string) ([]byte, error) {
+	if list == nil {
+		list = []map[string]string{}
+	}
+	return json.MarshalIndent(list, "", "    ")
+}
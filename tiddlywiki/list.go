@@ -0,0 +1,58 @@
+package tiddlywiki
+
+import "strings"
+
+// ParseStringList splits a TiddlyWiki string list, such as the tags field, into
+// its items. Items containing spaces are wrapped in double square brackets:
+//
+//	one [[two words]] three
+//
+// Duplicate items are dropped, as TiddlyWiki does.
+func ParseStringList(s string) []string {
+	var items []string
+	seen := map[string]bool{}
+	add := func(item string) {
+		if !seen[item] {
+			seen[item] = true
+			items = append(items, item)
+		}
+	}
+
+	for i := 0; i < len(s); {
+		if isSpace(s[i]) {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], "[[") {
+			if end := strings.Index(s[i+2:], "]]"); end >= 0 {
+				add(s[i+2 : i+2+end])
+				i += end + 4
+				continue
+			}
+		}
+		j := i
+		for j < len(s) && !isSpace(s[j]) {
+			j++
+		}
+		add(s[i:j])
+		i = j
+	}
+	return items
+}
+
+// StringifyList is the inverse of ParseStringList.
+func StringifyList(items []string) string {
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if item == "" || strings.ContainsAny(item, " \t\r\n") {
+			out = append(out, "[["+item+"]]")
+		} else {
+			out = append(out, item)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,255 @@
+package tiddlywiki
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A single-file TiddlyWiki keeps its tiddlers in the store area of the HTML
+// page, each one as a div.
+var (
+	// ErrNoStoreArea is returned for an HTML page that isn't a TiddlyWiki.
+	ErrNoStoreArea = errors.New("tiddlywiki: no store area")
+	// ErrBadStoreArea is returned when the store area can't be read.
+	ErrBadStoreArea = errors.New("tiddlywiki: malformed store area")
+
+	// storeAreaStart is the opening tag of the store area as TiddlyWiki 5
+	// writes it.
+	storeAreaStart = []byte(`<div id="storeArea" style="display:none;">`)
+	divStart       = []byte("<div ")
+	divEnd         = []byte("</div>")
+	titleAttr      = regexp.MustCompile(`\stitle="([^"]*)"`)
+
+	// These match the store areas, and the parts of a tiddler div, in the
+	// same way as TiddlyWiki does when it imports a single-file wiki.
+	divAreaMarker  = regexp.MustCompile(`<div id=["']?(?:storeArea|systemArea)['"]?( style=["']?display:none;["']?)?>`)
+	jsonAreaMarker = regexp.MustCompile(`<script class="tiddlywiki-tiddler(?:-store|s)"[^>]*>`)
+	scriptEnd      = []byte("</script>")
+	divOpen        = regexp.MustCompile(`(?i)^\s*<div\s+([^>]*)>(\s*<pre>)?`)
+	divClose       = regexp.MustCompile(`(?i)</pre>\s*</div>\s*$`)
+	divCloseNoPre  = regexp.MustCompile(`(?i)</div>\s*$`)
+	divAttr        = regexp.MustCompile(`\s*([^=\s]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// htmlEncoder escapes only what TiddlyWiki's htmlDecode reverses, which
+// doesn't include the numeric entity for an apostrophe.
+var htmlEncoder = strings.NewReplacer(`&`, `&amp;`, `<`, `&lt;`, `>`, `&gt;`, `"`, `&quot;`)
+
+// TiddlerDiv writes a tiddler in the form used in the store area. The text
+// goes in a pre element and every other field becomes an attribute.
+func TiddlerDiv(fields map[string]string) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if name != "text" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("<div")
+	for _, name := range names {
+		sb.WriteString(" " + name + `="` + htmlEncoder.Replace(fields[name]) + `"`)
+	}
+	sb.WriteString(">\n<pre>" + htmlEncoder.Replace(fields["text"]) + "</pre>\n</div>\n")
+	return sb.String()
+}
+
+// AddTiddlers returns a copy of page with the tiddler divs added at the start
+// of its store area.
+func AddTiddlers(page []byte, divs ...string) ([]byte, error) {
+	i := bytes.Index(page, storeAreaStart)
+	if i < 0 {
+		return nil, ErrNoStoreArea
+	}
+	i += len(storeAreaStart)
+
+	var buf bytes.Buffer
+	buf.Grow(len(page))
+	buf.Write(page[:i])
+	buf.WriteString("\n")
+	for _, div := range divs {
+		buf.WriteString(div)
+	}
+	buf.Write(page[i:])
+	return buf.Bytes(), nil
+}
+
+// ReplaceTiddlers returns a copy of page with tiddlers, given as their fields,
+// added to its store area. They take the place of any tiddlers already there
+// with the same titles. The tiddlers titled in remove are left out altogether.
+func ReplaceTiddlers(page []byte, tiddlers []map[string]string, remove ...string) ([]byte, error) {
+	divs, start, end, err := storeArea(page)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := map[string]bool{}
+	for _, title := range remove {
+		removed[title] = true
+	}
+	replaced := map[string]bool{}
+	for _, fields := range tiddlers {
+		replaced[fields["title"]] = true
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(page))
+	buf.Write(page[:start])
+	buf.WriteString("\n")
+	for _, d := range divs {
+		if !removed[d.title] && !replaced[d.title] {
+			buf.Write(page[d.start:d.end])
+			buf.WriteString("\n")
+		}
+	}
+	for _, fields := range tiddlers {
+		if !removed[fields["title"]] {
+			buf.WriteString(TiddlerDiv(fields))
+		}
+	}
+	buf.Write(page[end:])
+	return buf.Bytes(), nil
+}
+
+// Tiddlers reads the tiddlers of a single-file TiddlyWiki, given as their
+// fields. Both the JSON store areas written by TiddlyWiki 5.2 and later and the
+// div store area written before that, and by TiddlyWiki Classic, are read. A
+// tiddler that appears more than once is given as it appears last.
+func Tiddlers(page []byte) ([]map[string]string, error) {
+	var list []map[string]string
+	found := false
+
+	for _, m := range divAreaMarker.FindAllSubmatchIndex(page, -1) {
+		found = true
+		divs, _, err := scanDivs(page, m[1])
+		if err != nil {
+			return nil, err
+		}
+		// Only TiddlyWiki 5 hides the store area with a style.
+		defaultType := ""
+		if m[2] < 0 {
+			defaultType = "text/x-tiddlywiki"
+		}
+		for _, d := range divs {
+			if fields := parseDiv(page[d.start:d.end], defaultType); fields != nil {
+				list = append(list, fields)
+			}
+		}
+	}
+
+	for _, m := range jsonAreaMarker.FindAllIndex(page, -1) {
+		found = true
+		n := bytes.Index(page[m[1]:], scriptEnd)
+		if n < 0 {
+			return nil, ErrBadStoreArea
+		}
+		tiddlers, err := ParseJSONTiddlers(page[m[1] : m[1]+n])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadStoreArea, err)
+		}
+		list = append(list, tiddlers...)
+	}
+
+	if !found {
+		return nil, ErrNoStoreArea
+	}
+
+	// Keep the last of each title, in the order they first appeared.
+	index := map[string]int{}
+	tiddlers := make([]map[string]string, 0, len(list))
+	for _, fields := range list {
+		if i, ok := index[fields["title"]]; ok {
+			tiddlers[i] = fields
+			continue
+		}
+		index[fields["title"]] = len(tiddlers)
+		tiddlers = append(tiddlers, fields)
+	}
+	return tiddlers, nil
+}
+
+// parseDiv reads the fields of a tiddler from its div, in the same way as
+// TiddlyWiki's deserializer. Divs written before the text went in a pre element
+// are read too.
+func parseDiv(div []byte, defaultType string) map[string]string {
+	m := divOpen.FindSubmatchIndex(div)
+	if m == nil {
+		return nil
+	}
+	closing := divClose
+	if m[4] < 0 {
+		closing = divCloseNoPre
+	}
+	end := closing.FindIndex(div)
+	if end == nil || end[0] < m[1] {
+		return nil
+	}
+
+	fields := map[string]string{}
+	if defaultType != "" {
+		fields["type"] = defaultType
+	}
+	for _, a := range divAttr.FindAllSubmatch(div[m[2]:m[3]], -1) {
+		value := a[2]
+		if value == nil {
+			value = a[3]
+		}
+		fields[string(a[1])] = html.UnescapeString(string(value))
+	}
+	fields["text"] = html.UnescapeString(string(div[m[1]:end[0]]))
+	if fields["title"] == "" {
+		return nil
+	}
+	return fields
+}
+
+// storeDiv is where the div of a tiddler is in a page.
+type storeDiv struct {
+	title      string
+	start, end int
+}
+
+// storeArea finds the divs of the tiddlers in the store area of page, along
+// with where the store area's content starts and ends.
+func storeArea(page []byte) (divs []storeDiv, start, end int, err error) {
+	i := bytes.Index(page, storeAreaStart)
+	if i < 0 {
+		return nil, 0, 0, ErrNoStoreArea
+	}
+	start = i + len(storeAreaStart)
+	divs, end, err = scanDivs(page, start)
+	return divs, start, end, err
+}
+
+// scanDivs finds the tiddler divs in a store area whose content starts at
+// start, and where its closing tag is.
+func scanDivs(page []byte, start int) ([]storeDiv, int, error) {
+	var divs []storeDiv
+	for pos := start; ; {
+		rest := bytes.TrimLeft(page[pos:], " \t\r\n")
+		pos = len(page) - len(rest)
+		if bytes.HasPrefix(rest, divEnd) {
+			return divs, pos, nil
+		}
+
+		// The text of a tiddler is escaped, so the first closing tag after
+		// the start of its div is the end of it.
+		tagEnd := bytes.IndexByte(rest, '>')
+		n := bytes.Index(rest, divEnd)
+		if !bytes.HasPrefix(rest, divStart) || tagEnd < 0 || n < 0 {
+			return nil, 0, ErrBadStoreArea
+		}
+		d := storeDiv{start: pos, end: pos + n + len(divEnd)}
+		if m := titleAttr.FindSubmatch(rest[:tagEnd]); m != nil {
+			d.title = html.UnescapeString(string(m[1]))
+		}
+		divs = append(divs, d)
+		pos = d.end
+	}
+}
@@ -0,0 +1,123 @@
+package tiddlywiki
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testPage is a single-file wiki as TiddlyWiki 5.1 saves it, cut down to its
+// store area.
+const testPage = `<html><body>
+<div id="storeArea" style="display:none;">
+<div created="20210131120000000" tags="one [[two words]]" title="Notes">
+<pre>a &lt;b&gt; &amp; &quot;c&quot;</pre>
+</div>
+<div title="$:/config/tiddlyweb/host">
+<pre>$protocol$//$host$/</pre>
+</div>
+</div>
+</body></html>`
+
+func TestTiddlers(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want []map[string]string
+	}{
+		{
+			"div store area",
+			testPage,
+			[]map[string]string{
+				{"created": "20210131120000000", "tags": "one [[two words]]", "title": "Notes", "text": `a <b> & "c"`},
+				{"title": "$:/config/tiddlyweb/host", "text": "$protocol$//$host$/"},
+			},
+		},
+		{
+			"json store area",
+			`<script class="tiddlywiki-tiddler-store" type="application/json">[{"title":"A","text":"a"},{"title":"B","tags":["x","y z"]}]</script>`,
+			[]map[string]string{{"title": "A", "text": "a"}, {"title": "B", "tags": "x [[y z]]"}},
+		},
+		{
+			"the last of a title",
+			`<script class="tiddlywiki-tiddler-store" type="application/json">[{"title":"A","text":"1"},{"title":"B"},{"title":"A","text":"2"}]</script>`,
+			[]map[string]string{{"title": "A", "text": "2"}, {"title": "B"}},
+		},
+		{
+			"classic",
+			`<div id="storeArea"><div title="Old" modifier="me">old text</div></div>`,
+			[]map[string]string{{"title": "Old", "modifier": "me", "type": "text/x-tiddlywiki", "text": "old text"}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := Tiddlers([]byte(tt.page))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Tiddlers = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTiddlersErrors(t *testing.T) {
+	tests := []struct {
+		page string
+		err  error
+	}{
+		{"<html></html>", ErrNoStoreArea},
+		{`<script class="tiddlywiki-tiddler-store">[{"title":`, ErrBadStoreArea},
+		{`<script class="tiddlywiki-tiddler-store">not json</script>`, ErrBadStoreArea},
+		{`<div id="storeArea" style="display:none;"><p>`, ErrBadStoreArea},
+	}
+	for _, tt := range tests {
+		if _, err := Tiddlers([]byte(tt.page)); !errors.Is(err, tt.err) {
+			t.Errorf("Tiddlers(%q) error = %v, want %v", tt.page, err, tt.err)
+		}
+	}
+}
+
+// TestReplaceTiddlers makes sure that the tiddlers written to a store area are
+// read back as they were.
+func TestReplaceTiddlers(t *testing.T) {
+	tiddlers := []map[string]string{
+		{"title": "Notes", "text": "new <text> & 'quotes'", "caption": `"quoted"`},
+		{"title": "Added", "text": ""},
+	}
+	page, err := ReplaceTiddlers([]byte(testPage), tiddlers, "$:/config/tiddlyweb/host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Tiddlers(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, tiddlers) {
+		t.Errorf("Tiddlers = %q, want %q", got, tiddlers)
+	}
+
+	if _, err := ReplaceTiddlers([]byte("<html></html>"), tiddlers); err != ErrNoStoreArea {
+		t.Errorf("ReplaceTiddlers without a store area error = %v, want %v", err, ErrNoStoreArea)
+	}
+}
+
+func TestImportable(t *testing.T) {
+	tests := map[string]bool{
+		"Notes":                           true,
+		"$:/config/Thing":                 true,
+		"$:/plugins/someone/plugin":       true,
+		"":                                false,
+		"$:/core":                         false,
+		"$:/StoryList":                    false,
+		"$:/plugins/tiddlywiki/tiddlyweb": false,
+		"$:/boot/boot.js":                 false,
+		"$:/state/popup/x":                false,
+		"$:/temp/search":                  false,
+	}
+	for title, want := range tests {
+		if got := Importable(title); got != want {
+			t.Errorf("Importable(%q) = %v, want %v", title, got, want)
+		}
+	}
+}
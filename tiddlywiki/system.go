@@ -0,0 +1,43 @@
+package tiddlywiki
+
+import "strings"
+
+// IsSystemTiddler reports whether a tiddler is one of the system tiddlers that
+// the browser already has and so are left out of the list of tiddlers.
+func IsSystemTiddler(title string) bool {
+	/*
+		These tiddlers are listed when you click on System under More in the sidebar:
+		   $:/boot/boot.css
+		   $:/boot/boot.js
+		   $:/boot/bootprefix.js
+		   $:/core
+		   $:/HistoryList
+		   $:/isEncrypted
+		   $:/library/sjcl.js
+		   $:/plugins/tiddlywiki/tiddlyweb
+		   $:/state/tab-1749438307
+		   $:/state/tab/moresidebar-1850697562
+		   $:/state/tab/sidebar--595412856
+		   $:/status/IsAnonymous
+		   $:/status/IsLoggedIn
+		   $:/status/IsReadOnly
+		   $:/status/RequireReloadDueToPluginChange
+		   $:/status/UserName
+		   $:/StoryList
+		   $:/temp/info-plugin
+		   $:/themes/tiddlywiki/snowwhite
+		   $:/themes/tiddlywiki/vanilla
+		But if we don't return what's under /themes/ any changes we make to the
+		appearance will get lost on the next refresh.
+	*/
+	return strings.HasPrefix(title, "$:/boot/") ||
+		strings.HasPrefix(title, "$:/core") ||
+		strings.HasPrefix(title, "$:/HistoryList") ||
+		strings.HasPrefix(title, "$:/isEncrypted") ||
+		strings.HasPrefix(title, "$:/library/") ||
+		strings.HasPrefix(title, "$:/plugins/") ||
+		strings.HasPrefix(title, "$:/state/") ||
+		strings.HasPrefix(title, "$:/status/") ||
+		strings.HasPrefix(title, "$:/StoryList") ||
+		strings.HasPrefix(title, "$:/temp/")
+}
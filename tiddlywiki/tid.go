@@ -0,0 +1,129 @@
+package tiddlywiki
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var blankLine = regexp.MustCompile(`\r?\n\r?\n`)
+
+// ParseTid parses the contents of a .tid file: a block of "name: value" header
+// lines, a blank line and then the text.
+func ParseTid(data []byte) map[string]string {
+	parts := blankLine.Split(string(data), 2)
+	fields := ParseFieldBlock(parts[0])
+	if len(parts) == 2 {
+		fields["text"] = parts[1]
+	}
+	return fields
+}
+
+// ParseFieldBlock parses "name: value" lines, as found at the top of .tid files
+// and in .meta files.
+func ParseFieldBlock(s string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(s, "\n") {
+		p := strings.Index(line, ":")
+		if p < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:p])
+		if name != "" {
+			fields[name] = strings.TrimSpace(line[p+1:])
+		}
+	}
+	return fields
+}
+
+// MarshalTid returns the contents of a .tid file for a tiddler.
+func MarshalTid(fields map[string]string) []byte {
+	return []byte(MarshalFieldBlock(fields) + "\n" + fields["text"])
+}
+
+// MarshalFile returns the contents of the file that Node.js TiddlyWiki would
+// save a tiddler in, and the file's extension: a .tid file, a .json file when
+// its fields don't fit in a .tid header, or for the types that have a file
+// extension, a file of that type. Those last also need a .meta file, whose
+// contents are returned as meta.
+func MarshalFile(fields map[string]string) (ext string, data, meta []byte, err error) {
+	typeExt, binary := FileExtension(fields["type"])
+	switch {
+	case !SafeForTid(fields):
+		data, err = MarshalJSONTiddlers([]map[string]string{fields})
+		return ".json", data, nil, err
+	case typeExt == "":
+		return ".tid", MarshalTid(fields), nil, nil
+	}
+
+	data = []byte(fields["text"])
+	if binary {
+		if data, err = base64.StdEncoding.DecodeString(fields["text"]); err != nil {
+			return "", nil, nil, fmt.Errorf("decode %s: %w", fields["title"], err)
+		}
+	}
+	return typeExt, data, []byte(MarshalFieldBlock(fields)), nil
+}
+
+// MarshalFieldBlock writes every field apart from the text as a "name: value"
+// line, in alphabetical order as TiddlyWiki does.
+func MarshalFieldBlock(fields map[string]string) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if name != "text" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ": " + fields[name] + "\n")
+	}
+	return b.String()
+}
+
+// SafeForTid reports whether the fields can be written as .tid header lines
+// without losing anything, that is none of them apart from the text contain line
+// breaks or start or end with white space.
+func SafeForTid(fields map[string]string) bool {
+	for name, value := range fields {
+		if name == "text" {
+			continue
+		}
+		if strings.ContainsAny(name, ":\r\n") || strings.TrimSpace(name) != name {
+			return false
+		}
+		if strings.ContainsAny(value, "\r\n") || strings.TrimSpace(value) != value {
+			return false
+		}
+	}
+	return true
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[<>~:"|?*^/\\\x00-\x1f]`)
+
+// Filename turns a title into the base name, without extension, that Node.js
+// TiddlyWiki would use for the tiddler's file. $:/StoryList, for example,
+// becomes $__StoryList.
+func Filename(title string) string {
+	name := unsafeFilenameChars.ReplaceAllString(title, "_")
+	// Don't let the filename start with a dot because such files are hidden.
+	if strings.HasPrefix(name, ".") {
+		name = "_" + name[1:]
+	}
+	if len(name) > 200 {
+		name = name[:200]
+		// Don't leave half of a multi-byte character at the end.
+		for !utf8.ValidString(name) {
+			name = name[:len(name)-1]
+		}
+	}
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
@@ -0,0 +1,124 @@
+// Package tiddlywiki converts tiddlers between the formats that TiddlyWiki
+// uses: the TiddlyWeb JSON kept in app.Tiddler.Meta, the plain field strings
+// of the TiddlyWiki core, the files of a Node.js TiddlyWiki wiki folder and the
+// store area of a single-file wiki.
+package tiddlywiki
+
+import (
+	"encoding/json"
+	"strconv"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+// webFields are the keys of the TiddlyWeb JSON format that describe where a
+// tiddler is kept rather than the tiddler itself.
+var webFields = map[string]bool{
+	"bag":         true,
+	"permissions": true,
+	"recipe":      true,
+	"revision":    true,
+	"uri":         true,
+}
+
+// topFields are the fields that the TiddlyWeb JSON format keeps at the top
+// level. All other fields go in the "fields" object.
+var topFields = map[string]bool{
+	"created":  true,
+	"creator":  true,
+	"modified": true,
+	"modifier": true,
+	"tags":     true,
+	"title":    true,
+	"type":     true,
+}
+
+// Fields returns the fields of a tiddler as TiddlyWiki strings, with lists
+// such as tags stringified and the text included.
+func Fields(t app.Tiddler) (map[string]string, error) {
+	var js map[string]interface{}
+	if err := json.Unmarshal([]byte(t.Meta), &js); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	for k, v := range js {
+		switch {
+		case k == "fields":
+			if extra, ok := v.(map[string]interface{}); ok {
+				for name, value := range extra {
+					fields[name] = fieldString(value)
+				}
+			}
+		case webFields[k], k == "text":
+		default:
+			fields[k] = fieldString(v)
+		}
+	}
+	if t.Text != "" {
+		fields["text"] = t.Text
+	}
+	return fields, nil
+}
+
+// NewTiddler builds a tiddler in the TiddlyWeb format from TiddlyWiki field
+// strings. The bag is left out of the meta data when it is empty.
+func NewTiddler(fields map[string]string, bag string, rev int) (app.Tiddler, error) {
+	js := map[string]interface{}{}
+	extra := map[string]string{}
+	for k, v := range fields {
+		switch {
+		case k == "text", webFields[k]:
+		case k == "tags":
+			tags := ParseStringList(v)
+			if tags == nil {
+				tags = []string{}
+			}
+			js[k] = tags
+		case topFields[k]:
+			js[k] = v
+		default:
+			extra[k] = v
+		}
+	}
+	if len(extra) > 0 {
+		js["fields"] = extra
+	}
+	if bag != "" {
+		js["bag"] = bag
+	}
+	js["revision"] = rev
+
+	meta, err := json.Marshal(js)
+	if err != nil {
+		return app.Tiddler{}, err
+	}
+	return app.Tiddler{
+		Rev:      rev,
+		Meta:     string(meta),
+		Text:     fields["text"],
+		IsSystem: IsSystemTiddler(fields["title"]),
+	}, nil
+}
+
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fieldString(item))
+		}
+		return StringifyList(items)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
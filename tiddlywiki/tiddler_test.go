@@ -0,0 +1,197 @@
+package tiddlywiki
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	app "github.com/etitcombe/tiddlypom"
+)
+
+func TestParseStringList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"one", []string{"one"}},
+		{"one [[two words]] three", []string{"one", "two words", "three"}},
+		{"  one\ttwo\n", []string{"one", "two"}},
+		{"one one [[one]]", []string{"one"}},
+		{"[[]] x", []string{"", "x"}},
+		{"[[unclosed", []string{"[[unclosed"}},
+	}
+	for _, tt := range tests {
+		if got := ParseStringList(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseStringList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStringifyList(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"one", "two words", ""}, "one [[two words]] [[]]"},
+		{[]string{"tab\there"}, "[[tab\there]]"},
+	}
+	for _, tt := range tests {
+		got := StringifyList(tt.in)
+		if got != tt.want {
+			t.Errorf("StringifyList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if back := ParseStringList(got); len(tt.in) > 0 && !reflect.DeepEqual(back, tt.in) {
+			t.Errorf("ParseStringList(%q) = %q, want %q", got, back, tt.in)
+		}
+	}
+}
+
+func TestTid(t *testing.T) {
+	fields := map[string]string{
+		"title":    "Notes",
+		"tags":     "one [[two words]]",
+		"modified": "20210131120000000",
+		"text":     "first line\n\nsecond paragraph",
+	}
+	data := MarshalTid(fields)
+	want := "modified: 20210131120000000\ntags: one [[two words]]\ntitle: Notes\n\nfirst line\n\nsecond paragraph"
+	if string(data) != want {
+		t.Errorf("MarshalTid = %q, want %q", data, want)
+	}
+	if got := ParseTid(data); !reflect.DeepEqual(got, fields) {
+		t.Errorf("ParseTid = %q, want %q", got, fields)
+	}
+	if got := ParseTid([]byte("title: CRLF\r\n\r\ntext")); got["title"] != "CRLF" || got["text"] != "text" {
+		t.Errorf("ParseTid with CRLF = %q", got)
+	}
+	if got := ParseTid([]byte("title: No text")); got["title"] != "No text" || got["text"] != "" {
+		t.Errorf("ParseTid without text = %q", got)
+	}
+}
+
+func TestSafeForTid(t *testing.T) {
+	tests := []struct {
+		fields map[string]string
+		want   bool
+	}{
+		{map[string]string{"title": "x", "text": " leading\nand breaks "}, true},
+		{map[string]string{"title": "two\nlines"}, false},
+		{map[string]string{"title": " padded"}, false},
+		{map[string]string{"odd:name": "x"}, false},
+		{map[string]string{" name": "x"}, false},
+	}
+	for _, tt := range tests {
+		if got := SafeForTid(tt.fields); got != tt.want {
+			t.Errorf("SafeForTid(%q) = %v, want %v", tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestMarshalFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   map[string]string
+		ext      string
+		data     string
+		withMeta bool
+	}{
+		{"tid", map[string]string{"title": "A", "text": "a"}, ".tid", "title: A\n\na", false},
+		{"json", map[string]string{"title": "A\nB", "text": "a"}, ".json", "", false},
+		{"image", map[string]string{"title": "P", "type": "image/png", "text": "aGk="}, ".png", "hi", true},
+		{"text type", map[string]string{"title": "C", "type": "text/css", "text": "p {}"}, ".css", "p {}", true},
+	}
+	for _, tt := range tests {
+		ext, data, meta, err := MarshalFile(tt.fields)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if ext != tt.ext || tt.data != "" && string(data) != tt.data || (meta != nil) != tt.withMeta {
+			t.Errorf("%s: MarshalFile = %q, %q, %q", tt.name, ext, data, meta)
+		}
+	}
+	if _, _, _, err := MarshalFile(map[string]string{"title": "P", "type": "image/png", "text": "not base64!"}); err == nil {
+		t.Error("MarshalFile with bad base64 succeeded")
+	}
+}
+
+func TestFilename(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Notes", "Notes"},
+		{"$:/StoryList", "$__StoryList"},
+		{"a/b\\c?d*e", "a_b_c_d_e"},
+		{".hidden", "_hidden"},
+		{"", "_"},
+		{strings.Repeat("é", 150), strings.Repeat("é", 100)},
+	}
+	for _, tt := range tests {
+		if got := Filename(tt.title); got != tt.want {
+			t.Errorf("Filename(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestFieldsRoundTrip(t *testing.T) {
+	fields := map[string]string{
+		"title":    "$:/StoryList",
+		"tags":     "one [[two words]]",
+		"type":     "text/vnd.tiddlywiki",
+		"modified": "20210131120000000",
+		"color":    "red",
+		"text":     "the text",
+	}
+	tid, err := NewTiddler(fields, "default", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tid.Rev != 3 || tid.Text != "the text" || !tid.IsSystem {
+		t.Errorf("NewTiddler = %+v", tid)
+	}
+	for _, want := range []string{`"bag":"default"`, `"revision":3`, `"fields":{"color":"red"}`, `"tags":["one","two words"]`} {
+		if !strings.Contains(tid.Meta, want) {
+			t.Errorf("NewTiddler meta %s doesn't have %s", tid.Meta, want)
+		}
+	}
+
+	got, err := Fields(tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, fields) {
+		t.Errorf("Fields = %q, want %q", got, fields)
+	}
+}
+
+func TestFieldsValues(t *testing.T) {
+	meta := `{"title":"T","list":["a","b c"],"count":2.5,"done":true,"none":null,"obj":{"k":1},"uri":"x","text":"ignored"}`
+	got, err := Fields(app.Tiddler{Meta: meta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"title": "T", "list": "a [[b c]]", "count": "2.5", "done": "true", "none": "", "obj": `{"k":1}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Fields = %q, want %q", got, want)
+	}
+	if _, err := Fields(app.Tiddler{Meta: "not json"}); err == nil {
+		t.Error("Fields of bad meta data succeeded")
+	}
+}
+
+func TestIsSystemTiddler(t *testing.T) {
+	for title, want := range map[string]bool{"$:/core": true, "$:/StoryList": true, "$:/config/Thing": false, "Notes": false} {
+		if got := IsSystemTiddler(title); got != want {
+			t.Errorf("IsSystemTiddler(%q) = %v, want %v", title, got, want)
+		}
+	}
+}
+
+func ExampleParseStringList() {
+	fmt.Printf("%q\n", ParseStringList("Meeting [[Big Plans]] Meeting"))
+	// Output: ["Meeting" "Big Plans"]
+}
@@ -0,0 +1,81 @@
+package tiddlywiki
+
+import "strings"
+
+// contentType describes how tiddlers of a content type are kept in files.
+type contentType struct {
+	ext    string
+	binary bool
+}
+
+// contentTypes are the content types that Node.js TiddlyWiki saves as their
+// own files with a .meta file alongside. Binary files are held in tiddlers as
+// base64.
+var contentTypes = map[string]contentType{
+	"application/javascript": {".js", false},
+	"application/json":       {".json", false},
+	"application/pdf":        {".pdf", true},
+	"application/zip":        {".zip", true},
+	"audio/mp3":              {".mp3", true},
+	"audio/mp4":              {".mp4", true},
+	"audio/mpeg":             {".mp3", true},
+	"audio/ogg":              {".ogg", true},
+	"audio/wav":              {".wav", true},
+	"font/woff":              {".woff", true},
+	"font/woff2":             {".woff2", true},
+	"image/gif":              {".gif", true},
+	"image/heic":             {".heic", true},
+	"image/jpeg":             {".jpg", true},
+	"image/png":              {".png", true},
+	"image/svg+xml":          {".svg", false},
+	"image/webp":             {".webp", true},
+	"image/x-icon":           {".ico", true},
+	"text/css":               {".css", false},
+	"text/csv":               {".csv", false},
+	"text/html":              {".html", false},
+	"text/plain":             {".txt", false},
+	"text/x-markdown":        {".md", false},
+	"video/mp4":              {".mp4", true},
+	"video/ogg":              {".ogv", true},
+	"video/webm":             {".webm", true},
+}
+
+// extensionTypes maps file extensions back to content types where the
+// mapping above is ambiguous or incomplete.
+var extensionTypes = map[string]string{
+	".jpeg": "image/jpeg",
+	".mp3":  "audio/mpeg",
+	".mp4":  "video/mp4",
+	".tid":  "application/x-tiddler",
+}
+
+// FileExtension returns the extension of the file that a tiddler of type typ
+// is saved in, and whether its text is base64 encoded binary data. The
+// extension is empty for types that are saved as .tid files.
+func FileExtension(typ string) (ext string, binary bool) {
+	ct, ok := contentTypes[typ]
+	if !ok {
+		return "", false
+	}
+	return ct.ext, ct.binary
+}
+
+// TypeForExtension returns the content type of files with the extension ext,
+// or the empty string when it is not known.
+func TypeForExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if typ, ok := extensionTypes[ext]; ok {
+		return typ
+	}
+	for typ, ct := range contentTypes {
+		if ct.ext == ext {
+			return typ
+		}
+	}
+	return ""
+}
+
+// IsBinaryType reports whether tiddlers of type typ hold base64 encoded data.
+func IsBinaryType(typ string) bool {
+	return contentTypes[typ].binary
+}
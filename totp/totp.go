@@ -0,0 +1,133 @@
+// Package totp implements the time-based one-time passwords of RFC 6238, as
+// shown by authenticator apps, and the recovery codes that stand in for them
+// when the app is lost.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/etitcombe/tiddlypom/rand"
+)
+
+const (
+	// Digits is the length of a code, and Period how long each one lasts.
+	Digits = 6
+	Period = 30 * time.Second
+
+	// skew is how many periods either side of now a code is accepted for,
+	// to allow for clocks that are a little off.
+	skew = 1
+
+	secretBytes = 20
+
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates the secret that an app and the server share, in base32
+// as apps expect it.
+func NewSecret() (string, error) {
+	b, err := rand.Bytes(secretBytes)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the otpauth URI that an app reads, usually from a QR code, to be
+// set up with secret for account.
+func URI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period/time.Second)))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// Code returns the code for secret at t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	return codeFor(key, step(t)), nil
+}
+
+// Validate reports whether code is the code for secret at t, give or take a
+// period, and returns the step that it is for. A code should only be accepted
+// once, so a step that has been used shouldn't be accepted again.
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return 0, false
+	}
+	code = strings.TrimSpace(code)
+	now := step(t)
+	for s := now - skew; s <= now+skew; s++ {
+		if subtle.ConstantTimeCompare([]byte(codeFor(key, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+func step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// codeFor is the HOTP of RFC 4226 for the counter s.
+func codeFor(key []byte, s int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(s))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, n%mod)
+}
+
+// RecoveryCodes generates a set of codes that can each be used once instead
+// of a code from the app.
+func RecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b, err := rand.Bytes(recoveryCodeLength)
+		if err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(encoding.EncodeToString(b))[:recoveryCodeLength]
+		codes = append(codes, c[:recoveryCodeLength/2]+"-"+c[recoveryCodeLength/2:])
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode puts a recovery code as it was typed into the form it
+// is kept in, without the spaces and dashes and in lower case.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
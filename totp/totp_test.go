@@ -0,0 +1,86 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the test vectors in RFC 6238, in base32.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// TestCode checks the SHA-1 test vectors of RFC 6238, whose eight digit codes
+// end in the six digits given here.
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+		step   int64
+	}{
+		{"now", rfcSecret, "050471", true, 37037037},
+		{"spaces", rfcSecret, " 050471 ", true, 37037037},
+		{"lower case secret", strings.ToLower(rfcSecret), "050471", true, 37037037},
+		{"previous period", rfcSecret, "081804", true, 37037036},
+		{"wrong", rfcSecret, "123456", false, 0},
+		{"too old", rfcSecret, "287082", false, 0},
+		{"bad secret", "not base32!", "050471", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.code, now)
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.code, step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := RecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != recoveryCodeLength+1 || c[recoveryCodeLength/2] != '-' {
+			t.Errorf("code %q isn't two halves joined by a dash", c)
+		}
+		n := NormalizeRecoveryCode(strings.ToUpper(c))
+		if n != strings.Replace(c, "-", "", 1) {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q", strings.ToUpper(c), n)
+		}
+		if seen[n] {
+			t.Errorf("code %q is repeated", c)
+		}
+		seen[n] = true
+	}
+}